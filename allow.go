@@ -0,0 +1,43 @@
+package nvelope
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/muir/nject"
+)
+
+// HandleOptions is a wrapper that answers OPTIONS requests on its own:
+// it sets the Allow header to the given methods (plus OPTIONS) and
+// writes a 204 with no body.  Other requests pass through untouched.
+// Routers only send a request to an endpoint if the method matches
+// so the endpoint must also be registered for OPTIONS.  It is meant
+// to be used downstream from a response encoder.
+//
+//	router.HandleFunc("/things/{id}", service.Bind(
+//		nvelope.EncodeJSON,
+//		nvelope.HandleOptions("GET", "PUT", "DELETE"),
+//		...
+//	)).Methods("GET", "PUT", "DELETE", "OPTIONS")
+func HandleOptions(methods ...string) nject.Provider {
+	allowed := make([]string, 0, len(methods)+1)
+	seen := make(map[string]bool)
+	for _, method := range append(methods, http.MethodOptions) {
+		method = strings.ToUpper(method)
+		if seen[method] {
+			continue
+		}
+		seen[method] = true
+		allowed = append(allowed, method)
+	}
+	allow := strings.Join(allowed, ", ")
+	return nject.Provide("handle-options",
+		func(inner func() (Response, error), w *DeferredWriter, r *http.Request) (Response, error) {
+			if r.Method != http.MethodOptions {
+				return inner()
+			}
+			w.Header().Set("Allow", allow)
+			w.WriteHeader(http.StatusNoContent)
+			return nil, w.Flush()
+		})
+}
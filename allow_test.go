@@ -0,0 +1,39 @@
+package nvelope_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/muir/nvelope"
+	"github.com/muir/nvelope/nvelopetest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleOptions(t *testing.T) {
+	var called int
+	h, err := nvelopetest.Handler(
+		nvelope.NoLogger,
+		nvelope.InjectWriter,
+		nvelope.EncodeJSON,
+		nvelope.HandleOptions("get", "PUT", "GET"),
+		func() (nvelope.Response, error) {
+			called++
+			return "hi", nil
+		},
+	)
+	require.NoError(t, err)
+
+	res := nvelopetest.Do(h, http.MethodOptions, "/x")
+	assert.Equal(t, http.StatusNoContent, res.Status)
+	assert.Equal(t, "GET, PUT, OPTIONS", res.Header.Get("Allow"))
+	assert.Empty(t, res.Body)
+	assert.Equal(t, 0, called, "endpoint is skipped")
+
+	res = nvelopetest.Do(h, http.MethodGet, "/x")
+	assert.Equal(t, http.StatusOK, res.Status)
+	assert.Empty(t, res.Header.Get("Allow"))
+	assert.Equal(t, `"hi"`, res.Body)
+	assert.Equal(t, 1, called)
+}
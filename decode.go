@@ -2,15 +2,23 @@ package nvelope
 
 import (
 	"bytes"
+	"compress/gzip"
 	"encoding"
+	"encoding/base64"
 	"encoding/json"
 	"encoding/xml"
+	"flag"
 	"io"
+	"math"
 	"net/http"
 	"net/url"
 	"reflect"
-	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
 
 	"github.com/muir/nject"
 	"github.com/muir/reflectutils"
@@ -35,16 +43,136 @@ func readBody(r *http.Request) (Body, nject.TerminalError) {
 	return Body(body), err
 }
 
+// BodyStatus records what GenerateDecoder did with the request
+// body.  It is provided by InjectBodyStatus.  Middleware that is
+// before InjectBodyStatus in the injection chain can check it after
+// calling inner() to find out if the body was decoded.  ReadBody
+// leaves r.Body readable, so middleware that needs the raw bytes,
+// like signature verification, can still read them.
+type BodyStatus struct {
+	// Decoded is true if a non-empty body was decoded into
+	// a model without error
+	Decoded bool
+}
+
+// InjectBodyStatus provides a *BodyStatus that GenerateDecoder
+// will update.  It must be before GenerateDecoder in the
+// injection chain.
+var InjectBodyStatus = nject.Provide("body-status", func() *BodyStatus {
+	return &BodyStatus{}
+})
+
+// ReadBodyNoRewrap is like ReadBody but it does not replace r.Body
+// with a copy of what was read.  That saves memory for large bodies
+// but it means that r.Body is drained: it is replaced with http.NoBody
+// so anything downstream that reads r.Body directly will find it
+// empty.  Only use it when the Body type is the only way the request
+// body is consumed.
+var ReadBodyNoRewrap = nject.Provide("read-body-no-rewrap", readBodyNoRewrap)
+
+func readBodyNoRewrap(r *http.Request) (Body, nject.TerminalError) {
+	// nolint:errcheck
+	defer r.Body.Close()
+	body, err := io.ReadAll(r.Body)
+	r.Body = http.NoBody
+	return Body(body), err
+}
+
+// BodyReaderOpt are functional arguments for MakeBodyReader
+type BodyReaderOpt func(*bodyReaderOptions)
+
+type bodyReaderOptions struct {
+	checkContentLength bool
+}
+
+// CheckContentLength true causes the body reader to compare the number
+// of bytes read with the Content-Length of the request (if it is
+// known) and reject the request with a 400 if they differ.  A body that
+// ends early (io.ErrUnexpectedEOF) is also rejected with a 400.  The
+// net/http server already limits reads to the Content-Length, so this
+// catches truncated uploads and bugs in middleware that replaces
+// r.Body without updating r.ContentLength.
+func CheckContentLength(b bool) BodyReaderOpt {
+	return func(o *bodyReaderOptions) {
+		o.checkContentLength = b
+	}
+}
+
+// MakeBodyReader creates a provider like ReadBody that can be
+// adjusted with options.
+//
+//	nvelope.MakeBodyReader(nvelope.CheckContentLength(true))
+func MakeBodyReader(opts ...BodyReaderOpt) nject.Provider {
+	var o bodyReaderOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return nject.Provide("read-body-with-options", func(r *http.Request) (Body, nject.TerminalError) {
+		body, err := readBody(r)
+		if !o.checkContentLength {
+			return body, err
+		}
+		if err != nil {
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil, ReturnCode(errors.Wrap(err, "request body is truncated"), http.StatusBadRequest)
+			}
+			return body, err
+		}
+		if r.ContentLength >= 0 && int64(len(body)) != r.ContentLength {
+			return nil, ReturnCode(errors.Errorf("request body is %d bytes but Content-Length is %d",
+				len(body), r.ContentLength), http.StatusBadRequest)
+		}
+		return body, nil
+	})
+}
+
 // Decoder is the signature for decoders: take bytes and
 // a pointer to something and deserialize it.
 type Decoder func([]byte, interface{}) error
 
+// TagDecoder is like Decoder but it is also given the parsed
+// tags of the field being decoded so that it can adapt its
+// decoding based on options like "layout=".
+type TagDecoder func(data []byte, target interface{}, tags Tags) error
+
 type eigo struct {
 	tag                          string
 	decoders                     map[string]Decoder
+	methodDecoders               map[string]map[string]Decoder
+	tagDecoders                  map[string]TagDecoder
 	defaultContentType           string
 	rejectUnknownQueryParameters bool
+	unknownQueryParameterHandler func(key string, values []string) error
+	detectFormBodies             bool
+	discriminatorField           string
+	discriminatorTypes           map[string]reflect.Type
 	pathVarFunction              interface{}
+	logDecodeErrors              bool
+	cookieCodec                  func(name, raw string) (string, error)
+	postProcessors               []func(interface{}, *http.Request) error
+	modelValidators              []func(interface{}) error
+	unprocessableEntity          bool
+	decodeErrorStatus            int
+	collectAllErrors             bool
+	maxDecompressedSize          int64
+	urlDecodePathVars            bool
+	normalizePathVars            bool
+	listKnownQueryParameters     bool
+	dottedQueryKeys              bool
+	validateTags                 bool
+	trustForwardedHeaders        bool
+	strictMapPairs               bool
+	jwtKeyFunc                   JWTKeyFunc
+	trustUnverifiedJWT           bool
+	decodeEcho                   string
+	maxQueryParameters           int
+	maxHeaders                   int
+	jsonSchema                   string
+	jsonSchemaValidator          JSONSchemaValidator
+	modelFactories               map[reflect.Type]func() interface{}
+	// fillerCache is per GenerateDecoder value because options like
+	// decoders are functions that cannot be compared
+	fillerCache *sync.Map // reflect.Type -> cachedFillers
 }
 
 // DecodeInputsGeneratorOpt are functional arguments for
@@ -56,6 +184,14 @@ type DecodeInputsGeneratorOpt func(*eigo)
 // is used in the requet, then the value of that header will be
 // used to pick a decoder.
 //
+// The content type can also be a pattern that matches a family of
+// media types: "application/*+json" matches any application media type
+// with a +json suffix, like "application/vnd.api+json"; "application/*"
+// matches any application media type; and "*/*" matches everything.
+// When looking for a decoder, an exact match is preferred, then a match
+// ignoring media type parameters (like "; charset=utf-8"), then a
+// suffix pattern, then a type pattern, and finally "*/*".
+//
 // When using a decoder, the body must be provided as an nvelope.Body
 // parameter. Use nvelope.ReadBody to do that.
 func WithDecoder(contentType string, decoder Decoder) DecodeInputsGeneratorOpt {
@@ -64,6 +200,43 @@ func WithDecoder(contentType string, decoder Decoder) DecodeInputsGeneratorOpt {
 	}
 }
 
+// WithMethodDecoder is like WithDecoder but the decoder is only used
+// for requests with the given HTTP method.  For example, PATCH requests
+// can accept JSON Merge Patch (RFC 7386) while PUT requests accept
+// plain JSON:
+//
+//	nvelope.WithDecoder("application/json", json.Unmarshal),
+//	nvelope.WithMethodDecoder("PATCH", "application/merge-patch+json", mergePatchDecoder),
+//
+// Decoders registered for the request's method are searched first, in
+// the order described for WithDecoder, and then the decoders registered
+// with WithDecoder.
+func WithMethodDecoder(method string, contentType string, decoder Decoder) DecodeInputsGeneratorOpt {
+	return func(o *eigo) {
+		method = strings.ToUpper(method)
+		if o.methodDecoders == nil {
+			o.methodDecoders = make(map[string]map[string]Decoder)
+		}
+		if o.methodDecoders[method] == nil {
+			o.methodDecoders[method] = make(map[string]Decoder)
+		}
+		o.methodDecoders[method][contentType] = decoder
+	}
+}
+
+// WithTagDecoder registers a decoder for fields tagged with a matching
+// "content=" option.  Unlike decoders registered with WithDecoder, the
+// decoder is given the parsed tags of the field.  For example, a decoder
+// for "content=application/date" could use the "layout=" option to pick
+// a time format.  Tag decoders are only used for "content=" fields, not
+// for decoding the request body.  A tag decoder takes precedence over
+// a decoder registered with WithDecoder for the same content type.
+func WithTagDecoder(contentType string, decoder TagDecoder) DecodeInputsGeneratorOpt {
+	return func(o *eigo) {
+		o.tagDecoders[contentType] = decoder
+	}
+}
+
 // WithDefaultContentType specifies which model decoder to use when
 // no "Content-Type" header was sent.
 func WithDefaultContentType(contentType string) DecodeInputsGeneratorOpt {
@@ -81,19 +254,274 @@ func WithDefaultContentType(contentType string) DecodeInputsGeneratorOpt {
 // This does not apply to query parameters with content=application/json
 // decodings.  If you want to disallow unknown tags for content= decodings,
 // define a custom decoder.
+//
+// Individual models can override this setting with a field tagged
+// "options,rejectUnknown=true" (or false).  The field is not filled so
+// the blank identifier works well:
+//
+//	type StrictModel struct {
+//		_     struct{} `nvelope:"options,rejectUnknown=true"`
+//		Limit int      `nvelope:"query,name=limit"`
+//	}
 func RejectUnknownQueryParameters(b bool) DecodeInputsGeneratorOpt {
 	return func(o *eigo) {
 		o.rejectUnknownQueryParameters = b
 	}
 }
 
-/* TODO
+// ListKnownQueryParameters true adds the names of the query parameters
+// that are supported to the error from RejectUnknownQueryParameters
+// to help clients fix their requests:
+//
+//	query parameter 'limt' not supported, supported parameters are: cursor, limit, q
+//
+// Deep object parameters are listed as "name[]".
+func ListKnownQueryParameters(b bool) DecodeInputsGeneratorOpt {
+	return func(o *eigo) {
+		o.listKnownQueryParameters = b
+	}
+}
+
+// DottedQueryKeys true allows clients to use dots instead of brackets
+// for deepObject=true query parameters: "filter.a=1" is treated like
+// "filter[a]=1".  Dotted keys can also reach into struct and map members
+// of deepObject structs: "filter.range.min=3" fills the Min member of
+// the Range member of the filter struct.
+//
+// Dotted keys are only split when there is no exact match.  A query
+// parameter or struct member whose name contains a dot is filled directly
+// when the key matches its name.  Otherwise the key is split at the
+// first dot.  Map keys are not split further: "labels.a.b=x" fills
+// the key "a.b" of a labels map.  Mixing "filter[a]" and "filter.a" in
+// the same request for the same member is not supported.
+func DottedQueryKeys(b bool) DecodeInputsGeneratorOpt {
+	return func(o *eigo) {
+		o.dottedQueryKeys = b
+	}
+}
+
+// WithUnknownQueryParameterHandler provides a function that is called
+// for each query parameter that does not match any field in the model.
+// It can ignore, log, or collect the parameter.  If it returns an error,
+// the request is rejected with a 400 unless the error already has a
+// return code (see ReturnCode).  When a handler is provided, it is used
+// instead of RejectUnknownQueryParameters for top-level parameters.
+// Unknown keys within a filled struct are still controlled by
+// RejectUnknownQueryParameters.
+func WithUnknownQueryParameterHandler(handler func(key string, values []string) error) DecodeInputsGeneratorOpt {
+	return func(o *eigo) {
+		o.unknownQueryParameterHandler = handler
+	}
+}
+
+// DetectFormBodies true causes request bodies that look like
+// application/x-www-form-urlencoded data to be used for filling
+// form=true and formOnly=true query parameters even when the
+// Content-Type header is missing or says something else.  A body looks
+// like form data if it is non-empty printable ASCII with no spaces or
+// quotes, contains an "=", does not start with "{", "[", or "<", and
+// can be parsed as form data.
+func DetectFormBodies(b bool) DecodeInputsGeneratorOpt {
+	return func(o *eigo) {
+		o.detectFormBodies = b
+	}
+}
+
+// WithDiscriminator enables decoding request bodies into model fields
+// that are interfaces.  The body is first decoded into a
+// map[string]interface{} to find the value of the discriminator field.
+// That value selects the concrete type from types.  The body is then
+// decoded into a new value of that type which is assigned to the
+// interface field.  If the type does not implement the interface but a
+// pointer to the type does, then the pointer is assigned.  Types that
+// implement neither are ignored for that field.  This is the OpenAPI
+// discriminator pattern.
+//
+//	nvelope.WithDiscriminator("type", map[string]reflect.Type{
+//		"circle": reflect.TypeOf(Circle{}),
+//		"square": reflect.TypeOf(Square{}),
+//	})
+func WithDiscriminator(fieldName string, types map[string]reflect.Type) DecodeInputsGeneratorOpt {
+	return func(o *eigo) {
+		o.discriminatorField = fieldName
+		o.discriminatorTypes = types
+	}
+}
+
+// LogDecodeErrors true causes each error encountered while filling
+// a model to be logged at debug level before the request is rejected.
+// When true, a BasicLogger must be provided in the injection chain.
+func LogDecodeErrors(b bool) DecodeInputsGeneratorOpt {
+	return func(o *eigo) {
+		o.logDecodeErrors = b
+	}
+}
+
+// MaxQueryParameters rejects requests with more than n query
+// parameters with a 400.  Each value counts so "a=1&a=2" is two.
+// The limit is checked before the query is parsed.  It also applies
+// to application/x-www-form-urlencoded bodies that are used to fill
+// form=true parameters.  Zero, the default, means no limit.
+func MaxQueryParameters(n int) DecodeInputsGeneratorOpt {
+	return func(o *eigo) {
+		o.maxQueryParameters = n
+	}
+}
+
+// MaxHeaders rejects requests with more than n header values
+// with a 400.  Repeated headers count once per value.  Zero, the
+// default, means no limit.  The size of the headers is limited by
+// http.Server.MaxHeaderBytes.
+func MaxHeaders(n int) DecodeInputsGeneratorOpt {
+	return func(o *eigo) {
+		o.maxHeaders = n
+	}
+}
+
+// WithDecodeEcho helps clients see how their requests were
+// interpreted.  When a request has the named header (with any value),
+// the endpoint is not called.  Instead, the filled model is sent back
+// as JSON with a 200 status.  Requests that cannot be decoded get the
+// usual error response.  If more than one model is decoded, only the
+// first is sent.  A DeferredWriter (see InjectWriter) must be provided
+// in the injection chain.  This is meant for development: it should
+// not be enabled for endpoints whose models hold values that clients
+// should not see.
+func WithDecodeEcho(headerName string) DecodeInputsGeneratorOpt {
+	return func(o *eigo) {
+		o.decodeEcho = http.CanonicalHeaderKey(headerName)
+	}
+}
+
+// errDecodeEcho stops the injection chain after WithDecodeEcho has
+// sent the model
+var errDecodeEcho = errors.New("decoded model was sent instead of calling the endpoint")
+
+// echoDecoded sends the model for WithDecodeEcho
+func echoDecoded(w *DeferredWriter, model interface{}) error {
+	enc, err := json.Marshal(model)
+	if err != nil {
+		return errors.Wrap(err, "encode decoded model")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(enc)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		return errors.Wrap(err, "send decoded model")
+	}
+	return errDecodeEcho
+}
+
+// WithCookieCodec provides a function that transforms raw cookie values
+// before they are unpacked.  Use it to verify signed cookies or to
+// decode encoded cookies.  If the codec returns an error, the request
+// is rejected with a 400 unless the error already has a return code
+// (see ReturnCode and Unauthorized).
+func WithCookieCodec(codec func(name, raw string) (string, error)) DecodeInputsGeneratorOpt {
+	return func(o *eigo) {
+		o.cookieCodec = codec
+	}
+}
+
+// WithModelPostProcess adds a function that is called after a model
+// has been filled.  It receives a pointer to the model and can modify
+// it to normalize values or compute derived fields.  Multiple
+// functions are called in the order they were added.  If one returns
+// an error, the rest are skipped and the request is rejected with a 400
+// unless the error already has a return code (see ReturnCode).
+func WithModelPostProcess(f func(model interface{}, r *http.Request) error) DecodeInputsGeneratorOpt {
+	return func(o *eigo) {
+		o.postProcessors = append(o.postProcessors, f)
+	}
+}
+
+// WithModelFactory provides a function to create the initial value
+// for a model before it is filled from the request.  Without a factory,
+// models start out as zero values.  The model type can be specified
+// as either a struct type or a pointer to a struct type.  The factory can
+// return either the struct or a pointer to the struct.  It is called
+// once when the decoder is generated to check what it returns and then
+// once per request.  It must return a new value each time.  If it returns
+// nil or something else on a request, that request fails with a 500.
+//
+//	nvelope.WithModelFactory(reflect.TypeOf(MyModel{}), func() interface{} {
+//		return &MyModel{
+//			Labels: make(map[string]string),
+//		}
+//	})
+func WithModelFactory(modelType reflect.Type, factory func() interface{}) DecodeInputsGeneratorOpt {
+	return func(o *eigo) {
+		if modelType.Kind() == reflect.Ptr {
+			modelType = modelType.Elem()
+		}
+		if o.modelFactories == nil {
+			o.modelFactories = make(map[reflect.Type]func() interface{})
+		}
+		o.modelFactories[modelType] = factory
+	}
+}
+
+// WithModelValidator adds a function that is called with a pointer
+// to the model after it has been filled and after any WithModelPostProcess
+// functions have run.  Multiple validators can be added.  They are called
+// in the order they were added and the first error rejects the request.
+// Validation errors are returned as 400 unless they already have a
+// return code or UseUnprocessableEntity is set.
 func WithModelValidator(f func(interface{}) error) DecodeInputsGeneratorOpt {
 	return func(o *eigo) {
 		o.modelValidators = append(o.modelValidators, f)
 	}
 }
-*/
+
+// UseUnprocessableEntity distinguishes between requests that are
+// malformed and requests that are well-formed but have invalid
+// content.  When true:
+//
+//	*json.SyntaxError from decoding the body	400 Bad Request
+//	*json.UnmarshalTypeError from decoding the body	422 Unprocessable Entity
+//	errors from WithModelValidator			422 Unprocessable Entity
+//
+// All other decoding errors remain 400 (or the status set with
+// WithDecodeErrorStatus).  Errors that already have a return code
+// (see ReturnCode) keep it.  The default is false: everything is 400.
+func UseUnprocessableEntity(b bool) DecodeInputsGeneratorOpt {
+	return func(o *eigo) {
+		o.unprocessableEntity = b
+	}
+}
+
+// CollectAllErrors true causes the decoder to report all of the
+// problems with a request rather than just the first one.  The
+// errors are returned as a ValidationErrors which MakeResponseEncoder
+// turns into a structured JSON response.  WithModelPostProcess
+// functions and WithModelValidator functions are not called if
+// there were errors filling the model.
+func CollectAllErrors(b bool) DecodeInputsGeneratorOpt {
+	return func(o *eigo) {
+		o.collectAllErrors = b
+	}
+}
+
+// WithMaxDecompressedSize limits the size of decompressed values
+// for parameters tagged with "encoding=gzip".  Larger values are
+// rejected.  The default is one megabyte.
+func WithMaxDecompressedSize(n int64) DecodeInputsGeneratorOpt {
+	return func(o *eigo) {
+		o.maxDecompressedSize = n
+	}
+}
+
+// WithDecodeErrorStatus overrides the status code used for errors
+// from decoding the request.  The default is 400.  Errors that already
+// have a return code (see ReturnCode) keep it.
+func WithDecodeErrorStatus(code int) DecodeInputsGeneratorOpt {
+	return func(o *eigo) {
+		o.decodeErrorStatus = code
+	}
+}
 
 /* TODO
 func CallModelMethodIfPresent(method string) DecodeInputsGeneratorOpt {
@@ -131,6 +559,58 @@ func WithPathVarsFunction(pathVarFunction interface{}) DecodeInputsGeneratorOpt
 	}
 }
 
+// URLDecodePathVars true causes path variables to be unescaped
+// with url.PathUnescape before they are decoded.  Whether this is
+// needed depends on the router.  Most routers, including gorilla/mux
+// by default, match against the decoded path: a value like "a%2Fb" is
+// seen as "a/b" which then does not match a single path element.  For
+// IDs that can contain slashes, configure the router to match against
+// the encoded path (for gorilla/mux, use Router.UseEncodedPath()) and
+// then the router will provide "a%2Fb" as the variable value.  With
+// URLDecodePathVars, that is decoded to "a/b".  Do not use this with
+// routers that already decode variables or values with "%" in
+// them will be decoded twice.
+func URLDecodePathVars(b bool) DecodeInputsGeneratorOpt {
+	return func(o *eigo) {
+		o.urlDecodePathVars = b
+	}
+}
+
+// NormalizePathVars true causes path variables to be cleaned up before
+// they are decoded: leading and trailing spaces are trimmed and then
+// trailing slashes are removed.  This helps with hand-written routes
+// and routers that include a trailing "/" in the last variable.
+// When combined with URLDecodePathVars, normalization happens after
+// the value is unescaped.
+func NormalizePathVars(b bool) DecodeInputsGeneratorOpt {
+	return func(o *eigo) {
+		o.normalizePathVars = b
+	}
+}
+
+// TrustForwardedHeaders true causes fields tagged `nvelope:"host"` and
+// `nvelope:"scheme"` to be filled from the X-Forwarded-Host and
+// X-Forwarded-Proto headers when they are present.  Only use this
+// when the server is behind a proxy that sets those headers:
+// otherwise clients can claim any host or scheme they like.  If
+// there is more than one proxy, the first (client-most) value is used.
+func TrustForwardedHeaders(b bool) DecodeInputsGeneratorOpt {
+	return func(o *eigo) {
+		o.trustForwardedHeaders = b
+	}
+}
+
+// StrictMapPairs true rejects maps and structs that are filled from
+// a delimited list of keys and values (like "?m=k1,v1,k2,v2" with
+// explode=false) when the list has an odd number of elements.  Without
+// it, the last key gets an empty value, which is then decoded as if the
+// empty value had been supplied.
+func StrictMapPairs(b bool) DecodeInputsGeneratorOpt {
+	return func(o *eigo) {
+		o.strictMapPairs = b
+	}
+}
+
 // WithTag overrides the tag for specifying fields to be filled
 // from the http request.  The default is "nvelope"
 func WithTag(tag string) DecodeInputsGeneratorOpt {
@@ -139,6 +619,30 @@ func WithTag(tag string) DecodeInputsGeneratorOpt {
 	}
 }
 
+// DecodeProfile bundles options into one so that a shared set of
+// options can be defined once and used by many endpoints.  Options
+// are applied in order, both within the profile and in the list
+// passed to GenerateDecoder, so options that come later override
+// options that came earlier: the last WithTag wins and a later
+// WithDecoder for the same content type replaces an earlier one.
+// Options that add to a list, like WithModelPostProcess and
+// WithModelValidator, accumulate instead.
+//
+//	var apiProfile = nvelope.DecodeProfile(
+//		nvelope.WithDecoder("application/json", json.Unmarshal),
+//		nvelope.WithDefaultContentType("application/json"),
+//		nvelope.RejectUnknownQueryParameters(true),
+//	)
+//
+//	nvelope.GenerateDecoder(apiProfile, nvelope.RejectUnknownQueryParameters(false))
+func DecodeProfile(opts ...DecodeInputsGeneratorOpt) DecodeInputsGeneratorOpt {
+	return func(o *eigo) {
+		for _, opt := range opts {
+			opt(o)
+		}
+	}
+}
+
 // TODO: Does this work?
 // This model can be defined right in the function though:
 //
@@ -149,8 +653,6 @@ func WithTag(tag string) DecodeInputsGeneratorOpt {
 //      ...
 //  }
 
-var deepObjectRE = regexp.MustCompile(`^([^\[]+)\[([^\]]+)\]$`) // id[name]
-
 // TODO: handle multipart form uploads
 
 // GenerateDecoder injects a special provider that uses
@@ -166,10 +668,43 @@ var deepObjectRE = regexp.MustCompile(`^([^\[]+)\[([^\]]+)\]$`) // id[name]
 // Major warning: the endpoint handler must receive the request
 // model as a field inside a model, not as a standalone model.
 //
+// Each struct type that is missing gets its own provider so the
+// request can be split across several models, for example one for
+// path variables and one for query parameters:
+//
+//	func(p PathParams, q *QueryParams, b BodyModel) (nvelope.Response, error)
+//
+// With RejectUnknownQueryParameters, a query parameter is only
+// unknown if none of the models accept it.
+//
 // The following tags are recognized:
 //
 // `nvelope:"model"` causes the POST or PUT body to be decoded
-// using a decoder like json.Unmarshal.
+// using a decoder like json.Unmarshal.  The tagged field does not have to
+// be a struct.  When the body is a JSON array, tag a slice:
+//
+//	func HandleBatch(s struct {
+//		Items []Item `nvelope:"model"`
+//	}) ...
+//
+// `nvelope:"model,form=true"` additionally allows the body to be
+// application/x-www-form-urlencoded.  When it is, the form values are
+// used to fill the fields of the model the same way that deepObject=true
+// fills a struct from query parameters: the fields are matched by their
+// nvelope tag name or, if untagged, by their field name.  Other content
+// types are decoded as usual.  This allows one endpoint to accept the
+// same data as JSON or as an HTML form.  Nested form names, like
+// "user[name]" or "user[address][city]", fill struct and map members.
+// The same is true for query parameters with deepObject=true and
+// form=true (or formOnly=true) when their values come from a form body.
+//
+//	type Login struct {
+//		Username string `json:"username" nvelope:"username"`
+//		Password string `json:"password" nvelope:"password"`
+//	}
+//	func HandleLogin(s struct {
+//		Login Login `nvelope:"model,form=true"`
+//	}) ...
 //
 // `nvelope:"path,name=xxx"` causes part of the URL path to
 // be extracted and written to the tagged field.
@@ -178,21 +713,38 @@ var deepObjectRE = regexp.MustCompile(`^([^\[]+)\[([^\]]+)\]$`) // id[name]
 // parameters to be extracted and written to the tagged field.
 //
 // `nvelope:"header,name=xxx"` causes the named HTTP header
-// to be extracted and written to the tagged field.
+// to be extracted and written to the tagged field.  Header names
+// are not case sensitive.
 //
 // `nvelope:"cookie,name=xxx"` cause the named HTTP cookie to be
 // extracted and writted to the tagged field.
 //
+// `nvelope:"method"` causes the request method (r.Method) to be
+// written to the tagged field.  This is useful for models that
+// are shared by endpoints for more than one method.
+//
+// `nvelope:"host"` causes the host the request was sent to (r.Host,
+// which may include a port) to be written to the tagged field.
+//
+// `nvelope:"scheme"` causes the scheme of the request, "http" or "https",
+// to be written to the tagged field.
+//
+// Behind a proxy, use TrustForwardedHeaders so that host and scheme
+// come from the X-Forwarded-Host and X-Forwarded-Proto headers.
+//
 // Path, query, header, and cookie support options described
 // in https://swagger.io/docs/specification/serialization/ for
 // controlling how to serialize.  The following are supported
 // as appropriate.
 //
 //	explode=true			# default for query, header
-//	explode=false			# default for path
+//	explode=false			# default for path and cookie
 //	delimiter=comma			# default
 //	delimiter=space			# query parameters only
 //	delimiter=pipe			# query parameters only
+//	delimiter=semicolon		# query parameters only
+//	delimiter=tab			# query parameters only
+//	delimiter=%3B			# query parameters only, URL-escaped arbitrary delimiter
 //	allowReserved=false		# default
 //	allowReserved=true		# query parameters only
 //	form=false			# default
@@ -203,8 +755,54 @@ var deepObjectRE = regexp.MustCompile(`^([^\[]+)\[([^\]]+)\]$`) // id[name]
 //	content=application/xml		# specifies that the value should be decoded with XML
 //	content=application/yaml	# specifies that the value should be decoded with YAML
 //	content=text/yaml		# specifies that the value should be decoded with YAML
+//	content=text/plain		# specifies that the value should be decoded with UnmarshalText
+//	encoding=base64			# with content=, base64 decode the value before decoding the content
+//	encoding=gzip			# with content=, gunzip the value before decoding the content
+//	encoding=base64+gzip		# with content=, base64 decode and then gunzip (see WithMaxDecompressedSize)
+//	layout=xxx			# not used directly: available to decoders registered with WithTagDecoder
+//	style=form			# default for query, same as delimiter=comma
+//	style=simple			# default for path and header, same as delimiter=comma
+//	style=spaceDelimited		# query parameters only, same as delimiter=space
+//	style=pipeDelimited		# query parameters only, same as delimiter=pipe
+//	style=deepObject		# query parameters only, same as deepObject=true
+//	scheme=Bearer			# headers only, require and remove an authentication scheme prefix
+//	scheme=Basic			# headers only, for a struct: fill the first two fields with user and password
+//	fold=true			# header slices only, split repeated headers on commas (RFC 7230)
 //	deepObject=false		# default
-//	deepObject=true			# required for query object
+//	deepObject=true			# required for query object, for slices and arrays: fill by index as in "a[2]=x"
+//	default=xxx			# value to use when the parameter is not supplied
+//	minimum=N			# numbers only, reject values less than N
+//	maximum=N			# numbers only, reject values greater than N
+//	exclusiveMin=N			# numbers only, reject values less than or equal to N
+//	exclusiveMax=N			# numbers only, reject values greater than or equal to N
+//	multipleOf=N			# numbers only, reject values that are not a multiple of N
+//	format=xxx			# strings only, validate with a format like "email" (see RegisterFormat)
+//	minLength=N			# strings only, reject values shorter than N characters (runes)
+//	maxLength=N			# strings only, reject values longer than N characters (runes)
+//	byteLength=true			# strings only, minLength and maxLength count bytes instead of runes
+//	required=true			# reject the request if the value is not supplied
+//	join=comma			# query and header, explode=true only: join repeated values into one string, also pipe, space, etc.
+//	errMsg=xxx			# message sent to the client instead of the error details if decoding fails
+//	presence=true			# headers and query parameters, bools only: true if supplied, even if empty
+//	prefix=X-Meta-			# headers only, maps only: collect every header that starts with the prefix
+//	boolStyle=strict		# default, bools accept what strconv.ParseBool accepts
+//	boolStyle=lenient		# bools only, also accept yes/no, on/off, and y/n (any case)
+//	jwt=true			# headers and cookies only, decode the claims of a JSON Web Token (see WithJWTKeyFunc)
+//	exactItems=true			# arrays only, reject the request unless exactly as many values as the array length are supplied
+//	numberFormat=strict		# default, numbers must not have grouping separators
+//	numberFormat=grouped		# numbers only, accept comma grouping separators as in "1,234.56"
+//
+// Fields that are not supplied are left alone: pointer fields stay nil and
+// other fields keep their zero value (or the value from "default").  This
+// makes pointers the natural choice for optional values when the zero value
+// is meaningful.  Add "required=true" to reject requests that do not supply
+// the value, regardless of whether the field is a pointer.  A value is
+// supplied if the query parameter, header, or cookie is present (even if
+// empty), if the path variable is not empty, or, for "model", if the body
+// is not empty.  "required" cannot be combined with "default".
+//
+// Values that do not satisfy a constraint, like "minimum", are rejected
+// with an error that wraps a *DecodeError describing the constraint.
 //
 // "style=label" and "style=matrix" are NOT yet supported for path parameters.
 //
@@ -214,6 +812,9 @@ var deepObjectRE = regexp.MustCompile(`^([^\[]+)\[([^\]]+)\]$`) // id[name]
 //	deepObject=true
 //	deepObject=false,explode=false
 //
+// Cookies filling maps and structs expect a single cookie with a value
+// like "k1,v1,k2,v2" or, with explode=true, "k1=v1,k2=v2".
+//
 // When filling embedded structs from query, or header, parameters,
 // using explode=false or deepObject=true, tagging struct members is
 // optional.  Tag them with their name or with "-" if you do not want
@@ -227,7 +828,27 @@ var deepObjectRE = regexp.MustCompile(`^([^\[]+)\[([^\]]+)\]$`) // id[name]
 //		} `nvelope:"query,name=embedded,explode=false"`
 //	}
 //
-// "deepObject=true" is only supported for maps and structs and only for query parameters.
+// Untagged embedded structs are walked and their tagged fields are filled
+// as if they were declared directly in the outer struct.  This allows common
+// sets of parameters to be shared between many models.
+//
+//	type Common struct {
+//		Verbose bool `nvelope:"query,name=verbose"`
+//	}
+//	type Fillme struct {
+//		Common
+//		ID int `nvelope:"path,name=id"`
+//	}
+//
+// Exported embedded pointers to structs are walked the same way.  The
+// pointer is allocated when one of its fields is filled and otherwise
+// left nil.
+//
+// "deepObject=true" is only supported for maps, structs, slices, and arrays and only for
+// query parameters.  Slices and arrays are filled by index, leaving unspecified elements
+// zero: "a[0]=x&a[3]=y" fills a slice of length 4.  Indexes must be less than 1000.
+// When a struct is filled with deepObject=true, a key that is repeated, as in
+// "obj[tags]=a&obj[tags]=b", fills a slice or array member with all of the values.
 //
 // Use "explode=true" combined with setting a "content" when you have a map to a struct or
 // a slice of structs and each value will be encoded in JSON/XML independently. If the entire
@@ -237,206 +858,119 @@ var deepObjectRE = regexp.MustCompile(`^([^\[]+)\[([^\]]+)\]$`) // id[name]
 // unpack strings into struct fields.  That provides support for time.Duration and anything
 // that implements encoding.TextUnmarshaler or flag.Value.  Additional custom decoders can
 // be registered with https://pkg.go.dev/github.com/muir/reflectutils#RegisterStringSetter .
+// Slices and arrays of such types are decoded element by element.
+// Fields of type url.URL and *url.URL are parsed with url.Parse, so relative
+// URLs are accepted.
+// Types that can't decode themselves, like generated enums, can be
+// registered with RegisterEnum.
+// Named types (like "type Status string" or "type Labels map[string]string")
+// are decoded the same way as their underlying types unless they implement
+// encoding.TextUnmarshaler.
+//
+// Arbitrary precision numbers (big.Int, big.Float, and big.Rat, or pointers to them)
+// are supported through encoding.TextUnmarshaler.  Note that big.Int accepts
+// base prefixes: "0x10" decodes as 16.  The minimum= and similar constraints
+// are not supported for them.
+//
+// Times in request bodies are decoded by the body decoder (json.Unmarshal),
+// which only accepts RFC3339 for time.Time.  Use FlexibleTime or Date for
+// fields that use other layouts.  Both also work as parameters.
+//
+// Each GenerateDecoder value caches what it learns about a model type
+// the first time that type is bound.  The cache is not shared between
+// separate calls to GenerateDecoder, even with identical options, so
+// create the decoder once and use it in every endpoint's chain to
+// avoid repeating that work:
+//
+//	var decoder = nvelope.GenerateDecoder(
+//		nvelope.WithDecoder("application/json", json.Unmarshal),
+//	)
 //
 // There are a couple of example decoders defined in https://github.com/muir/nape and also
 // https://github.com/muir/nchi .
 func GenerateDecoder(
 	genOpts ...DecodeInputsGeneratorOpt,
 ) interface{} {
-	options := eigo{
-		tag:      "nvelope",
-		decoders: make(map[string]Decoder),
-	}
-	for _, opt := range genOpts {
-		opt(&options)
-	}
+	options := newEigo(genOpts)
 	return nject.GenerateFromInjectionChain("GenerateDecoder", func(before nject.Collection, after nject.Collection) (nject.Provider, error) {
 		full := before.Append("after", after)
 		missingInputs, _ := full.DownFlows()
+		_, providedBefore := before.DownFlows()
+		var bodyProvided, bodyStatusProvided bool
+		for _, t := range providedBefore {
+			switch t {
+			case bodyType:
+				bodyProvided = true
+			case bodyStatusType:
+				bodyStatusProvided = true
+			}
+		}
+		// Inputs can be split across several models (for example,
+		// one for path variables and one for query parameters) so
+		// each model needs to know which query parameters the others
+		// accept.
+		models := make(map[reflect.Type]*modelFillers)
+		for _, missingType := range missingInputs {
+			nonPointer := missingType
+			if nonPointer.Kind() == reflect.Ptr {
+				nonPointer = nonPointer.Elem()
+			}
+			if nonPointer.Kind() != reflect.Struct {
+				continue
+			}
+			fillers, err := options.fillersFor(nonPointer)
+			if err != nil {
+				return nil, err
+			}
+			if !fillers.empty() {
+				models[nonPointer] = fillers
+			}
+		}
 		var providers []interface{}
 		for _, missingType := range missingInputs {
 			returnType := missingType
-			var nonPointer reflect.Type
-			var returnAddress bool
-			// nolint:exhaustive
-			switch missingType.Kind() {
-			case reflect.Struct:
-				nonPointer = returnType
-			case reflect.Ptr:
-				returnAddress = true
-				e := returnType.Elem()
-				if e.Kind() != reflect.Struct {
-					continue
-				}
-				nonPointer = e
-			default:
+			nonPointer := missingType
+			returnAddress := missingType.Kind() == reflect.Ptr
+			if returnAddress {
+				nonPointer = missingType.Elem()
+			}
+			fillers, ok := models[nonPointer]
+			if !ok {
 				continue
 			}
-			var varsFillers []func(model reflect.Value, routeVarLookup RouteVarLookup) error
-			var headerFillers []func(model reflect.Value, header http.Header) error
-			var cookieFillers []func(model reflect.Value, r *http.Request) error
-			var bodyFillers []func(model reflect.Value, body []byte, r *http.Request) error
-			queryFillers := make(map[string]func(reflect.Value, []string) error)
-			queryFillersForm := make(map[string]func(reflect.Value, []string) error)
-			deepObjectFillers := make(map[string]func(reflect.Value, map[string][]string) error)
-			deepObjectFillersForm := make(map[string]func(reflect.Value, map[string][]string) error)
-			var returnError error
-			reflectutils.WalkStructElements(nonPointer, func(field reflect.StructField) bool {
-				tag, ok := reflectutils.LookupTag(field.Tag, options.tag)
-				if !ok {
-					return true
-				}
-				tags, err := parseTag(tag)
-				if err != nil {
-					returnError = err
-					return false
-				}
-				if tags.Base == "model" {
-					bodyFillers = append(bodyFillers,
-						func(model reflect.Value, body []byte, r *http.Request) error {
-							f := model.FieldByIndex(field.Index)
-							ct := r.Header.Get("Content-Type")
-							if ct == "" {
-								ct = options.defaultContentType
-							}
-							exactDecoder, ok := options.decoders[ct]
-							if !ok {
-								return errors.Errorf("No body decoder for content type %s", ct)
-							}
-							// nolint:govet
-							err := exactDecoder(body, f.Addr().Interface())
-							return errors.Wrapf(err, "Could not decode %s into %s", ct, field.Type)
-						})
-					return false
-				}
-
-				name := field.Name // not used by model, but used by the rest
-				if tags.Name != "" {
-					name = tags.Name
-				}
-				unpacker, err := getUnpacker(field.Type, field.Name, name, tags.Base, tags, options)
-				if err != nil {
-					returnError = err
-					return false
-				}
-				switch tags.Base {
-				case "path":
-					varsFillers = append(varsFillers, func(model reflect.Value, routeVarLookup RouteVarLookup) error {
-						f := model.FieldByIndex(field.Index)
-						return errors.Wrapf(
-							unpacker.single("path", f, routeVarLookup(name)),
-							"path element %s into field %s",
-							name, field.Name)
-					})
-				case "header":
-					if unpacker.multi != nil {
-						headerFillers = append(headerFillers, func(model reflect.Value, header http.Header) error {
-							f := model.FieldByIndex(field.Index)
-							values, ok := header[name]
-							if !ok {
-								return nil
-							}
-							return errors.Wrapf(
-								unpacker.multi("header", f, values),
-								"header %s into field %s",
-								name, field.Name)
-						})
-					} else {
-						headerFillers = append(headerFillers, func(model reflect.Value, header http.Header) error {
-							f := model.FieldByIndex(field.Index)
-							values, ok := header[name]
-							if !ok || len(values) == 0 {
-								return nil
-							}
-							return errors.Wrapf(
-								unpacker.single("header", f, values[0]),
-								"header %s into field %s",
-								name, field.Name)
-						})
-					}
-				case "query":
-					switch {
-					case unpacker.deepObject != nil:
-						deepObjectFillers[name] = func(model reflect.Value, mapValues map[string][]string) error {
-							f := model.FieldByIndex(field.Index)
-							return unpacker.deepObject(f, mapValues)
-						}
-					case unpacker.multi != nil:
-						queryFillers[name] = func(model reflect.Value, values []string) error {
-							f := model.FieldByIndex(field.Index)
-							return errors.Wrapf(
-								unpacker.multi("query", f, values),
-								"query parameter %s into field %s",
-								name, field.Name)
-						}
-					default:
-						queryFillers[name] = func(model reflect.Value, values []string) error {
-							if len(values) == 0 {
-								return nil
-							}
-							f := model.FieldByIndex(field.Index)
-							return errors.Wrapf(
-								unpacker.single("query", f, values[0]),
-								"query parameter %s into field %s",
-								name, field.Name)
-						}
-					}
-					if tags.Form || tags.FormOnly {
-						if unpacker.deepObject != nil {
-							deepObjectFillersForm[name] = deepObjectFillers[name]
-							if tags.FormOnly {
-								delete(deepObjectFillers, name)
-							}
-						} else {
-							queryFillersForm[name] = queryFillers[name]
-							if tags.FormOnly {
-								delete(queryFillers, name)
-							}
-						}
+			if len(models) > 1 {
+				withSiblings := *fillers
+				for t, sibling := range models {
+					if t != nonPointer {
+						withSiblings.siblings = append(withSiblings.siblings, sibling)
 					}
-				case "cookie":
-					cookieFillers = append(cookieFillers, func(model reflect.Value, r *http.Request) error {
-						f := model.FieldByIndex(field.Index)
-						cookie, err := r.Cookie(name)
-						if err != nil {
-							if errors.Is(err, http.ErrNoCookie) {
-								return nil
-							}
-							return errors.Wrapf(err, "cookie parameter %s into field %s", name, field.Name)
-						}
-						return errors.Wrapf(
-							unpacker.single("cookie", f, cookie.Value),
-							"cookie parameter %s into field %s",
-							name, field.Name)
-					})
 				}
-				return true
-			})
-			if returnError != nil {
-				return nil, returnError
-			}
-
-			if len(varsFillers) == 0 &&
-				len(headerFillers) == 0 &&
-				len(cookieFillers) == 0 &&
-				len(queryFillers) == 0 &&
-				len(queryFillersForm) == 0 &&
-				len(bodyFillers) == 0 &&
-				len(deepObjectFillers) == 0 &&
-				len(deepObjectFillersForm) == 0 {
-				continue
+				fillers = &withSiblings
 			}
 
 			outputs := []reflect.Type{returnType, terminalErrorType}
 			inputs := []reflect.Type{httpRequestType}
-			if len(bodyFillers) != 0 || len(queryFillersForm) != 0 || len(deepObjectFillersForm) != 0 {
-				inputs = append(inputs, bodyType)
+			var bodyIndex int
+			var bodyStatusIndex int
+			name := "create " + nonPointer.String()
+			if fillers.needsBody() {
+				if !bodyProvided {
+					// Body may still be an argument of the invoke
+					// function which is not visible here.  If it isn't, the
+					// provider name is included in nject's missing input error.
+					name += " (requires nvelope.Body, use nvelope.ReadBody to provide it)"
+				}
+				bodyIndex = addToInputs(&inputs, bodyType)
+				if bodyStatusProvided && len(fillers.body) != 0 {
+					bodyStatusIndex = addToInputs(&inputs, bodyStatusType)
+				}
 			}
 
 			// if there are route/path vars, then routeVarLookup needs its input map built
 			var rvlInputMap []int
 			var rvl reflect.Value
-			if len(varsFillers) > 0 {
+			var rvlInputPool *sync.Pool
+			if len(fillers.vars) > 0 {
 				if options.pathVarFunction == nil {
 					return nil, errors.Errorf("path/route variable interpolation requested, but no RouteVarLookup function provided by WithPathVarsFunction")
 				}
@@ -448,105 +982,855 @@ func GenerateDecoder(
 				for i := 0; i < len(rvlInputMap); i++ {
 					rvlInputMap[i] = addToInputs(&inputs, rvl.Type().In(i))
 				}
+				rvlInputPool = &sync.Pool{
+					New: func() interface{} {
+						s := make([]reflect.Value, len(rvlInputMap))
+						return &s
+					},
+				}
+			}
+
+			var factory func() (reflect.Value, error)
+			if f, ok := options.modelFactories[nonPointer]; ok {
+				if _, err := callModelFactory(f, nonPointer); err != nil {
+					return nil, err
+				}
+				factory = func() (reflect.Value, error) {
+					return callModelFactory(f, nonPointer)
+				}
+			}
+
+			var logInputIndex int
+			if options.logDecodeErrors {
+				logInputIndex = addToInputs(&inputs, basicLoggerType)
+			}
+			var echoIndex int
+			if options.decodeEcho != "" {
+				echoIndex = addToInputs(&inputs, deferredWriterType)
 			}
 
 			reflective := nject.MakeReflective(inputs, outputs, func(in []reflect.Value) []reflect.Value {
 				// nolint:errcheck
 				r := in[0].Interface().(*http.Request)
-				mp := reflect.New(nonPointer)
-				model := mp.Elem()
-				var err error
-				setError := func(e error) {
-					if err == nil && e != nil {
-						err = e
-					}
-				}
-				if len(bodyFillers) != 0 {
-					body := []byte(in[1].Interface().(Body))
-					for _, bf := range bodyFillers {
-						setError(bf(model, body, r))
+				var mp reflect.Value
+				if factory != nil {
+					var err error
+					mp, err = factory()
+					if err != nil {
+						mp = reflect.New(nonPointer)
+						if returnAddress {
+							return []reflect.Value{mp, reflect.ValueOf(err)}
+						}
+						return []reflect.Value{mp.Elem(), reflect.ValueOf(err)}
 					}
+				} else {
+					mp = reflect.New(nonPointer)
 				}
-				if len(varsFillers) != 0 {
-					rvlInputs := make([]reflect.Value, len(rvlInputMap))
+				var routeVarLookup RouteVarLookup
+				if len(fillers.vars) != 0 {
+					rvlInputs := rvlInputPool.Get().(*[]reflect.Value)
 					for i, inputIndex := range rvlInputMap {
-						rvlInputs[i] = in[inputIndex]
-					}
-					routeVarLookup := rvl.Call(rvlInputs)[0].Interface().(RouteVarLookup)
-					for _, vf := range varsFillers {
-						setError(vf(model, routeVarLookup))
-					}
-				}
-				for _, hf := range headerFillers {
-					setError(hf(model, r.Header))
-				}
-				var deepObjects map[string]map[string][]string
-				handleQueryParams := func(values url.Values, queryFillers map[string]func(reflect.Value, []string) error, deepObjectFillers map[string]func(reflect.Value, map[string][]string) error) {
-					for key, vals := range values {
-						if qf, ok := queryFillers[key]; ok {
-							setError(qf(model, vals))
-							continue
-						}
-						if len(deepObjectFillers) != 0 {
-							if m := deepObjectRE.FindStringSubmatch(key); len(m) == 3 {
-								if _, ok := deepObjectFillers[m[1]]; ok {
-									if deepObjects == nil {
-										deepObjects = make(map[string]map[string][]string)
-									}
-									if deepObjects[m[1]] == nil {
-										deepObjects[m[1]] = make(map[string][]string)
-									}
-									deepObjects[m[1]][m[2]] = vals
-									continue
-								}
-							}
-						}
-						if options.rejectUnknownQueryParameters {
-							setError(errors.Errorf("query parameter '%s' not supported", key))
-						}
+						(*rvlInputs)[i] = in[inputIndex]
 					}
-				}
-				handleQueryParams(r.URL.Query(), queryFillers, deepObjectFillers)
-				if len(queryFillersForm) != 0 || len(deepObjectFillersForm) != 0 {
-					body := []byte(in[1].Interface().(Body))
-					ct := r.Header.Get("Content-Type")
-					if ct == "application/x-www-form-urlencoded" {
-						values, err := url.ParseQuery(string(body))
-						if err != nil {
-							setError(errors.Wrap(err, "could not parse application/x-www-form-urlencoded data"))
-						} else {
-							handleQueryParams(values, queryFillersForm, deepObjectFillersForm)
-						}
+					routeVarLookup = rvl.Call(*rvlInputs)[0].Interface().(RouteVarLookup)
+					for i := range *rvlInputs {
+						(*rvlInputs)[i] = reflect.Value{}
 					}
+					rvlInputPool.Put(rvlInputs)
 				}
-				for dofKey, values := range deepObjects {
-					setError(deepObjectFillers[dofKey](model, values))
+				var body Body
+				if bodyIndex != 0 {
+					body = in[bodyIndex].Interface().(Body)
 				}
-				for _, cf := range cookieFillers {
-					setError(cf(model, r))
+				var log BasicLogger
+				if options.logDecodeErrors {
+					log = in[logInputIndex].Interface().(BasicLogger)
 				}
 				var ev reflect.Value
-				if err == nil {
-					ev = reflect.Zero(errorType)
+				if err := options.fill(fillers, mp, returnType, r, body, routeVarLookup, log); err != nil {
+					ev = reflect.ValueOf(err)
 				} else {
-					ev = reflect.ValueOf(errors.Wrapf(ReturnCode(err, 400), "%s model", returnType))
+					ev = reflect.Zero(errorType)
+					if bodyStatusIndex != 0 && len(body) != 0 {
+						in[bodyStatusIndex].Interface().(*BodyStatus).Decoded = true
+					}
+					if _, ok := r.Header[options.decodeEcho]; ok && echoIndex != 0 {
+						ev = reflect.ValueOf(echoDecoded(in[echoIndex].Interface().(*DeferredWriter), mp.Interface()))
+					}
 				}
 				if returnAddress {
 					return []reflect.Value{mp, ev}
 				}
-				return []reflect.Value{model, ev}
+				return []reflect.Value{mp.Elem(), ev}
 			})
-			providers = append(providers, nject.Provide("create "+nonPointer.String(), reflective))
+			providers = append(providers, nject.Provide(name, reflective))
 		}
 		return nject.Sequence("fill functions from request", providers...), nil
 	})
 }
 
-// generateStructUnpacker generates a function to deal with filling a struct from
-// an array of key, value pairs.
-func generateStructUnpacker(
-	base string,
-	fieldType reflect.Type,
+// callModelFactory calls a factory provided with WithModelFactory and
+// returns a pointer to the model it created
+func callModelFactory(f func() interface{}, modelType reflect.Type) (reflect.Value, error) {
+	v := reflect.ValueOf(f())
+	switch {
+	case !v.IsValid():
+		return reflect.Value{}, errors.Errorf("model factory for %s returned nil", modelType)
+	case v.Type() == modelType:
+		mp := reflect.New(modelType)
+		mp.Elem().Set(v)
+		return mp, nil
+	case v.Type() != reflect.PointerTo(modelType):
+		return reflect.Value{}, errors.Errorf("model factory for %s returned %s, it must return %s or %s",
+			modelType, v.Type(), modelType, reflect.PointerTo(modelType))
+	case v.IsNil():
+		return reflect.Value{}, errors.Errorf("model factory for %s returned a nil pointer", modelType)
+	default:
+		return v, nil
+	}
+}
+
+// decoderFor finds the decoder for a Content-Type as described in
+// WithDecoder and WithMethodDecoder
+func (options *eigo) decoderFor(method string, contentType string) (Decoder, bool) {
+	if decoders, ok := options.methodDecoders[method]; ok {
+		if decoder, ok := lookupDecoder(decoders, contentType); ok {
+			return decoder, true
+		}
+	}
+	return lookupDecoder(options.decoders, contentType)
+}
+
+func lookupDecoder(decoders map[string]Decoder, contentType string) (Decoder, bool) {
+	if decoder, ok := decoders[contentType]; ok {
+		return decoder, true
+	}
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	if decoder, ok := decoders[mediaType]; ok {
+		return decoder, true
+	}
+	typ, subtype, _ := strings.Cut(mediaType, "/")
+	if i := strings.LastIndexByte(subtype, '+'); i != -1 {
+		if decoder, ok := decoders[typ+"/*"+subtype[i:]]; ok {
+			return decoder, true
+		}
+		if decoder, ok := decoders["*/*"+subtype[i:]]; ok {
+			return decoder, true
+		}
+	}
+	if decoder, ok := decoders[typ+"/*"]; ok {
+		return decoder, true
+	}
+	decoder, ok := decoders["*/*"]
+	return decoder, ok
+}
+
+func newEigo(genOpts []DecodeInputsGeneratorOpt) *eigo {
+	options := &eigo{
+		tag:                 "nvelope",
+		decoders:            make(map[string]Decoder),
+		tagDecoders:         make(map[string]TagDecoder),
+		decodeErrorStatus:   http.StatusBadRequest,
+		maxDecompressedSize: defaultMaxDecompressedSize,
+		fillerCache:         &sync.Map{},
+	}
+	for _, opt := range genOpts {
+		opt(options)
+	}
+	return options
+}
+
+// DecodeRequest fills model, which must be a pointer to a struct, from
+// the request using the same rules and options as GenerateDecoder.  It is
+// meant for testing request models and for use outside of nject injection
+// chains.  If the model needs the request body, the body is read and then
+// replaced so that it can be read again.  A function provided with
+// WithPathVarsFunction must take only an *http.Request.  WithModelFactory
+// and LogDecodeErrors have no effect.
+func DecodeRequest(r *http.Request, model interface{}, genOpts ...DecodeInputsGeneratorOpt) error {
+	mp := reflect.ValueOf(model)
+	if mp.Kind() != reflect.Ptr || mp.IsNil() || mp.Elem().Kind() != reflect.Struct {
+		return errors.Errorf("DecodeRequest requires a non-nil pointer to a struct, not %T", model)
+	}
+	options := newEigo(genOpts)
+	fillers, err := options.buildModelFillers(mp.Type().Elem())
+	if err != nil {
+		return err
+	}
+	var body Body
+	if fillers.needsBody() && r.Body != nil {
+		body, err = readBody(r)
+		if err != nil {
+			return errors.Wrap(err, "read request body")
+		}
+	}
+	var routeVarLookup RouteVarLookup
+	if len(fillers.vars) != 0 {
+		rvl := reflect.ValueOf(options.pathVarFunction)
+		if options.pathVarFunction == nil ||
+			rvl.Type().Kind() != reflect.Func ||
+			rvl.Type().NumOut() != 1 ||
+			!rvl.Type().Out(0).AssignableTo(rvlType) {
+			return errors.Errorf("path/route variable interpolation requested, but no function that returns RouteVarLookup was provided by WithPathVarsFunction")
+		}
+		in := make([]reflect.Value, rvl.Type().NumIn())
+		for i := range in {
+			if rvl.Type().In(i) != httpRequestType {
+				return errors.Errorf("the function provided by WithPathVarsFunction, %T, must take only *http.Request for use with DecodeRequest", options.pathVarFunction)
+			}
+			in[i] = reflect.ValueOf(r)
+		}
+		routeVarLookup = rvl.Call(in)[0].Interface().(RouteVarLookup)
+	}
+	return options.fill(fillers, mp, mp.Type(), r, body, routeVarLookup, nil)
+}
+
+// fill populates the model that mp points to from the request.  Every
+// error is logged if log is not nil; the first one is returned.
+func (options *eigo) fill(
+	fillers *modelFillers,
+	mp reflect.Value,
+	returnType reflect.Type,
+	r *http.Request,
+	body Body,
+	routeVarLookup RouteVarLookup,
+	log BasicLogger,
+) error {
+	if err := options.checkLimits(r); err != nil {
+		return errors.Wrapf(err, "%s model", returnType)
+	}
+	model := mp.Elem()
+	var err error
+	var collected ValidationErrors
+	setError := func(e error) {
+		if e == nil {
+			return
+		}
+		if log != nil {
+			log.Debug("could not decode request", map[string]interface{}{
+				"error":  e.Error(),
+				"model":  returnType.String(),
+				"method": r.Method,
+				"uri":    r.URL.String(),
+			})
+		}
+		if options.collectAllErrors {
+			var fe *FieldError
+			if !errors.As(e, &fe) || fe != e {
+				var field string
+				if fe != nil {
+					field = fe.Field
+				}
+				fe = &FieldError{Field: field, Err: e}
+			}
+			collected = append(collected, fe)
+			err = collected
+			return
+		}
+		if err == nil {
+			err = e
+		}
+	}
+	for _, df := range fillers.defaults {
+		setError(df(model))
+	}
+	if len(fillers.body) != 0 {
+		if len(body) == 0 && fillers.requiredBody {
+			err := errors.New("request body is required")
+			if fillers.requiredBodyMsg != "" {
+				err = WithClientMessage(err, fillers.requiredBodyMsg)
+			}
+			setError(err)
+		} else if err := options.validateJSONSchema(r, body); err != nil {
+			setError(err)
+		} else {
+			for _, bf := range fillers.body {
+				setError(bf(model, body, r))
+			}
+		}
+	}
+	for _, vf := range fillers.vars {
+		setError(vf(model, routeVarLookup))
+	}
+	for _, hf := range fillers.header {
+		setError(hf(model, r.Header))
+	}
+	var deepObjects map[string]map[string][]string
+	handleQueryParams := func(values url.Values, queryFillers map[string]func(reflect.Value, []string) error, deepObjectFillers map[string]func(reflect.Value, map[string][]string) error, bracketKeys bool) {
+		for key, vals := range values {
+			if qf, ok := queryFillers[key]; ok {
+				setError(qf(model, vals))
+				continue
+			}
+			if len(deepObjectFillers) != 0 {
+				objectName, objectKey, ok := splitDeepObjectKey(key)
+				if !ok && bracketKeys {
+					objectName, objectKey, ok = splitBracketKey(key)
+				}
+				if !ok && options.dottedQueryKeys {
+					objectName, objectKey, ok = strings.Cut(key, ".")
+				}
+				if ok {
+					if _, ok := deepObjectFillers[objectName]; ok {
+						if deepObjects == nil {
+							deepObjects = make(map[string]map[string][]string)
+						}
+						if deepObjects[objectName] == nil {
+							deepObjects[objectName] = make(map[string][]string)
+						}
+						deepObjects[objectName][objectKey] = vals
+						continue
+					}
+				}
+			}
+			if fillers.siblingAccepts(key, bracketKeys, options.dottedQueryKeys) {
+				continue
+			}
+			switch {
+			case options.unknownQueryParameterHandler != nil:
+				setError(options.unknownQueryParameterHandler(key, vals))
+			case fillers.rejectUnknown && options.listKnownQueryParameters:
+				setError(errors.Errorf("query parameter '%s' not supported, supported parameters are: %s",
+					key, knownQueryParameters(fillers, bracketKeys)))
+			case fillers.rejectUnknown:
+				setError(errors.Errorf("query parameter '%s' not supported", key))
+			}
+		}
+	}
+	query := r.URL.Query()
+	handleQueryParams(query, fillers.query, fillers.deepObject, false)
+	var formValues url.Values
+	if len(fillers.queryForm) != 0 || len(fillers.deepObjectForm) != 0 {
+		ct := r.Header.Get("Content-Type")
+		if ct == "application/x-www-form-urlencoded" || (options.detectFormBodies && looksLikeForm(body)) {
+			if err := options.checkFormLimit(body); err != nil {
+				setError(err)
+			} else {
+				values, err := url.ParseQuery(string(body))
+				switch {
+				case err == nil:
+					formValues = values
+					handleQueryParams(values, fillers.queryForm, fillers.deepObjectForm, true)
+				case ct == "application/x-www-form-urlencoded":
+					setError(errors.Wrap(err, "could not parse application/x-www-form-urlencoded data"))
+				}
+			}
+		}
+	}
+	for dofKey, values := range deepObjects {
+		dof, ok := fillers.deepObject[dofKey]
+		if !ok {
+			dof = fillers.deepObjectForm[dofKey]
+		}
+		setError(dof(model, values))
+	}
+	for _, cf := range fillers.cookie {
+		setError(cf(model, r))
+	}
+	for _, rf := range fillers.request {
+		setError(rf(model, r))
+	}
+	for _, rf := range fillers.required {
+		var present bool
+		switch rf.base {
+		case "path":
+			present = routeVarLookup(rf.name) != ""
+		case "header":
+			_, present = r.Header[rf.name]
+		case "cookie":
+			_, cookieErr := r.Cookie(rf.name)
+			present = cookieErr == nil
+		case "query":
+			_, inQuery := query[rf.name]
+			_, inForm := formValues[rf.name]
+			// deepObjects only holds values from the sources
+			// that the field reads
+			present = (rf.query && inQuery) || (rf.form && inForm) || deepObjects[rf.name] != nil
+		}
+		if !present {
+			err := errors.Errorf("%s '%s' is required", requiredWhat[rf.base], rf.name)
+			if rf.errMsg != "" {
+				err = WithClientMessage(err, rf.errMsg)
+			}
+			setError(&FieldError{Field: rf.name, Err: err})
+		}
+	}
+	// query parameters are filled in random order so sort
+	// to keep the errors stable
+	if len(collected) > 1 {
+		sort.SliceStable(collected, func(i, j int) bool {
+			return collected[i].Field < collected[j].Field
+		})
+	}
+	if err == nil {
+		for _, pp := range options.postProcessors {
+			err = pp(mp.Interface(), r)
+			if err != nil {
+				break
+			}
+		}
+	}
+	if err == nil {
+		for _, validator := range options.modelValidators {
+			err = validator(mp.Interface())
+			if err != nil {
+				err = options.unprocessable(err)
+				break
+			}
+		}
+	}
+	if err == nil {
+		return nil
+	}
+	var rc returnCode
+	if !errors.As(err, &rc) {
+		err = ReturnCode(err, options.decodeErrorStatus)
+	}
+	return errors.Wrapf(err, "%s model", returnType)
+}
+
+// checkLimits enforces MaxQueryParameters and MaxHeaders
+func (options *eigo) checkLimits(r *http.Request) error {
+	if options.maxQueryParameters > 0 && countQueryPairs(r.URL.RawQuery) > options.maxQueryParameters {
+		return tooMany("query parameters", options.maxQueryParameters)
+	}
+	if options.maxHeaders > 0 {
+		var count int
+		for _, values := range r.Header {
+			count += len(values)
+		}
+		if count > options.maxHeaders {
+			return tooMany("headers", options.maxHeaders)
+		}
+	}
+	return nil
+}
+
+// checkFormLimit applies MaxQueryParameters to a form body
+func (options *eigo) checkFormLimit(body []byte) error {
+	if options.maxQueryParameters > 0 && countQueryPairs(string(body)) > options.maxQueryParameters {
+		return tooMany("form values", options.maxQueryParameters)
+	}
+	return nil
+}
+
+func tooMany(what string, limit int) error {
+	return BadRequest(errors.Errorf("too many %s (the limit is %d)", what, limit))
+}
+
+// countQueryPairs counts the non-empty &-separated parts of a
+// query string the way url.ParseQuery does, without parsing it
+func countQueryPairs(query string) int {
+	var count int
+	for query != "" {
+		var part string
+		part, query, _ = strings.Cut(query, "&")
+		if part != "" {
+			count++
+		}
+	}
+	return count
+}
+
+// unprocessable marks err as a 422 if UseUnprocessableEntity is set
+// and err does not already have a return code
+func (options *eigo) unprocessable(err error) error {
+	if !options.unprocessableEntity {
+		return err
+	}
+	var rc returnCode
+	if errors.As(err, &rc) {
+		return err
+	}
+	return ReturnCode(err, http.StatusUnprocessableEntity)
+}
+
+// modelFillers are the per-field functions that fill a model from
+// the parts of a request.  They depend only on the model type and
+// the decoder options so they are built once and cached.
+type modelFillers struct {
+	defaults        []func(model reflect.Value) error
+	vars            []func(model reflect.Value, routeVarLookup RouteVarLookup) error
+	header          []func(model reflect.Value, header http.Header) error
+	cookie          []func(model reflect.Value, r *http.Request) error
+	request         []func(model reflect.Value, r *http.Request) error
+	body            []func(model reflect.Value, body []byte, r *http.Request) error
+	query           map[string]func(reflect.Value, []string) error
+	queryForm       map[string]func(reflect.Value, []string) error
+	deepObject      map[string]func(reflect.Value, map[string][]string) error
+	deepObjectForm  map[string]func(reflect.Value, map[string][]string) error
+	required        []requiredField
+	requiredBody    bool
+	requiredBodyMsg string
+	rejectUnknown   bool
+	// siblings are the other models that are decoded from the same
+	// request by the same injection chain
+	siblings []*modelFillers
+}
+
+// requiredField is a field tagged required=true
+type requiredField struct {
+	base   string
+	name   string
+	errMsg string
+	// query and form are the sources that fill a query parameter
+	query bool
+	form  bool
+}
+
+var requiredWhat = map[string]string{
+	"path":   "path element",
+	"header": "header",
+	"cookie": "cookie parameter",
+	"query":  "query parameter",
+}
+
+func (mf *modelFillers) needsBody() bool {
+	return len(mf.body) != 0 || len(mf.queryForm) != 0 || len(mf.deepObjectForm) != 0
+}
+
+func (mf *modelFillers) empty() bool {
+	return len(mf.vars) == 0 &&
+		len(mf.header) == 0 &&
+		len(mf.cookie) == 0 &&
+		len(mf.request) == 0 &&
+		len(mf.query) == 0 &&
+		len(mf.queryForm) == 0 &&
+		len(mf.body) == 0 &&
+		len(mf.deepObject) == 0 &&
+		len(mf.deepObjectForm) == 0
+}
+
+type cachedFillers struct {
+	generation int64
+	fillers    *modelFillers
+	err        error
+}
+
+// fillerCacheGeneration is bumped to invalidate every decoder's cache
+var fillerCacheGeneration int64
+
+func resetFillerCache() {
+	atomic.AddInt64(&fillerCacheGeneration, 1)
+}
+
+// fillersFor returns the fillers for a model type, building them
+// the first time a type is seen by this GenerateDecoder value.  Binding chains
+// concurrently is safe: at worst the fillers are built more than once.
+func (options *eigo) fillersFor(nonPointer reflect.Type) (*modelFillers, error) {
+	generation := atomic.LoadInt64(&fillerCacheGeneration)
+	if c, ok := options.fillerCache.Load(nonPointer); ok {
+		cached := c.(cachedFillers)
+		if cached.generation == generation {
+			return cached.fillers, cached.err
+		}
+	}
+	mf, err := options.buildModelFillers(nonPointer)
+	options.fillerCache.Store(nonPointer, cachedFillers{
+		generation: generation,
+		fillers:    mf,
+		err:        err,
+	})
+	return mf, err
+}
+
+func (options *eigo) buildModelFillers(nonPointer reflect.Type) (*modelFillers, error) {
+	if options.validateTags {
+		if err := checkModelTags(nonPointer, options.tag); err != nil {
+			return nil, err
+		}
+	}
+	mf := &modelFillers{
+		query:          make(map[string]func(reflect.Value, []string) error),
+		queryForm:      make(map[string]func(reflect.Value, []string) error),
+		deepObject:     make(map[string]func(reflect.Value, map[string][]string) error),
+		deepObjectForm: make(map[string]func(reflect.Value, map[string][]string) error),
+	}
+	reject, err := modelRejectUnknown(nonPointer, options.tag)
+	if err != nil {
+		return nil, err
+	}
+	if reject != nil && *reject != options.rejectUnknownQueryParameters {
+		modelOptions := *options
+		modelOptions.rejectUnknownQueryParameters = *reject
+		options = &modelOptions
+	}
+	mf.rejectUnknown = options.rejectUnknownQueryParameters
+	var returnError error
+	walkModelFields(nonPointer, func(field reflect.StructField) bool {
+		tag, ok := reflectutils.LookupTag(field.Tag, options.tag)
+		if !ok {
+			if hasUnreachableTags(field, options.tag) {
+				returnError = errors.Errorf("cannot fill tagged fields through unexported embedded pointer %s", field.Type)
+				return false
+			}
+			return true
+		}
+		tags, err := parseTag(tag)
+		if err != nil {
+			returnError = err
+			return false
+		}
+		if tags.Base == "options" {
+			return false
+		}
+		if tags.Required {
+			if tags.Default != "" {
+				returnError = errors.Errorf("required and default cannot both be set, field %s", field.Name)
+				return false
+			}
+			if tags.Base == "model" {
+				mf.requiredBody = true
+				mf.requiredBodyMsg = tags.ErrMsg
+			} else {
+				mf.required = append(mf.required, requiredField{
+					base:   tags.Base,
+					name:   parameterName(field, tags),
+					errMsg: tags.ErrMsg,
+					query:  !tags.FormOnly,
+					form:   tags.Form || tags.FormOnly,
+				})
+			}
+		}
+		if tags.Base == "model" {
+			var formUnpacker unpack
+			if tags.Form {
+				formTags := tags
+				formTags.DeepObject = true
+				formTags.BracketKeys = true
+				formUnpacker, err = getUnpacker(field.Type, field.Name, field.Name, "query", formTags, *options)
+				if err != nil {
+					returnError = err
+					return false
+				}
+				if formUnpacker.deepObject == nil {
+					returnError = errors.Errorf("form=true on a model requires a struct, field %s", field.Name)
+					return false
+				}
+			}
+			var discriminated map[string]discriminatedType
+			if field.Type.Kind() == reflect.Interface && options.discriminatorField != "" {
+				discriminated, err = options.discriminatedTypes(field)
+				if err != nil {
+					returnError = err
+					return false
+				}
+			}
+			mf.body = append(mf.body,
+				func(model reflect.Value, body []byte, r *http.Request) error {
+					f := fieldByIndex(model, field.Index)
+					ct := r.Header.Get("Content-Type")
+					if ct == "" {
+						ct = options.defaultContentType
+					}
+					if formUnpacker.deepObject != nil && ct == "application/x-www-form-urlencoded" {
+						if err := options.checkFormLimit(body); err != nil {
+							return err
+						}
+						values, err := url.ParseQuery(string(body))
+						if err != nil {
+							return errors.Wrap(err, "could not parse application/x-www-form-urlencoded data")
+						}
+						return errors.Wrapf(formUnpacker.deepObject(f, values), "Could not decode %s into %s", ct, field.Type)
+					}
+					exactDecoder, ok := options.decoderFor(r.Method, ct)
+					if !ok {
+						return errors.Errorf("No body decoder for content type %s", ct)
+					}
+					if discriminated != nil {
+						return errors.Wrapf(options.decodeDiscriminated(exactDecoder, body, f, discriminated),
+							"Could not decode %s into %s", ct, field.Type)
+					}
+					// nolint:govet
+					err := exactDecoder(body, f.Addr().Interface())
+					if err != nil {
+						var typeError *json.UnmarshalTypeError
+						if errors.As(err, &typeError) {
+							err = options.unprocessable(err)
+						}
+					}
+					return errors.Wrapf(err, "Could not decode %s into %s", ct, field.Type)
+				})
+			if tags.ErrMsg != "" {
+				filler := mf.body[len(mf.body)-1]
+				mf.body[len(mf.body)-1] = func(model reflect.Value, body []byte, r *http.Request) error {
+					return WithClientMessage(filler(model, body, r), tags.ErrMsg)
+				}
+			}
+			return false
+		}
+
+		name := parameterName(field, tags)
+		if tags.Presence {
+			returnError = presenceFiller(mf, field, name, tags)
+			return false
+		}
+		if tags.Prefix != "" {
+			returnError = prefixFiller(mf, field, tags, *options)
+			return false
+		}
+		if tags.Base == "query" && (tags.Form || tags.FormOnly) {
+			tags.BracketKeys = true
+		}
+		unpacker, err := getUnpacker(field.Type, field.Name, name, tags.Base, tags, *options)
+		if err != nil {
+			returnError = err
+			return false
+		}
+		if tags.Scheme != "" {
+			unpacker, err = authSchemeUnpacker(field, tags, unpacker)
+			if err != nil {
+				returnError = err
+				return false
+			}
+		}
+		if tags.Join != "" {
+			unpacker, err = joinUnpacker(field, tags, unpacker)
+			if err != nil {
+				returnError = err
+				return false
+			}
+		}
+		if tags.ErrMsg != "" {
+			unpacker = errMsgUnpacker(tags.ErrMsg, unpacker)
+		}
+		if tags.Default != "" {
+			defaultFiller, err := makeDefaultFiller(field, name, tags, unpacker)
+			if err != nil {
+				returnError = err
+				return false
+			}
+			mf.defaults = append(mf.defaults, defaultFiller)
+		}
+		switch tags.Base {
+		case "path":
+			mf.vars = append(mf.vars, func(model reflect.Value, routeVarLookup RouteVarLookup) error {
+				f := fieldByIndex(model, field.Index)
+				value := routeVarLookup(name)
+				if options.urlDecodePathVars {
+					var err error
+					value, err = url.PathUnescape(value)
+					if err != nil {
+						return wrapFieldError(err, "path element", name, field.Name)
+					}
+				}
+				if options.normalizePathVars {
+					value = strings.TrimRight(strings.TrimSpace(value), "/")
+				}
+				return wrapFieldError(unpacker.single("path", f, value), "path element", name, field.Name)
+			})
+		case "header":
+			if tags.Fold && (unpacker.multi == nil || field.Type.Kind() == reflect.Map) {
+				returnError = errors.Errorf("fold=true requires an exploded slice, field %s", field.Name)
+				return false
+			}
+			if unpacker.multi != nil {
+				mf.header = append(mf.header, func(model reflect.Value, header http.Header) error {
+					f := fieldByIndex(model, field.Index)
+					values, ok := header[name]
+					if !ok {
+						return nil
+					}
+					if tags.Fold {
+						values = foldHeaderValues(values)
+					}
+					return wrapFieldError(unpacker.multi("header", f, values), "header", name, field.Name)
+				})
+			} else {
+				mf.header = append(mf.header, func(model reflect.Value, header http.Header) error {
+					f := fieldByIndex(model, field.Index)
+					values, ok := header[name]
+					if !ok || len(values) == 0 {
+						return nil
+					}
+					return wrapFieldError(unpacker.single("header", f, values[0]), "header", name, field.Name)
+				})
+			}
+		case "query":
+			switch {
+			case unpacker.deepObject != nil:
+				mf.deepObject[name] = func(model reflect.Value, mapValues map[string][]string) error {
+					f := fieldByIndex(model, field.Index)
+					err := unpacker.deepObject(f, mapValues)
+					if err != nil {
+						return &FieldError{Field: name, Err: err}
+					}
+					return nil
+				}
+			case unpacker.multi != nil:
+				mf.query[name] = func(model reflect.Value, values []string) error {
+					f := fieldByIndex(model, field.Index)
+					return wrapFieldError(unpacker.multi("query", f, values), "query parameter", name, field.Name)
+				}
+			default:
+				mf.query[name] = func(model reflect.Value, values []string) error {
+					if len(values) == 0 {
+						return nil
+					}
+					f := fieldByIndex(model, field.Index)
+					return wrapFieldError(unpacker.single("query", f, values[0]), "query parameter", name, field.Name)
+				}
+			}
+			if tags.Form || tags.FormOnly {
+				if unpacker.deepObject != nil {
+					mf.deepObjectForm[name] = mf.deepObject[name]
+					if tags.FormOnly {
+						delete(mf.deepObject, name)
+					}
+				} else {
+					mf.queryForm[name] = mf.query[name]
+					if tags.FormOnly {
+						delete(mf.query, name)
+					}
+				}
+			}
+		case "cookie":
+			mf.cookie = append(mf.cookie, func(model reflect.Value, r *http.Request) error {
+				f := fieldByIndex(model, field.Index)
+				cookie, err := r.Cookie(name)
+				if err != nil {
+					if errors.Is(err, http.ErrNoCookie) {
+						return nil
+					}
+					return wrapFieldError(err, "cookie parameter", name, field.Name)
+				}
+				value := cookie.Value
+				if options.cookieCodec != nil {
+					value, err = options.cookieCodec(name, value)
+					if err != nil {
+						return &FieldError{Field: name, Err: errors.Wrapf(err, "cookie parameter %s", name)}
+					}
+				}
+				return wrapFieldError(unpacker.single("cookie", f, value), "cookie parameter", name, field.Name)
+			})
+		case "method", "host", "scheme":
+			source := tags.Base
+			if unpacker.single == nil {
+				returnError = errors.Errorf("%s cannot be decoded into %s, field %s", source, field.Type, field.Name)
+				return false
+			}
+			trust := options.trustForwardedHeaders
+			mf.request = append(mf.request, func(model reflect.Value, r *http.Request) error {
+				value := requestValue(source, r, trust)
+				return wrapFieldError(unpacker.single(source, fieldByIndex(model, field.Index), value), "decode", source, field.Name)
+			})
+		}
+		// The fields inside a tagged struct are filled by
+		// its unpacker, not as top-level fields.
+		return false
+	})
+	if returnError != nil {
+		return nil, returnError
+	}
+	return mf, nil
+}
+
+// generateStructUnpacker generates a function to deal with filling a struct from
+// an array of key, value pairs.
+func generateStructUnpacker(
+	base string,
+	fieldType reflect.Type,
 	tagName string,
 	outerTags tags,
 	options eigo,
@@ -554,6 +1838,10 @@ func generateStructUnpacker(
 	type fillTarget struct {
 		field reflect.StructField
 		unpack
+		// repeated is used for deepObject slices when a key is repeated
+		repeated func(from string, target reflect.Value, values []string) error
+		// nested is used for dotted keys that reach into struct and map members
+		nested func(target reflect.Value, mapValues map[string][]string) error
 	}
 	targets := make(map[string]fillTarget)
 	var anyErr error
@@ -586,10 +1874,44 @@ func generateStructUnpacker(
 			anyErr = errors.Wrap(err, field.Name)
 			return false
 		}
-		targets[tags.Base] = fillTarget{
+		target := fillTarget{
 			field:  field,
 			unpack: unpacker,
 		}
+		if outerTags.DeepObject && unpacker.single != nil && !tags.Explode && base == "query" {
+			elem := field.Type
+			for elem.Kind() == reflect.Ptr {
+				elem = elem.Elem()
+			}
+			if elem.Kind() == reflect.Slice || elem.Kind() == reflect.Array {
+				exploded := tags
+				exploded.Explode = true
+				repeated, err := getUnpacker(field.Type, field.Name, tags.Base, base, exploded, options)
+				if err != nil {
+					anyErr = errors.Wrap(err, field.Name)
+					return false
+				}
+				target.repeated = repeated.multi
+			}
+		}
+		if outerTags.DeepObject && (options.dottedQueryKeys || outerTags.BracketKeys) && base == "query" {
+			elem := field.Type
+			for elem.Kind() == reflect.Ptr {
+				elem = elem.Elem()
+			}
+			if elem.Kind() == reflect.Struct || elem.Kind() == reflect.Map {
+				deep := tags
+				deep.DeepObject = true
+				deep.BracketKeys = outerTags.BracketKeys
+				nested, err := getUnpacker(field.Type, field.Name, tags.Base, base, deep, options)
+				if err != nil {
+					anyErr = errors.Wrap(err, field.Name)
+					return false
+				}
+				target.nested = nested.deepObject
+			}
+		}
+		targets[tags.Base] = target
 		return true
 	})
 	if anyErr != nil {
@@ -597,6 +1919,11 @@ func generateStructUnpacker(
 	}
 	return unpack{
 		multi: func(from string, model reflect.Value, values []string) error {
+			if options.strictMapPairs {
+				if err := checkPairs(values); err != nil {
+					return err
+				}
+			}
 			for i := 0; i < len(values); i += 2 {
 				keyString := values[i]
 				var valueString string
@@ -619,8 +1946,29 @@ func generateStructUnpacker(
 			return nil
 		},
 		deepObject: func(model reflect.Value, mapValues map[string][]string) error {
+			var nested map[string]map[string][]string
 			for keyString, values := range mapValues {
 				target, ok := targets[keyString]
+				if !ok && (options.dottedQueryKeys || outerTags.BracketKeys) {
+					var prefix, rest string
+					var found bool
+					if outerTags.BracketKeys {
+						prefix, rest, found = splitBracketKey(keyString)
+					}
+					if !found && options.dottedQueryKeys {
+						prefix, rest, found = strings.Cut(keyString, ".")
+					}
+					if found && targets[prefix].nested != nil {
+						if nested == nil {
+							nested = make(map[string]map[string][]string)
+						}
+						if nested[prefix] == nil {
+							nested[prefix] = make(map[string][]string)
+						}
+						nested[prefix][rest] = values
+						continue
+					}
+				}
 				if !ok {
 					if options.rejectUnknownQueryParameters {
 						return errors.Errorf("No struct member to receive key '%s'", keyString)
@@ -629,19 +1977,29 @@ func generateStructUnpacker(
 				}
 				f := model.FieldByIndex(target.field.Index)
 				var err error
-				if target.single != nil {
+				switch {
+				case len(values) > 1 && target.repeated != nil:
+					err = target.repeated("query", f, values)
+				case target.single != nil:
 					if len(values) > 0 {
 						err = target.single("query", f, values[0])
 					}
-				} else {
+				default:
 					err = target.multi("query", f, values)
 				}
 				if err != nil {
 					return errors.Wrap(err, target.field.Name)
 				}
 			}
-			return nil
-		},
+			for prefix, values := range nested {
+				target := targets[prefix]
+				err := target.nested(model.FieldByIndex(target.field.Index), values)
+				if err != nil {
+					return errors.Wrap(err, target.field.Name)
+				}
+			}
+			return nil
+		},
 	}, nil
 }
 
@@ -650,7 +2008,13 @@ func mapUnpack(
 	keyUnpack func(from string, target reflect.Value, value string) error,
 	valueUnpack func(from string, target reflect.Value, value string) error,
 	values []string,
+	strict bool,
 ) error {
+	if strict {
+		if err := checkPairs(values); err != nil {
+			return err
+		}
+	}
 	m := reflect.MakeMap(f.Type())
 	for i := 0; i < len(values); i += 2 {
 		keyString := values[i]
@@ -711,6 +2075,64 @@ func arrayUnpack(
 	return nil
 }
 
+// exactArrayUnpack implements exactItems=true: the number of values
+// must match the length of the array
+func exactArrayUnpack(
+	from string, f reflect.Value,
+	singleUnpack func(from string, target reflect.Value, value string) error,
+	values []string,
+) error {
+	if len(values) != f.Len() {
+		return errors.Errorf("exactly %d values are required, got %d", f.Len(), len(values))
+	}
+	return arrayUnpack(from, f, singleUnpack, values)
+}
+
+// maxSparseIndex limits the size of slices filled by index so
+// that a request cannot force a huge allocation
+const maxSparseIndex = 1000
+
+// indexUnpack fills a slice or array from values keyed by
+// their index, eg "a[2]=x".  Missing indexes are left zero.
+func indexUnpack(
+	f reflect.Value,
+	singleUnpack func(from string, target reflect.Value, value string) error,
+	mapValues map[string][]string,
+) error {
+	indexes := make(map[int]string, len(mapValues))
+	maxIndex := -1
+	for key, values := range mapValues {
+		i, err := strconv.Atoi(key)
+		if err != nil || i < 0 {
+			return errors.Errorf("invalid index '%s'", key)
+		}
+		if i >= maxSparseIndex || (f.Kind() == reflect.Array && i >= f.Len()) {
+			return errors.Errorf("index %d out of range", i)
+		}
+		if len(values) == 0 {
+			continue
+		}
+		indexes[i] = values[0]
+		if i > maxIndex {
+			maxIndex = i
+		}
+	}
+	a := f
+	if f.Kind() == reflect.Slice {
+		a = reflect.MakeSlice(f.Type(), maxIndex+1, maxIndex+1)
+	} else {
+		a.Set(reflect.Zero(f.Type()))
+	}
+	for i, value := range indexes {
+		err := singleUnpack("query", a.Index(i), value)
+		if err != nil {
+			return err
+		}
+	}
+	f.Set(a)
+	return nil
+}
+
 type unpack struct {
 	createMe   bool
 	single     func(from string, target reflect.Value, value string) error
@@ -727,18 +2149,42 @@ func getUnpacker(
 	tags tags,
 	options eigo,
 ) (unpack, error) {
+	if tags.JWT {
+		return jwtUnpacker(fieldType, fieldName, base, tags, options)
+	}
 	if tags.Content != "" {
 		return contentUnpacker(fieldType, fieldName, name, base, tags, options)
 	}
+	if tags.Encoding != "" {
+		return unpack{}, errors.Errorf("Cannot decode into %s: encoding=%s requires content=", fieldName, tags.Encoding)
+	}
+	if parse, ok := lookupEnum(fieldType); ok {
+		return enumUnpacker(fieldType, name, parse), nil
+	}
+	if fieldType == urlType || fieldType == urlPtrType {
+		return unpack{
+			createMe: true,
+			single: func(from string, target reflect.Value, value string) error {
+				u, err := url.Parse(value)
+				if err != nil {
+					return wrapDecodeError(err, from, name)
+				}
+				if fieldType == urlType {
+					target.Set(reflect.ValueOf(*u))
+				} else {
+					target.Set(reflect.ValueOf(u))
+				}
+				return nil
+			},
+		}, nil
+	}
 	if fieldType.AssignableTo(textUnmarshallerType) {
 		return unpack{
 			createMe: true,
 			single: func(from string, target reflect.Value, value string) error {
 				p := reflect.New(fieldType.Elem())
 				target.Set(p)
-				return errors.Wrapf(
-					target.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value)),
-					"decode %s %s", from, name)
+				return wrapDecodeError(target.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value)), from, name)
 			},
 		}, nil
 	}
@@ -746,9 +2192,25 @@ func getUnpacker(
 		return unpack{
 			createMe: true,
 			single: func(from string, target reflect.Value, value string) error {
-				return errors.Wrapf(
-					target.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value)),
-					"decode %s %s", from, name)
+				return wrapDecodeError(target.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value)), from, name)
+			},
+		}, nil
+	}
+	if fieldType.Kind() == reflect.Ptr && fieldType.AssignableTo(flagValueType) {
+		return unpack{
+			createMe: true,
+			single: func(from string, target reflect.Value, value string) error {
+				p := reflect.New(fieldType.Elem())
+				target.Set(p)
+				return wrapDecodeError(target.Interface().(flag.Value).Set(value), from, name)
+			},
+		}, nil
+	}
+	if reflect.PointerTo(fieldType).AssignableTo(flagValueType) {
+		return unpack{
+			createMe: true,
+			single: func(from string, target reflect.Value, value string) error {
+				return wrapDecodeError(target.Addr().Interface().(flag.Value).Set(value), from, name)
 			},
 		}, nil
 	}
@@ -785,12 +2247,67 @@ func getUnpacker(
 		reflect.String,
 		reflect.Complex64, reflect.Complex128,
 		reflect.Bool:
+		if tags.BoolStyle == "lenient" && fieldType.Kind() == reflect.Bool {
+			return unpack{single: func(from string, target reflect.Value, value string) error {
+				b, err := parseLenientBool(value)
+				if err != nil {
+					return wrapDecodeError(err, from, name)
+				}
+				target.SetBool(b)
+				return nil
+			}}, nil
+		}
 		f, err := reflectutils.MakeStringSetter(fieldType)
 		if err != nil {
 			return unpack{}, errors.Wrapf(err, "Cannot decode into %s, %s", fieldName, fieldType)
 		}
+		if tags.NumberFormat == "grouped" {
+			// nolint:exhaustive
+			switch fieldType.Kind() {
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+				reflect.Uint, reflect.Uintptr, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+				reflect.Float32, reflect.Float64:
+			default:
+				return unpack{}, errors.Errorf("Cannot decode into %s: numberFormat=grouped is only supported for numbers, not %s", fieldName, fieldType)
+			}
+			setter := f
+			f = func(target reflect.Value, value string) error {
+				plain, err := ungroupNumber(value)
+				if err != nil {
+					return err
+				}
+				return setter(target, plain)
+			}
+		}
+		check, err := makeRangeChecker(fieldType, tags)
+		if err != nil {
+			return unpack{}, errors.Wrapf(err, "Cannot decode into %s", fieldName)
+		}
+		if tags.Format != "" {
+			checkFormat, err := makeFormatChecker(fieldType, tags)
+			if err != nil {
+				return unpack{}, errors.Wrapf(err, "Cannot decode into %s", fieldName)
+			}
+			check = combineCheckers(check, checkFormat)
+		}
+		if tags.MinLength != nil || tags.MaxLength != nil {
+			checkLength, err := makeLengthChecker(fieldType, tags)
+			if err != nil {
+				return unpack{}, errors.Wrapf(err, "Cannot decode into %s", fieldName)
+			}
+			check = combineCheckers(check, checkLength)
+		}
+		if check != nil {
+			return unpack{single: func(from string, target reflect.Value, value string) error {
+				err := f(target, value)
+				if err == nil {
+					err = check(target)
+				}
+				return wrapDecodeError(err, from, name)
+			}}, nil
+		}
 		return unpack{single: func(from string, target reflect.Value, value string) error {
-			return errors.Wrapf(f(target, value), "decode %s %s", from, name)
+			return wrapDecodeError(f(target, value), from, name)
 		}}, nil
 
 	case reflect.Slice, reflect.Array:
@@ -803,17 +2320,31 @@ func getUnpacker(
 				return unpack{}, errors.New("explode=true not supported for cookies & path parameters")
 			}
 		}
-		if tags.DeepObject {
-			return unpack{}, errors.New("deepObject=true not supported for slices")
+		if tags.DeepObject && base != "query" {
+			return unpack{}, errors.Errorf("deepObject=true not supported for %s", base)
 		}
 
-		singleUnpack, err := getUnpacker(fieldType.Elem(), fieldName, name, base, tags.WithoutExplode(), options)
+		singleUnpack, err := getUnpacker(fieldType.Elem(), fieldName, name, base, tags.WithoutExplode().WithoutDeepObject(), options)
 		if err != nil {
 			return unpack{}, err
 		}
+		if tags.DeepObject {
+			if tags.ExactItems {
+				return unpack{}, errors.Errorf("Cannot decode into %s: exactItems=true cannot be combined with deepObject=true", fieldName)
+			}
+			if singleUnpack.single == nil {
+				return unpack{}, errors.Errorf("Cannot decode into %s, %s: deepObject=true requires simple elements", fieldName, fieldType)
+			}
+			return unpack{deepObject: func(target reflect.Value, mapValues map[string][]string) error {
+				return wrapDecodeError(indexUnpack(target, singleUnpack.single, mapValues), "query", name)
+			}}, nil
+		}
 		unslicer := sliceUnpack
 		if fieldType.Kind() == reflect.Array {
 			unslicer = arrayUnpack
+			if tags.ExactItems {
+				unslicer = exactArrayUnpack
+			}
 		}
 		switch base {
 		case "query", "header":
@@ -825,6 +2356,9 @@ func getUnpacker(
 				}, nil
 			}
 		}
+		if tags.NumberFormat == "grouped" && tags.Delimiter == "," {
+			return unpack{}, errors.Errorf("Cannot decode into %s: numberFormat=grouped requires a delimiter other than comma", fieldName)
+		}
 		return unpack{single: func(from string, target reflect.Value, value string) error {
 			values := strings.Split(value, tags.Delimiter)
 			return unslicer(from, target, singleUnpack.single, values)
@@ -850,6 +2384,12 @@ func getUnpacker(
 					},
 				}, nil
 			}
+		case "cookie":
+			if tags.Explode {
+				return unpack{single: func(from string, target reflect.Value, value string) error {
+					return structUnpacker.multi(from, target, resplitOnEquals(strings.Split(value, tags.Delimiter)))
+				}}, nil
+			}
 		}
 		return unpack{single: func(from string, target reflect.Value, value string) error {
 			values := strings.Split(value, tags.Delimiter)
@@ -863,7 +2403,7 @@ func getUnpacker(
 				return unpack{}, errors.New("delimiter setting is only allowed for 'query' parameters")
 			}
 		}
-		keyUnpack, err := getUnpacker(fieldType.Key(), fieldName, name, base, tags.WithoutExplode().WithoutDeepObject(), options)
+		keyUnpack, err := getUnpacker(fieldType.Key(), fieldName, name, base, tags.WithoutExplode().WithoutDeepObject().WithoutRange(), options)
 		if err != nil {
 			return unpack{}, err
 		}
@@ -913,14 +2453,20 @@ func getUnpacker(
 			if tags.Explode {
 				return unpack{
 					multi: func(from string, target reflect.Value, values []string) error {
-						return mapUnpack(from, target, keyUnpack.single, elementUnpack.single, resplitOnEquals(values))
+						return mapUnpack(from, target, keyUnpack.single, elementUnpack.single, resplitOnEquals(values), false)
 					},
 				}, nil
 			}
+		case "cookie":
+			if tags.Explode {
+				return unpack{single: func(from string, target reflect.Value, value string) error {
+					return mapUnpack(from, target, keyUnpack.single, elementUnpack.single, resplitOnEquals(strings.Split(value, tags.Delimiter)), false)
+				}}, nil
+			}
 		}
 		return unpack{single: func(from string, target reflect.Value, value string) error {
 			values := strings.Split(value, tags.Delimiter)
-			return mapUnpack(from, target, keyUnpack.single, elementUnpack.single, values)
+			return mapUnpack(from, target, keyUnpack.single, elementUnpack.single, values, options.strictMapPairs)
 		}}, nil
 
 	case reflect.Chan, reflect.Interface, reflect.UnsafePointer, reflect.Func, reflect.Invalid:
@@ -946,6 +2492,13 @@ func contentUnpacker(
 	options eigo,
 ) (unpack, error) {
 	decoder, ok := options.decoders[tags.Content]
+	if tagDecoder, tok := options.tagDecoders[tags.Content]; tok {
+		exported := tags.export()
+		decoder = func(data []byte, target interface{}) error {
+			return tagDecoder(data, target, exported)
+		}
+		ok = true
+	}
 	if !ok {
 		// tags.Content can provide access to decoders beyond what
 		// is specified for GenerateDecoder
@@ -956,10 +2509,26 @@ func contentUnpacker(
 			decoder = xml.Unmarshal
 		case "application/yaml", "text/yaml":
 			decoder = yaml.Unmarshal
+		case "text/plain":
+			decoder = decodeText
 		default:
 			return unpack{}, errors.Errorf("No decoder provided for content type '%s'", tags.Content)
 		}
 	}
+	if tags.Encoding != "" {
+		unencode, err := contentEncoding(tags.Encoding, options.maxDecompressedSize)
+		if err != nil {
+			return unpack{}, errors.Wrapf(err, "Cannot decode into %s", fieldName)
+		}
+		contentDecoder := decoder
+		decoder = func(data []byte, target interface{}) error {
+			data, err := unencode(data)
+			if err != nil {
+				return err
+			}
+			return contentDecoder(data, target)
+		}
+	}
 	kind := fieldType.Kind()
 	if tags.Explode &&
 		(base == "query" || base == "header") &&
@@ -982,7 +2551,7 @@ func contentUnpacker(
 				return nil
 			}}, nil
 		}
-		keyUnpack, err := getUnpacker(fieldType.Key(), fieldName, name, base, tags.WithoutExplode().WithoutContent().WithoutDeepObject(), options)
+		keyUnpack, err := getUnpacker(fieldType.Key(), fieldName, name, base, tags.WithoutExplode().WithoutContent().WithoutDeepObject().WithoutRange(), options)
 		if err != nil {
 			return unpack{}, err
 		}
@@ -1019,19 +2588,118 @@ func contentUnpacker(
 	}}, nil
 }
 
+const defaultMaxDecompressedSize = 1 << 20
+
+// contentEncoding returns a function to undo encoding=.  Encodings
+// can be combined with "+" and are undone in order.
+func contentEncoding(encoding string, maxSize int64) (func([]byte) ([]byte, error), error) {
+	var steps []func([]byte) ([]byte, error)
+	for _, step := range strings.Split(encoding, "+") {
+		switch step {
+		case "base64":
+			steps = append(steps, decodeBase64)
+		case "gzip":
+			steps = append(steps, func(data []byte) ([]byte, error) {
+				return gunzip(data, maxSize)
+			})
+		default:
+			return nil, errors.Errorf("encoding '%s' is not supported", step)
+		}
+	}
+	return func(data []byte) ([]byte, error) {
+		var err error
+		for _, step := range steps {
+			data, err = step(data)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return data, nil
+	}, nil
+}
+
+// decodeBase64 accepts both standard and URL-safe base64, with
+// or without padding
+func decodeBase64(data []byte) ([]byte, error) {
+	s := strings.TrimRight(string(data), "=")
+	enc := base64.RawStdEncoding
+	if strings.ContainsAny(s, "-_") {
+		enc = base64.RawURLEncoding
+	}
+	decoded, err := enc.DecodeString(s)
+	return decoded, errors.Wrap(err, "base64")
+}
+
+func gunzip(data []byte, maxSize int64) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Wrap(err, "gzip")
+	}
+	decompressed, err := io.ReadAll(io.LimitReader(zr, maxSize+1))
+	if err != nil {
+		return nil, errors.Wrap(err, "gzip")
+	}
+	if int64(len(decompressed)) > maxSize {
+		return nil, errors.Errorf("decompressed content exceeds %d bytes", maxSize)
+	}
+	return decompressed, nil
+}
+
+// decodeText is a Decoder that uses UnmarshalText if the target
+// supports it and otherwise sets the target from the text as if
+// there was no content= tag.
+func decodeText(data []byte, target interface{}) error {
+	v := reflect.ValueOf(target)
+	for {
+		if tu, ok := v.Interface().(encoding.TextUnmarshaler); ok {
+			return tu.UnmarshalText(data)
+		}
+		e := v.Elem()
+		if e.Kind() != reflect.Ptr {
+			break
+		}
+		if e.IsNil() {
+			e.Set(reflect.New(e.Type().Elem()))
+		}
+		v = e
+	}
+	setter, err := reflectutils.MakeStringSetter(v.Elem().Type())
+	if err != nil {
+		return err
+	}
+	return setter(v.Elem(), string(data))
+}
+
 var (
 	rvlType              = reflect.TypeOf(RouteVarLookup(nil))
 	httpRequestType      = reflect.TypeOf(&http.Request{})
 	bodyType             = reflect.TypeOf(Body{})
+	bodyStatusType       = reflect.TypeOf(&BodyStatus{})
 	textUnmarshallerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	flagValueType        = reflect.TypeOf((*flag.Value)(nil)).Elem()
+	urlType              = reflect.TypeOf(url.URL{})
+	urlPtrType           = reflect.TypeOf(&url.URL{})
 	terminalErrorType    = reflect.TypeOf((*nject.TerminalError)(nil)).Elem()
 	errorType            = reflect.TypeOf((*error)(nil)).Elem()
+	basicLoggerType      = reflect.TypeOf((*BasicLogger)(nil)).Elem()
+	deferredWriterType   = reflect.TypeOf(&DeferredWriter{})
 )
 
 var delimiters = map[string]string{
-	"comma": ",",
-	"pipe":  "|",
-	"space": " ",
+	"comma":     ",",
+	"pipe":      "|",
+	"space":     " ",
+	"semicolon": ";",
+	"tab":       "\t",
+}
+
+// styles maps OpenAPI style names to the delimiter they imply
+var styles = map[string]string{
+	"form":           ",",
+	"simple":         ",",
+	"spaceDelimited": " ",
+	"pipeDelimited":  "|",
+	"deepObject":     "",
 }
 
 type tags struct {
@@ -1039,23 +2707,100 @@ type tags struct {
 	Name          string `pt:"name"`
 	ExplodeP      *bool  `pt:"explode"`
 	Explode       bool
-	Delimiter     string `pt:"delimiter"`
-	AllowReserved bool   `pt:"allowReserved"`
-	Form          bool   `pt:"form"`
-	FormOnly      bool   `pt:"formOnly"`
-	Content       string `pt:"content"`
-	DeepObject    bool   `pt:"deepObject"`
+	Delimiter     string   `pt:"delimiter"`
+	Style         string   `pt:"style"`
+	AllowReserved bool     `pt:"allowReserved"`
+	Form          bool     `pt:"form"`
+	FormOnly      bool     `pt:"formOnly"`
+	Content       string   `pt:"content"`
+	DeepObject    bool     `pt:"deepObject"`
+	Default       string   `pt:"default"`
+	Fold          bool     `pt:"fold"`
+	Scheme        string   `pt:"scheme"`
+	Minimum       *float64 `pt:"minimum"`
+	Maximum       *float64 `pt:"maximum"`
+	ExclusiveMin  *float64 `pt:"exclusiveMin"`
+	ExclusiveMax  *float64 `pt:"exclusiveMax"`
+	MultipleOf    *float64 `pt:"multipleOf"`
+	Format        string   `pt:"format"`
+	MinLength     *int     `pt:"minLength"`
+	MaxLength     *int     `pt:"maxLength"`
+	ByteLength    bool     `pt:"byteLength"`
+	Required      bool     `pt:"required"`
+	Presence      bool     `pt:"presence"`
+	Prefix        string   `pt:"prefix"`
+	RejectUnknown *bool    `pt:"rejectUnknown"`
+	Join          string   `pt:"join"`
+	ErrMsg        string   `pt:"errMsg"`
+	Encoding      string   `pt:"encoding"`
+	Layout        string   `pt:"layout"`
+	BoolStyle     string   `pt:"boolStyle"`
+	JWT           bool     `pt:"jwt"`
+	ExactItems    bool     `pt:"exactItems"`
+	NumberFormat  string   `pt:"numberFormat"`
+	// BracketKeys is set for form bodies: deepObject keys like
+	// "user[address][city]" reach into nested members
+	BracketKeys bool
 }
 
 func (tags tags) WithoutExplode() tags    { tags.Explode = false; return tags }
 func (tags tags) WithoutContent() tags    { tags.Content = ""; return tags }
 func (tags tags) WithoutDeepObject() tags { tags.DeepObject = false; return tags }
+func (tags tags) WithoutRange() tags {
+	tags.Minimum = nil
+	tags.Maximum = nil
+	tags.ExclusiveMin = nil
+	tags.ExclusiveMax = nil
+	tags.MultipleOf = nil
+	tags.Format = ""
+	tags.MinLength = nil
+	tags.MaxLength = nil
+	return tags
+}
 
 func parseTag(tag reflectutils.Tag) (tags tags, err error) {
-	tags.Delimiter = ","
 	err = tag.Fill(&tags)
+	// remember whether a delimiter was given so that style= can
+	// reject an explicit delimiter=comma
+	explicitDelimiter := tags.Delimiter != ""
+	if !explicitDelimiter {
+		tags.Delimiter = ","
+	}
 	if replace, ok := delimiters[tags.Delimiter]; ok {
 		tags.Delimiter = replace
+	} else if strings.Contains(tags.Delimiter, "%") {
+		unescaped, uErr := url.PathUnescape(tags.Delimiter)
+		if uErr != nil {
+			return tags, errors.Wrapf(uErr, "invalid delimiter '%s'", tags.Delimiter)
+		}
+		tags.Delimiter = unescaped
+	}
+	if replace, ok := delimiters[tags.Join]; ok {
+		tags.Join = replace
+	}
+	if tags.Style != "" {
+		delimiter, ok := styles[tags.Style]
+		if !ok {
+			return tags, errors.Errorf("style=%s is not supported", tags.Style)
+		}
+		if tags.Style == "deepObject" {
+			tags.DeepObject = true
+		} else {
+			if explicitDelimiter && tags.Delimiter != delimiter {
+				return tags, errors.Errorf("style=%s conflicts with delimiter '%s'", tags.Style, tags.Delimiter)
+			}
+			tags.Delimiter = delimiter
+		}
+	}
+	switch tags.BoolStyle {
+	case "", "strict", "lenient":
+	default:
+		return tags, errors.Errorf("boolStyle=%s is not supported", tags.BoolStyle)
+	}
+	switch tags.NumberFormat {
+	case "", "strict", "grouped":
+	default:
+		return tags, errors.Errorf("numberFormat=%s is not supported", tags.NumberFormat)
 	}
 	if tags.ExplodeP != nil {
 		tags.Explode = *tags.ExplodeP
@@ -1068,6 +2813,819 @@ func parseTag(tag reflectutils.Tag) (tags tags, err error) {
 	return tags, err
 }
 
+// makeDefaultFiller returns a function that fills a field with
+// its default value.  The default is decoded once up front so that
+// invalid defaults are reported when the decoder is generated.
+func makeDefaultFiller(field reflect.StructField, name string, tags tags, unpacker unpack) (func(model reflect.Value) error, error) {
+	var fill func(f reflect.Value) error
+	switch {
+	case unpacker.single != nil:
+		fill = func(f reflect.Value) error {
+			return unpacker.single(tags.Base, f, tags.Default)
+		}
+	case unpacker.multi != nil:
+		fill = func(f reflect.Value) error {
+			return unpacker.multi(tags.Base, f, []string{tags.Default})
+		}
+	default:
+		return nil, errors.Errorf("default is not supported for %s", field.Name)
+	}
+	if err := fill(reflect.New(field.Type).Elem()); err != nil {
+		return nil, errors.Wrapf(err, "invalid default for %s %s", tags.Base, name)
+	}
+	return func(model reflect.Value) error {
+		return fill(fieldByIndex(model, field.Index))
+	}, nil
+}
+
+// makeFormatChecker returns a function to enforce format=
+func makeFormatChecker(fieldType reflect.Type, tags tags) (func(reflect.Value) error, error) {
+	if fieldType.Kind() != reflect.String {
+		return nil, errors.Errorf("format is only supported for strings, not %s", fieldType)
+	}
+	validate, ok := lookupFormat(tags.Format)
+	if !ok {
+		return nil, errors.Errorf("format '%s' is not registered", tags.Format)
+	}
+	return func(v reflect.Value) error {
+		if validate(v.String()) != nil {
+			return &DecodeError{Constraint: "format", Bound: tags.Format, Value: v.Interface()}
+		}
+		return nil
+	}, nil
+}
+
+// makeLengthChecker returns a function to enforce minLength= and
+// maxLength=
+func makeLengthChecker(fieldType reflect.Type, tags tags) (func(reflect.Value) error, error) {
+	if fieldType.Kind() != reflect.String {
+		return nil, errors.Errorf("minLength and maxLength are only supported for strings, not %s", fieldType)
+	}
+	length := utf8.RuneCountInString
+	if tags.ByteLength {
+		length = func(s string) int { return len(s) }
+	}
+	return func(v reflect.Value) error {
+		n := length(v.String())
+		if tags.MinLength != nil && n < *tags.MinLength {
+			return &DecodeError{Constraint: "minLength", Bound: *tags.MinLength, Value: v.Interface()}
+		}
+		if tags.MaxLength != nil && n > *tags.MaxLength {
+			return &DecodeError{Constraint: "maxLength", Bound: *tags.MaxLength, Value: v.Interface()}
+		}
+		return nil
+	}, nil
+}
+
+// combineCheckers returns a checker that runs both checkers.  Either
+// may be nil.
+func combineCheckers(a, b func(reflect.Value) error) func(reflect.Value) error {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	return func(v reflect.Value) error {
+		if err := a(v); err != nil {
+			return err
+		}
+		return b(v)
+	}
+}
+
+// isMultipleOf checks if the float f is a whole multiple of m.  Since
+// neither can be represented exactly, the remainder may be off by the
+// rounding error of f: a few units in its last place, as given by ulp.
+func isMultipleOf(f float64, m float64, ulp float64) bool {
+	r := math.Abs(math.Mod(f, m))
+	epsilon := 4 * ulp
+	return r <= epsilon || m-r <= epsilon
+}
+
+// isIntMultipleOf checks if the integer i is a whole multiple of m.
+// When m is a whole number, the check is exact.
+func isIntMultipleOf(i int64, m float64) bool {
+	if m == math.Trunc(m) {
+		if m >= 0x1p63 {
+			return i == 0
+		}
+		return i%int64(m) == 0
+	}
+	return isMultipleOf(float64(i), m, ulp64(float64(i)))
+}
+
+// isUintMultipleOf is isIntMultipleOf for unsigned integers
+func isUintMultipleOf(u uint64, m float64) bool {
+	if m == math.Trunc(m) {
+		if m >= 0x1p64 {
+			return u == 0
+		}
+		return u%uint64(m) == 0
+	}
+	return isMultipleOf(float64(u), m, ulp64(float64(u)))
+}
+
+func ulp64(f float64) float64 {
+	f = math.Abs(f)
+	return math.Nextafter(f, math.Inf(1)) - f
+}
+
+func ulp32(f float64) float64 {
+	f32 := float32(math.Abs(f))
+	return float64(math.Nextafter32(f32, float32(math.Inf(1)))) - float64(f32)
+}
+
+// compareIntToFloat compares i to bound without converting i to
+// float64, which would round values beyond 2^53
+func compareIntToFloat(i int64, bound float64) int {
+	switch {
+	case bound >= 0x1p63:
+		return -1
+	case bound < -0x1p63:
+		return 1
+	}
+	floor := math.Floor(bound)
+	fi := int64(floor)
+	switch {
+	case i < fi:
+		return -1
+	case i > fi:
+		return 1
+	case floor < bound:
+		return -1
+	}
+	return 0
+}
+
+// compareUintToFloat is compareIntToFloat for unsigned integers
+func compareUintToFloat(u uint64, bound float64) int {
+	switch {
+	case bound < 0:
+		return 1
+	case bound >= 0x1p64:
+		return -1
+	}
+	floor := math.Floor(bound)
+	fu := uint64(floor)
+	switch {
+	case u < fu:
+		return -1
+	case u > fu:
+		return 1
+	case floor < bound:
+		return -1
+	}
+	return 0
+}
+
+// makeRangeChecker returns a function to enforce minimum=, maximum=,
+// exclusiveMin=, exclusiveMax=, and multipleOf= on a numeric value.  It
+// returns nil if there are no limits.
+func makeRangeChecker(fieldType reflect.Type, tags tags) (func(reflect.Value) error, error) {
+	if tags.Minimum == nil && tags.Maximum == nil && tags.ExclusiveMin == nil && tags.ExclusiveMax == nil && tags.MultipleOf == nil {
+		return nil, nil
+	}
+	if tags.MultipleOf != nil && *tags.MultipleOf <= 0 {
+		return nil, errors.Errorf("multipleOf must be greater than zero, not %v", *tags.MultipleOf)
+	}
+	var multipleOf func(v reflect.Value, m float64) bool
+	var compare func(v reflect.Value, bound float64) int
+	// nolint:exhaustive
+	switch fieldType.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		multipleOf = func(v reflect.Value, m float64) bool { return isIntMultipleOf(v.Int(), m) }
+		compare = func(v reflect.Value, bound float64) int { return compareIntToFloat(v.Int(), bound) }
+	case reflect.Uint, reflect.Uintptr, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		multipleOf = func(v reflect.Value, m float64) bool { return isUintMultipleOf(v.Uint(), m) }
+		compare = func(v reflect.Value, bound float64) int { return compareUintToFloat(v.Uint(), bound) }
+	case reflect.Float32, reflect.Float64:
+		ulp := ulp64
+		if fieldType.Kind() == reflect.Float32 {
+			ulp = ulp32
+		}
+		multipleOf = func(v reflect.Value, m float64) bool { return isMultipleOf(v.Float(), m, ulp(v.Float())) }
+		compare = func(v reflect.Value, bound float64) int {
+			f := v.Float()
+			switch {
+			case f < bound:
+				return -1
+			case f > bound:
+				return 1
+			}
+			return 0
+		}
+	default:
+		return nil, errors.Errorf("minimum, maximum, exclusiveMin, exclusiveMax, and multipleOf are only supported for numbers, not %s", fieldType)
+	}
+	return func(v reflect.Value) error {
+		if tags.Minimum != nil && compare(v, *tags.Minimum) < 0 {
+			return &DecodeError{Constraint: "minimum", Bound: *tags.Minimum, Value: v.Interface()}
+		}
+		if tags.Maximum != nil && compare(v, *tags.Maximum) > 0 {
+			return &DecodeError{Constraint: "maximum", Bound: *tags.Maximum, Value: v.Interface()}
+		}
+		if tags.ExclusiveMin != nil && compare(v, *tags.ExclusiveMin) <= 0 {
+			return &DecodeError{Constraint: "exclusiveMin", Bound: *tags.ExclusiveMin, Value: v.Interface()}
+		}
+		if tags.ExclusiveMax != nil && compare(v, *tags.ExclusiveMax) >= 0 {
+			return &DecodeError{Constraint: "exclusiveMax", Bound: *tags.ExclusiveMax, Value: v.Interface()}
+		}
+		if tags.MultipleOf != nil && !multipleOf(v, *tags.MultipleOf) {
+			return &DecodeError{Constraint: "multipleOf", Bound: *tags.MultipleOf, Value: v.Interface()}
+		}
+		return nil
+	}, nil
+}
+
+type discriminatedType struct {
+	typ     reflect.Type
+	pointer bool
+}
+
+// discriminatedTypes selects the discriminator types that can be
+// assigned to an interface field
+func (options *eigo) discriminatedTypes(field reflect.StructField) (map[string]discriminatedType, error) {
+	discriminated := make(map[string]discriminatedType)
+	for value, typ := range options.discriminatorTypes {
+		switch {
+		case typ.AssignableTo(field.Type):
+			discriminated[value] = discriminatedType{typ: typ}
+		case reflect.PointerTo(typ).AssignableTo(field.Type):
+			discriminated[value] = discriminatedType{typ: typ, pointer: true}
+		}
+	}
+	if len(discriminated) == 0 {
+		return nil, errors.Errorf("none of the types provided by WithDiscriminator implement %s, field %s", field.Type, field.Name)
+	}
+	return discriminated, nil
+}
+
+// decodeDiscriminated decodes a body into an interface field
+// using the discriminator field to pick the concrete type
+func (options *eigo) decodeDiscriminated(decoder Decoder, body []byte, f reflect.Value, discriminated map[string]discriminatedType) error {
+	var peek map[string]interface{}
+	err := decoder(body, &peek)
+	if err != nil {
+		return err
+	}
+	value, ok := peek[options.discriminatorField]
+	if !ok {
+		return errors.Errorf("discriminator '%s' is missing", options.discriminatorField)
+	}
+	valueString, ok := value.(string)
+	if !ok {
+		return errors.Errorf("discriminator '%s' must be a string", options.discriminatorField)
+	}
+	dt, ok := discriminated[valueString]
+	if !ok {
+		return errors.Errorf("discriminator '%s' value '%s' is not supported", options.discriminatorField, valueString)
+	}
+	p := reflect.New(dt.typ)
+	err = decoder(body, p.Interface())
+	if err != nil {
+		return err
+	}
+	if dt.pointer {
+		f.Set(p)
+	} else {
+		f.Set(p.Elem())
+	}
+	return nil
+}
+
+// errMsgUnpacker wraps an unpacker so that its errors carry a
+// message for the client
+func errMsgUnpacker(msg string, unpacker unpack) unpack {
+	wrapped := unpack{createMe: unpacker.createMe}
+	if unpacker.single != nil {
+		wrapped.single = func(from string, target reflect.Value, value string) error {
+			return WithClientMessage(unpacker.single(from, target, value), msg)
+		}
+	}
+	if unpacker.multi != nil {
+		wrapped.multi = func(from string, target reflect.Value, values []string) error {
+			return WithClientMessage(unpacker.multi(from, target, values), msg)
+		}
+	}
+	if unpacker.deepObject != nil {
+		wrapped.deepObject = func(target reflect.Value, mapValues map[string][]string) error {
+			return WithClientMessage(unpacker.deepObject(target, mapValues), msg)
+		}
+	}
+	return wrapped
+}
+
+// joinUnpacker wraps a single value unpacker so that repeated
+// values are joined into one value
+func joinUnpacker(field reflect.StructField, tags tags, unpacker unpack) (unpack, error) {
+	switch tags.Base {
+	case "query", "header":
+	default:
+		return unpack{}, errors.Errorf("join is only supported for query parameters and headers, field %s", field.Name)
+	}
+	if !tags.Explode {
+		return unpack{}, errors.Errorf("join requires explode=true, field %s", field.Name)
+	}
+	if unpacker.single == nil {
+		return unpack{}, errors.Errorf("join requires a field that holds a single value, field %s is %s", field.Name, field.Type)
+	}
+	return unpack{multi: func(from string, target reflect.Value, values []string) error {
+		return unpacker.single(from, target, strings.Join(values, tags.Join))
+	}}, nil
+}
+
+// walkModelFields is like reflectutils.WalkStructElements except
+// that it also descends into exported embedded pointers to structs.
+// Fields found that way must be fetched with fieldByIndex.  Unexported
+// embedded pointers are skipped: see hasUnreachableTags.
+func walkModelFields(t reflect.Type, f func(reflect.StructField) bool) {
+	doWalkModelFields(t, nil, map[reflect.Type]bool{t: true}, f)
+}
+
+func doWalkModelFields(t reflect.Type, path []int, seen map[reflect.Type]bool, f func(reflect.StructField) bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		field.Index = append(append(make([]int, 0, len(path)+1), path...), field.Index...)
+		if !f(field) {
+			continue
+		}
+		ft := field.Type
+		switch {
+		case ft.Kind() == reflect.Struct:
+		case field.Anonymous && field.IsExported() && ft.Kind() == reflect.Ptr && ft.Elem().Kind() == reflect.Struct:
+			ft = ft.Elem()
+			if seen[ft] {
+				continue
+			}
+		default:
+			continue
+		}
+		seen[ft] = true
+		doWalkModelFields(ft, field.Index, seen, f)
+		delete(seen, ft)
+	}
+}
+
+// hasUnreachableTags returns true if field is an unexported embedded
+// pointer to a struct that has tagged fields.  walkModelFields does
+// not descend into such pointers because they cannot be allocated.
+func hasUnreachableTags(field reflect.StructField, tagName string) bool {
+	if !field.Anonymous || field.IsExported() ||
+		field.Type.Kind() != reflect.Ptr || field.Type.Elem().Kind() != reflect.Struct {
+		return false
+	}
+	var found bool
+	walkModelFields(field.Type.Elem(), func(inner reflect.StructField) bool {
+		if _, ok := reflectutils.LookupTag(inner.Tag, tagName); ok || hasUnreachableTags(inner, tagName) {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// fieldByIndex is like reflect.Value.FieldByIndex except that nil
+// embedded pointers along the way are allocated rather than causing
+// a panic.
+func fieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+// parameterName returns the name of the request parameter
+// that fills a field.  Header names are canonicalized to
+// match http.Header.
+func parameterName(field reflect.StructField, tags tags) string {
+	name := field.Name
+	if tags.Name != "" {
+		name = tags.Name
+	}
+	if tags.Base == "header" {
+		name = http.CanonicalHeaderKey(name)
+	}
+	return name
+}
+
+// presenceFiller adds a filler that sets a bool field to true when
+// the header or query parameter is present, regardless of its value.
+func presenceFiller(mf *modelFillers, field reflect.StructField, name string, tags tags) error {
+	if field.Type.Kind() != reflect.Bool {
+		return errors.Errorf("presence=true requires a bool, field %s is %s", field.Name, field.Type)
+	}
+	switch tags.Base {
+	case "header":
+		mf.header = append(mf.header, func(model reflect.Value, header http.Header) error {
+			if _, ok := header[name]; ok {
+				fieldByIndex(model, field.Index).SetBool(true)
+			}
+			return nil
+		})
+	case "query":
+		filler := func(model reflect.Value, _ []string) error {
+			fieldByIndex(model, field.Index).SetBool(true)
+			return nil
+		}
+		if !tags.FormOnly {
+			mf.query[name] = filler
+		}
+		if tags.Form || tags.FormOnly {
+			mf.queryForm[name] = filler
+		}
+	default:
+		return errors.Errorf("presence=true is only supported for headers and query parameters, field %s", field.Name)
+	}
+	return nil
+}
+
+// prefixFiller adds a filler that collects all of the headers whose
+// names start with the prefix into a map.  The prefix is removed from
+// the map keys.  Map values are filled with all of the values of the
+// header if they are exploded slices, otherwise with the values joined
+// with ", ".
+func prefixFiller(mf *modelFillers, field reflect.StructField, tags tags, options eigo) error {
+	if tags.Base != "header" {
+		return errors.Errorf("prefix=%s is only supported for headers, field %s", tags.Prefix, field.Name)
+	}
+	mapType := field.Type
+	for mapType.Kind() == reflect.Ptr {
+		mapType = mapType.Elem()
+	}
+	if mapType.Kind() != reflect.Map || mapType.Key().Kind() != reflect.String {
+		return errors.Errorf("prefix=%s requires a map with string keys, field %s is %s", tags.Prefix, field.Name, field.Type)
+	}
+	valueUnpack, err := getUnpacker(mapType.Elem(), field.Name, tags.Prefix, "header", tags, options)
+	if err != nil {
+		return errors.Wrap(err, field.Name)
+	}
+	if valueUnpack.single == nil && valueUnpack.multi == nil {
+		return errors.Errorf("prefix=%s cannot fill map values of type %s, field %s", tags.Prefix, mapType.Elem(), field.Name)
+	}
+	prefix := http.CanonicalHeaderKey(tags.Prefix)
+	mf.header = append(mf.header, func(model reflect.Value, header http.Header) error {
+		var m reflect.Value
+		for key, values := range header {
+			if len(key) <= len(prefix) || !strings.EqualFold(key[:len(prefix)], prefix) {
+				continue
+			}
+			if !m.IsValid() {
+				m = reflect.MakeMap(mapType)
+			}
+			value := reflect.New(mapType.Elem()).Elem()
+			var err error
+			if valueUnpack.multi != nil {
+				err = valueUnpack.multi("header", value, values)
+			} else {
+				err = valueUnpack.single("header", value, strings.Join(values, ", "))
+			}
+			if err != nil {
+				return wrapFieldError(err, "header", key, field.Name)
+			}
+			m.SetMapIndex(reflect.ValueOf(key[len(prefix):]).Convert(mapType.Key()), value)
+		}
+		if !m.IsValid() {
+			return nil
+		}
+		f := fieldByIndex(model, field.Index)
+		for f.Kind() == reflect.Ptr {
+			if f.IsNil() {
+				f.Set(reflect.New(f.Type().Elem()))
+			}
+			f = f.Elem()
+		}
+		f.Set(m)
+		return nil
+	})
+	return nil
+}
+
+// authSchemeUnpacker wraps an unpacker so that an HTTP authentication
+// scheme, like "Bearer", is required and removed before unpacking.
+// With scheme=Basic, a struct target has its first field filled with
+// the user and its second field filled with the password.
+func authSchemeUnpacker(field reflect.StructField, tags tags, unpacker unpack) (unpack, error) {
+	if tags.Base != "header" {
+		return unpack{}, errors.Errorf("scheme=%s is only supported for headers, field %s", tags.Scheme, field.Name)
+	}
+	if strings.EqualFold(tags.Scheme, "Basic") && field.Type.Kind() == reflect.Struct {
+		var err error
+		unpacker, err = basicAuthUnpacker(field, tags)
+		if err != nil {
+			return unpack{}, err
+		}
+	}
+	if unpacker.single == nil {
+		return unpack{}, errors.Errorf("scheme=%s requires a single value, field %s", tags.Scheme, field.Name)
+	}
+	return unpack{single: func(from string, target reflect.Value, value string) error {
+		credentials, ok := stripAuthScheme(tags.Scheme, value)
+		if !ok {
+			return authFailure(tags.Scheme, errors.Errorf("%s authorization required", tags.Scheme))
+		}
+		return unpacker.single(from, target, credentials)
+	}}, nil
+}
+
+func basicAuthUnpacker(field reflect.StructField, tags tags) (unpack, error) {
+	if field.Type.NumField() < 2 {
+		return unpack{}, errors.Errorf("scheme=Basic requires a struct with user and password fields, field %s", field.Name)
+	}
+	var setters [2]func(reflect.Value, string) error
+	for i := range setters {
+		f := field.Type.Field(i)
+		if f.PkgPath != "" {
+			return unpack{}, errors.Errorf("scheme=Basic cannot fill unexported field %s in %s", f.Name, field.Name)
+		}
+		var err error
+		setters[i], err = reflectutils.MakeStringSetter(f.Type)
+		if err != nil {
+			return unpack{}, errors.Wrapf(err, "Cannot decode into %s.%s", field.Name, f.Name)
+		}
+	}
+	return unpack{single: func(from string, target reflect.Value, value string) error {
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return authFailure(tags.Scheme, errors.Wrap(err, "invalid Basic credentials"))
+		}
+		user, password, ok := strings.Cut(string(decoded), ":")
+		if !ok {
+			return authFailure(tags.Scheme, errors.New("invalid Basic credentials"))
+		}
+		if err := setters[0](target.Field(0), user); err != nil {
+			return authFailure(tags.Scheme, err)
+		}
+		if err := setters[1](target.Field(1), password); err != nil {
+			return authFailure(tags.Scheme, err)
+		}
+		return nil
+	}}, nil
+}
+
+func authFailure(scheme string, err error) error {
+	return Unauthorized(ErrorHeader(err, "WWW-Authenticate", scheme))
+}
+
+// stripAuthScheme removes a case-insensitive scheme prefix
+// (eg "Bearer ") from an Authorization header value.
+func stripAuthScheme(scheme string, value string) (string, bool) {
+	i := strings.IndexByte(value, ' ')
+	if i == -1 || !strings.EqualFold(value[:i], scheme) {
+		return "", false
+	}
+	return strings.TrimSpace(value[i+1:]), true
+}
+
+// splitBracketKey splits a nested form key like "user[address][city]"
+// into "user" and "address[city]".
+func splitBracketKey(key string) (string, string, bool) {
+	open := strings.IndexByte(key, '[')
+	if open < 1 {
+		return "", "", false
+	}
+	end := strings.IndexByte(key[open:], ']')
+	if end < 2 {
+		return "", "", false
+	}
+	inner := key[open+1 : open+end]
+	rest := key[open+end+1:]
+	if strings.IndexByte(inner, '[') != -1 || (rest != "" && rest[0] != '[') {
+		return "", "", false
+	}
+	return key[:open], inner + rest, true
+}
+
+// splitDeepObjectKey splits a query parameter key like "id[name]" into
+// "id" and "name".  It matches ^([^\[]+)\[([^\]]+)\]$ so the name may
+// contain "[" but not "]".
+func splitDeepObjectKey(key string) (string, string, bool) {
+	open := strings.IndexByte(key, '[')
+	if open < 1 || key[len(key)-1] != ']' {
+		return "", "", false
+	}
+	inner := key[open+1 : len(key)-1]
+	if inner == "" || strings.IndexByte(inner, ']') != -1 {
+		return "", "", false
+	}
+	return key[:open], inner, true
+}
+
+// knownQueryParameters lists the query parameters (or form values)
+// that have fillers for ListKnownQueryParameters
+func knownQueryParameters(mf *modelFillers, form bool) string {
+	seen := make(map[string]struct{})
+	for _, m := range append([]*modelFillers{mf}, mf.siblings...) {
+		queryFillers, deepObjectFillers := m.query, m.deepObject
+		if form {
+			queryFillers, deepObjectFillers = m.queryForm, m.deepObjectForm
+		}
+		for name := range queryFillers {
+			seen[name] = struct{}{}
+		}
+		for name := range deepObjectFillers {
+			seen[name+"[]"] = struct{}{}
+		}
+	}
+	if len(seen) == 0 {
+		return "none"
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// siblingAccepts reports whether another model decoded from the
+// same request has a filler for a query parameter (or form value)
+func (mf *modelFillers) siblingAccepts(key string, form bool, dotted bool) bool {
+	if len(mf.siblings) == 0 {
+		return false
+	}
+	name, _, split := splitDeepObjectKey(key)
+	if !split && form {
+		name, _, split = splitBracketKey(key)
+	}
+	if !split && dotted {
+		name, _, split = strings.Cut(key, ".")
+	}
+	for _, sibling := range mf.siblings {
+		queryFillers, deepObjectFillers := sibling.query, sibling.deepObject
+		if form {
+			queryFillers, deepObjectFillers = sibling.queryForm, sibling.deepObjectForm
+		}
+		if _, ok := queryFillers[key]; ok {
+			return true
+		}
+		if _, ok := deepObjectFillers[name]; ok && split {
+			return true
+		}
+	}
+	return false
+}
+
+// modelRejectUnknown finds the per-model override of
+// RejectUnknownQueryParameters, if there is one
+func modelRejectUnknown(model reflect.Type, tagName string) (*bool, error) {
+	var reject *bool
+	var err error
+	walkModelFields(model, func(field reflect.StructField) bool {
+		tag, ok := reflectutils.LookupTag(field.Tag, tagName)
+		if !ok || err != nil {
+			return !ok
+		}
+		var tags tags
+		tags, err = parseTag(tag)
+		if err == nil && tags.Base == "options" && tags.RejectUnknown != nil {
+			if reject != nil && *reject != *tags.RejectUnknown {
+				err = errors.Errorf("conflicting rejectUnknown options in %s", model)
+			}
+			reject = tags.RejectUnknown
+		}
+		return false
+	})
+	return reject, err
+}
+
+// requestValue provides the values for the method, host, and
+// scheme pseudo-sources
+func requestValue(source string, r *http.Request, trustForwarded bool) string {
+	switch source {
+	case "method":
+		return r.Method
+	case "host":
+		if trustForwarded {
+			if forwarded := firstForwarded(r.Header.Get("X-Forwarded-Host")); forwarded != "" {
+				return forwarded
+			}
+		}
+		return r.Host
+	case "scheme":
+		if trustForwarded {
+			if forwarded := firstForwarded(r.Header.Get("X-Forwarded-Proto")); forwarded != "" {
+				return strings.ToLower(forwarded)
+			}
+		}
+		if r.TLS != nil {
+			return "https"
+		}
+		return "http"
+	default:
+		return ""
+	}
+}
+
+// firstForwarded returns the first of a comma-separated list of
+// values added by proxies
+func firstForwarded(value string) string {
+	first, _, _ := strings.Cut(value, ",")
+	return strings.TrimSpace(first)
+}
+
+// wrapFieldError annotates an error from filling a field.  Unlike
+// errors.Wrapf, it does not allocate when there is no error.
+func wrapFieldError(err error, what string, name string, fieldName string) error {
+	if err == nil {
+		return nil
+	}
+	return &FieldError{
+		Field: name,
+		Err:   errors.Wrapf(err, "%s %s into field %s", what, name, fieldName),
+	}
+}
+
+// wrapDecodeError annotates an error from decoding a value.  Unlike
+// errors.Wrapf, it does not allocate when there is no error.
+func wrapDecodeError(err error, from string, name string) error {
+	if err == nil {
+		return nil
+	}
+	return errors.Wrapf(err, "decode %s %s", from, name)
+}
+
+// ungroupNumber implements numberFormat=grouped: it removes the
+// commas from a number like "-1,234.5" after checking that they
+// separate groups of three digits
+func ungroupNumber(value string) (string, error) {
+	if !strings.Contains(value, ",") {
+		return value, nil
+	}
+	whole, fraction := value, ""
+	if i := strings.IndexAny(value, ".eE"); i != -1 {
+		whole, fraction = value[:i], value[i:]
+	}
+	groups := strings.Split(whole, ",")
+	first := strings.TrimLeft(groups[0], "+-")
+	ok := len(first) >= 1 && len(first) <= 3 && !strings.Contains(fraction, ",")
+	for _, group := range groups[1:] {
+		ok = ok && len(group) == 3
+	}
+	if !ok {
+		return "", errors.Errorf("'%s' is not a correctly grouped number", value)
+	}
+	return strings.Join(groups, "") + fraction, nil
+}
+
+// parseLenientBool implements boolStyle=lenient
+func parseLenientBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "1", "t", "true", "y", "yes", "on":
+		return true, nil
+	case "0", "f", "false", "n", "no", "off":
+		return false, nil
+	}
+	return false, errors.Errorf("'%s' is not a boolean", value)
+}
+
+// looksLikeForm guesses if a body is application/x-www-form-urlencoded
+// data.  See DetectFormBodies.
+func looksLikeForm(body []byte) bool {
+	if len(body) == 0 || bytes.IndexByte(body, '=') == -1 {
+		return false
+	}
+	switch body[0] {
+	case '{', '[', '<':
+		return false
+	}
+	for _, c := range body {
+		if c <= ' ' || c >= 0x7f || c == '"' {
+			return false
+		}
+	}
+	return true
+}
+
+// foldHeaderValues splits comma-separated header values into
+// individual tokens as allowed by RFC 7230
+func foldHeaderValues(values []string) []string {
+	folded := make([]string, 0, len(values))
+	for _, value := range values {
+		for _, token := range strings.Split(value, ",") {
+			token = strings.TrimSpace(token)
+			if token != "" {
+				folded = append(folded, token)
+			}
+		}
+	}
+	return folded
+}
+
+// checkPairs enforces StrictMapPairs
+func checkPairs(values []string) error {
+	if len(values)%2 != 0 {
+		return errors.Errorf("expected pairs of keys and values but got an odd number of elements (%d)", len(values))
+	}
+	return nil
+}
+
 func resplitOnEquals(values []string) []string {
 	nv := make([]string, len(values)*2)
 	for i, v := range values {
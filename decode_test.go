@@ -1,12 +1,31 @@
 package nvelope_test
 
 import (
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/gorilla/mux"
+	"github.com/muir/nape"
+	"github.com/muir/nject"
 	"github.com/muir/nvelope"
+	"github.com/muir/nvelope/nvelopetest"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -127,6 +146,44 @@ func TestDecodeQueryComplexParameters(t *testing.T) {
 	assert.Equal(t, `200->{"IntArrayP":[7,22,0]}`, do("/x?intarrayp=7,22"))
 }
 
+func TestDecodeQueryIndexed(t *testing.T) {
+	do := captureOutput("/x", func(s struct {
+		Slice  []string  `json:",omitempty" nvelope:"query,name=s,deepObject=true"`
+		Array  [3]int    `json:",omitempty" nvelope:"query,name=a,deepObject=true"`
+		ArrayP *[2]*bool `json:",omitempty" nvelope:"query,name=ap,style=deepObject"`
+	},
+	) (nvelope.Response, error) {
+		return s, nil
+	})
+	assert.Equal(t, `200->{"Slice":["","","x"],"Array":[0,0,0]}`, do("/x?s[2]=x"))
+	assert.Equal(t, `200->{"Slice":["a","","c"],"Array":[0,0,0]}`, do("/x?s[2]=c&s[0]=a"))
+	assert.Equal(t, `200->{"Array":[0,7,0]}`, do("/x?a[1]=7"))
+	assert.Equal(t, `200->{"Array":[0,0,0],"ArrayP":[null,true]}`, do("/x?ap[1]=true"))
+	assert.Regexp(t, `^400->.*index 3 out of range`, do("/x?a[3]=7"))
+	assert.Regexp(t, `^400->.*index 1000 out of range`, do("/x?s[1000]=x"))
+	assert.Regexp(t, `^400->.*invalid index 'x'`, do("/x?s[x]=x"))
+	assert.Regexp(t, `^400->.*invalid index '-1'`, do("/x?a[-1]=7"))
+}
+
+func TestDecodeDeepObjectRepeated(t *testing.T) {
+	do := captureOutput("/x", func(s struct {
+		Obj struct {
+			Name  string   `json:",omitempty" nvelope:"name"`
+			Tags  []string `json:",omitempty" nvelope:"tags"`
+			IDs   *[]int   `json:",omitempty" nvelope:"ids"`
+			Pair  [2]int   `json:",omitempty" nvelope:"pair"`
+			Count int      `json:",omitempty" nvelope:"count"`
+		} `nvelope:"query,name=obj,deepObject=true"`
+	},
+	) (nvelope.Response, error) {
+		return s.Obj, nil
+	})
+	assert.Equal(t, `200->{"Name":"x","Tags":["a","b"],"Pair":[0,0],"Count":2}`, do("/x?obj[name]=x&obj[tags]=a&obj[tags]=b&obj[count]=2"))
+	assert.Equal(t, `200->{"Tags":["a","b"],"Pair":[0,0]}`, do("/x?obj[tags]=a,b"))
+	assert.Equal(t, `200->{"IDs":[3,4,5],"Pair":[1,2]}`, do("/x?obj[ids]=3&obj[ids]=4&obj[ids]=5&obj[pair]=1&obj[pair]=2"))
+	assert.Regexp(t, `^400->.*too many values`, do("/x?obj[pair]=1&obj[pair]=2&obj[pair]=3"))
+}
+
 type Foo string
 
 func (fp *Foo) UnmarshalText(b []byte) error {
@@ -134,6 +191,193 @@ func (fp *Foo) UnmarshalText(b []byte) error {
 	return nil
 }
 
+func TestDecodeContentEncoding(t *testing.T) {
+	gz := func(s string) []byte {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		_, err := zw.Write([]byte(s))
+		require.NoError(t, err)
+		require.NoError(t, zw.Close())
+		return buf.Bytes()
+	}
+	type filter struct {
+		Names []string `json:"names"`
+		Min   int      `json:"min"`
+	}
+	do := captureOutputChain("/x",
+		nvelope.NoLogger,
+		nvelope.InjectWriter,
+		nvelope.EncodeJSON,
+		nvelope.ReadBody,
+		nvelope.GenerateDecoder(
+			nvelope.WithDecoder("application/json", json.Unmarshal),
+			nvelope.WithMaxDecompressedSize(100),
+		),
+		func(s struct {
+			F *filter `json:"f,omitempty" nvelope:"query,name=f,content=application/json,encoding=base64+gzip"`
+			B *filter `json:"b,omitempty" nvelope:"query,name=b,content=application/json,encoding=base64"`
+		},
+		) (nvelope.Response, error) {
+			return s, nil
+		},
+	)
+	blob := gz(`{"names":["a","b"],"min":3}`)
+	assert.Equal(t, `200->{"f":{"names":["a","b"],"min":3}}`, do("/x?f="+e(base64.StdEncoding.EncodeToString(blob))))
+	assert.Equal(t, `200->{"f":{"names":["a","b"],"min":3}}`, do("/x?f="+base64.RawURLEncoding.EncodeToString(blob)))
+	assert.Equal(t, `200->{"b":{"names":null,"min":7}}`, do("/x?b="+e(base64.StdEncoding.EncodeToString([]byte(`{"min":7}`)))))
+	big := gz(`{"names":["` + strings.Repeat("x", 200) + `"]}`)
+	assert.Regexp(t, `^400->.*decompressed content exceeds 100 bytes`, do("/x?f="+e(base64.StdEncoding.EncodeToString(big))))
+	assert.Regexp(t, `^400->.*gzip`, do("/x?f="+e(base64.StdEncoding.EncodeToString([]byte("not gzip")))))
+	assert.Regexp(t, `^400->.*base64`, do("/x?f=***"))
+
+	var bad struct {
+		F string `nvelope:"query,name=f,content=application/json,encoding=zip"`
+	}
+	assert.Error(t, nvelope.DecodeRequest(httptest.NewRequest("GET", "/", nil), &bad))
+	var noContent struct {
+		F string `nvelope:"query,name=f,encoding=gzip"`
+	}
+	assert.Error(t, nvelope.DecodeRequest(httptest.NewRequest("GET", "/", nil), &noContent))
+}
+
+func TestDecodeTextUnmarshalerSlices(t *testing.T) {
+	do := captureOutput("/x", func(s struct {
+		J  []Foo          `json:",omitempty" nvelope:"query,name=j,explode=true,content=application/json"`
+		T  []Foo          `json:",omitempty" nvelope:"query,name=t,explode=true,content=text/plain"`
+		P  []*Foo         `json:",omitempty" nvelope:"query,name=p,explode=true,content=text/plain"`
+		H  []Foo          `json:",omitempty" nvelope:"header,name=H,explode=true,content=text/plain"`
+		M  map[string]Foo `json:",omitempty" nvelope:"query,name=m,explode=true,content=text/plain"`
+		I  []int          `json:",omitempty" nvelope:"query,name=i,explode=true,content=text/plain"`
+		E  []Foo          `json:",omitempty" nvelope:"query,name=e,explode=true"`
+		EP []*Foo         `json:",omitempty" nvelope:"query,name=ep,explode=true"`
+		C  []Foo          `json:",omitempty" nvelope:"query,name=c,explode=false"`
+		EH []Foo          `json:",omitempty" nvelope:"header,name=EH,explode=true"`
+		EM map[string]Foo `json:",omitempty" nvelope:"query,name=em,explode=true"`
+	},
+	) (nvelope.Response, error) {
+		return s, nil
+	})
+	assert.Equal(t, `200->{"J":["~x~","~y~"]}`, do("/x?j="+e(`"x"`)+"&j="+e(`"y"`)))
+	assert.Equal(t, `200->{"T":["~x~","~y~"]}`, do("/x?t=x&t=y"))
+	assert.Equal(t, `200->{"P":["~x~","~y~"]}`, do("/x?p=x&p=y"))
+	assert.Equal(t, `200->{"H":["~x~","~y~"]}`, do("/x", header("H", "x"), header("H", "y")))
+	assert.Equal(t, `200->{"M":{"k":"~v~"}}`, do("/x?m=k%3Dv"))
+	assert.Equal(t, `200->{"I":[1,2]}`, do("/x?i=1&i=2"))
+	assert.Regexp(t, `^400->`, do("/x?i=one"))
+	assert.Equal(t, `200->{"E":["~x~","~y~"]}`, do("/x?e=x&e=y"), "without content=")
+	assert.Equal(t, `200->{"EP":["~x~","~y~"]}`, do("/x?ep=x&ep=y"), "pointers without content=")
+	assert.Equal(t, `200->{"C":["~x~","~y~"]}`, do("/x?c=x,y"), "not exploded")
+	assert.Equal(t, `200->{"EH":["~x~","~y~"]}`, do("/x", header("EH", "x"), header("EH", "y")), "header without content=")
+	assert.Equal(t, `200->{"EM":{"k":"~v~"}}`, do("/x?em=k%3Dv"), "map without content=")
+}
+
+func TestURLDecodePathVars(t *testing.T) {
+	type model struct {
+		Name string `nvelope:"path,name=name"`
+	}
+	serve := func(encoded bool, opts ...nvelope.DecodeInputsGeneratorOpt) func(string) string {
+		router := mux.NewRouter()
+		if encoded {
+			router.UseEncodedPath()
+		}
+		opts = append(opts, nvelope.WithPathVarsFunction(func(r *http.Request) nvelope.RouteVarLookup {
+			vars := mux.Vars(r)
+			return func(v string) string {
+				return vars[v]
+			}
+		}))
+		router.HandleFunc("/files/{name}", func(w http.ResponseWriter, r *http.Request) {
+			var m model
+			if err := nvelope.DecodeRequest(r, &m, opts...); err != nil {
+				w.WriteHeader(nvelope.GetReturnCode(err))
+				_, _ = w.Write([]byte(err.Error()))
+				return
+			}
+			_, _ = w.Write([]byte(m.Name))
+		})
+		return func(path string) string {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+			return fmt.Sprintf("%d->%s", w.Code, w.Body.String())
+		}
+	}
+
+	do := serve(true, nvelope.URLDecodePathVars(true))
+	assert.Equal(t, "200->a/b.txt", do("/files/a%2Fb.txt"))
+	assert.Equal(t, "200->a b", do("/files/a%20b"))
+	assert.Equal(t, "200->..", do("/files/%2E%2E"))
+	assert.Equal(t, "200->100%", do("/files/100%25"))
+
+	do = serve(true)
+	assert.Equal(t, "200->a%2Fb.txt", do("/files/a%2Fb.txt"), "without URLDecodePathVars")
+
+	do = serve(false)
+	assert.Equal(t, "404->404 page not found\n", do("/files/a%2Fb.txt"), "router matches the decoded path")
+	assert.Equal(t, "200->a b", do("/files/a%20b"))
+}
+
+func TestNormalizePathVars(t *testing.T) {
+	type model struct {
+		Name string `nvelope:"path,name=name"`
+		ID   int    `nvelope:"path,name=id"`
+	}
+	decode := func(name, id string, opts ...nvelope.DecodeInputsGeneratorOpt) string {
+		opts = append(opts, nvelope.WithPathVarsFunction(func(r *http.Request) nvelope.RouteVarLookup {
+			return func(v string) string {
+				return map[string]string{"name": name, "id": id}[v]
+			}
+		}))
+		var m model
+		err := nvelope.DecodeRequest(httptest.NewRequest("GET", "/", nil), &m, opts...)
+		if err != nil {
+			return fmt.Sprintf("%d", nvelope.GetReturnCode(err))
+		}
+		return fmt.Sprintf("%q %d", m.Name, m.ID)
+	}
+	assert.Equal(t, `"fred" 7`, decode(" fred ", "7/", nvelope.NormalizePathVars(true)))
+	assert.Equal(t, `"a/b" 7`, decode("a/b//", " 7 ", nvelope.NormalizePathVars(true)))
+	assert.Equal(t, `"a b" 7`, decode("a%20b%2F", "7", nvelope.NormalizePathVars(true), nvelope.URLDecodePathVars(true)))
+	assert.Equal(t, `" fred/" 7`, decode(" fred/", "7"), "without NormalizePathVars")
+	assert.Equal(t, "400", decode("fred", "7/"), "without NormalizePathVars")
+}
+
+func TestDecodeBigNumbers(t *testing.T) {
+	var m struct {
+		I  *big.Int   `nvelope:"query,name=i"`
+		IV big.Int    `nvelope:"query,name=iv"`
+		F  *big.Float `nvelope:"query,name=f"`
+		P  *big.Int   `nvelope:"path,name=p"`
+		L  []*big.Int `nvelope:"query,name=l"`
+	}
+	decode := func(target string, path string) error {
+		return nvelope.DecodeRequest(httptest.NewRequest("GET", target, nil), &m,
+			nvelope.WithPathVarsFunction(func(r *http.Request) nvelope.RouteVarLookup {
+				return func(string) string { return path }
+			}))
+	}
+	huge := "-123456789012345678901234567890123456789"
+	require.NoError(t, decode("/?i="+huge+"&iv=77&f=1.5e1000&l=1&l=-2", "0x10"))
+	assert.Equal(t, huge, m.I.String())
+	assert.Equal(t, "77", m.IV.String())
+	assert.Equal(t, "1.5e+1000", m.F.Text('g', 10))
+	assert.Equal(t, "16", m.P.String())
+	require.Len(t, m.L, 2)
+	assert.Equal(t, "-2", m.L[1].String())
+
+	err := decode("/?i=12x", "1")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `decode query i: math/big: cannot unmarshal "12x" into a *big.Int`)
+	assert.Equal(t, 400, nvelope.GetReturnCode(err))
+
+	err = decode("/?f=abc", "1")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `decode query f: math/big: cannot unmarshal "abc" into a *big.Float`)
+
+	err = decode("/", "1.5")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `decode path p: math/big: cannot unmarshal "1.5" into a *big.Int`)
+}
+
 func TestDecodeQueryJSONParameters(t *testing.T) {
 	do := captureOutput("/x", func(s struct {
 		Foo  Foo      `json:",omitempty" nvelope:"query,name=foo,explode=false"`
@@ -186,6 +430,29 @@ func TestDecodeQueryCookieParameters(t *testing.T) {
 	assert.Equal(t, `200->{"A3":["cow","boy"]}`, do("/x", cookie("A3", "cow,boy")))
 }
 
+func TestDecodeCookieObjects(t *testing.T) {
+	do := captureOutput("/x", func(s struct {
+		M  map[string]int `json:",omitempty" nvelope:"cookie,name=m"`
+		ME map[string]int `json:",omitempty" nvelope:"cookie,name=me,explode=true"`
+		S  *struct {
+			A int    `json:",omitempty" nvelope:"a"`
+			B string `json:",omitempty" nvelope:"b"`
+		} `json:",omitempty" nvelope:"cookie,name=s"`
+		SE *struct {
+			A int    `json:",omitempty" nvelope:"a"`
+			B string `json:",omitempty" nvelope:"b"`
+		} `json:",omitempty" nvelope:"cookie,name=se,explode=true"`
+	},
+	) (nvelope.Response, error) {
+		return s, nil
+	})
+	assert.Equal(t, `200->{"M":{"x":1,"y":2}}`, do("/x", cookie("m", "x,1,y,2")))
+	assert.Equal(t, `200->{"ME":{"x":1,"y":2}}`, do("/x", cookie("me", "x=1,y=2")))
+	assert.Equal(t, `200->{"S":{"A":3,"B":"bee"}}`, do("/x", cookie("s", "a,3,b,bee")))
+	assert.Equal(t, `200->{"SE":{"A":3,"B":"bee"}}`, do("/x", cookie("se", "a=3,b=bee")))
+	assert.Regexp(t, `^400->`, do("/x", cookie("me", "x=one")))
+}
+
 func TestDecodeQueryPathParameters(t *testing.T) {
 	do := captureOutput("/x/{a}/{b}/{c}", func(s struct {
 		A string `json:",omitempty" nvelope:"path,name=a"`
@@ -277,3 +544,2323 @@ func TestDecodeFormValues(t *testing.T) {
 	assert.Equal(t, `200->{"A":7,"B":8}`, do("/x?a=7&b=8", header("Content-type", "application/json"), body(`{}`)))
 	assert.Equal(t, `200->{"A":7,"B":8,"C":9,"D":2}`, do("/x?a=7", header("Content-type", "application/x-www-form-urlencoded"), body(`c=9&b=8&d=2`)))
 }
+
+func TestDecodeDetectFormBodies(t *testing.T) {
+	handler := func(s struct {
+		A int `json:",omitempty" nvelope:"query,name=a,form=true"`
+		B int `json:",omitempty" nvelope:"query,name=b,formOnly=true"`
+	},
+	) (nvelope.Response, error) {
+		return s, nil
+	}
+	chain := func(detect bool) func(string, ...mod) string {
+		return captureOutputChain("/x",
+			nvelope.NoLogger,
+			nvelope.InjectWriter,
+			nvelope.EncodeJSON,
+			nvelope.ReadBody,
+			nvelope.GenerateDecoder(
+				nvelope.WithDecoder("application/json", json.Unmarshal),
+				nvelope.DetectFormBodies(detect),
+			),
+			handler,
+		)
+	}
+	do := chain(true)
+	assert.Equal(t, `200->{"A":1,"B":2}`, do("/x", body(`a=1&b=2`)))
+	assert.Equal(t, `200->{"A":1,"B":2}`, do("/x", header("Content-Type", "text/plain"), body(`a=1&b=2`)))
+	assert.Equal(t, `200->{"A":3,"B":2}`, do("/x?a=3", body(`b=2`)))
+	assert.Equal(t, `200->{}`, do("/x", header("Content-Type", "application/json"), body(`{"a":"b=c"}`)))
+	assert.Equal(t, `200->{}`, do("/x", body(`not a form`)))
+	assert.Equal(t, `200->{}`, do("/x", body(`a=%zz`)))
+
+	do = chain(false)
+	assert.Equal(t, `200->{}`, do("/x", body(`a=1&b=2`)))
+}
+
+type login struct {
+	Username string   `json:"username" nvelope:"username"`
+	Password string   `json:"password" nvelope:"password"`
+	Scopes   []string `json:"scopes,omitempty" nvelope:"scope,explode=true"`
+}
+
+func TestDecodeModelForm(t *testing.T) {
+	do := captureOutput("/x", func(s struct {
+		Login login `nvelope:"model,form=true"`
+	},
+	) (nvelope.Response, error) {
+		return s.Login, nil
+	})
+	assert.Equal(t, `200->{"username":"joe","password":"s3cret"}`,
+		do("/x", header("Content-Type", "application/json"), body(`{"username":"joe","password":"s3cret"}`)))
+	assert.Equal(t, `200->{"username":"joe","password":"s3cret"}`,
+		do("/x", header("Content-Type", "application/x-www-form-urlencoded"), body(`username=joe&password=s3cret`)))
+	assert.Equal(t, `200->{"username":"joe","password":"","scopes":["a","b"]}`,
+		do("/x", header("Content-Type", "application/x-www-form-urlencoded"), body(`username=joe&scope=a&scope=b`)))
+
+	doPointer := captureOutput("/x", func(s struct {
+		Login *login `nvelope:"model,form=true"`
+	},
+	) (nvelope.Response, error) {
+		return s.Login, nil
+	})
+	assert.Equal(t, `200->{"username":"sue","password":"pw"}`,
+		doPointer("/x", header("Content-Type", "application/x-www-form-urlencoded"), body(`username=sue&password=pw`)))
+}
+
+func TestDecodeFormOnlyDeepObject(t *testing.T) {
+	do := captureOutput("/x", func(s struct {
+		M map[string]int `json:",omitempty" nvelope:"query,formOnly,name=m,deepObject=true"`
+	},
+	) (nvelope.Response, error) {
+		return s, nil
+	})
+	assert.Equal(t, `200->{"M":{"a":1}}`, do("/x", header("Content-Type", "application/x-www-form-urlencoded"), body(`m[a]=1`)))
+}
+
+type formAddress struct {
+	City string `json:"city" nvelope:"city"`
+	Zip  string `json:"zip,omitempty" nvelope:"zip"`
+}
+
+type formUser struct {
+	Name    string       `json:"name" nvelope:"name"`
+	Email   string       `json:"email" nvelope:"email"`
+	Address *formAddress `json:"address,omitempty" nvelope:"address"`
+}
+
+type signupForm struct {
+	User  formUser          `json:"user" nvelope:"user"`
+	Prefs map[string]string `json:"prefs,omitempty" nvelope:"prefs"`
+	Plan  string            `json:"plan" nvelope:"plan"`
+}
+
+func TestDecodeNestedForm(t *testing.T) {
+	form := header("Content-Type", "application/x-www-form-urlencoded")
+	do := captureOutput("/x", func(s struct {
+		Signup signupForm `nvelope:"model,form=true"`
+	},
+	) (nvelope.Response, error) {
+		return s.Signup, nil
+	})
+	assert.Equal(t, `200->{"user":{"name":"Sue","email":"sue@example.com","address":{"city":"Paris"}},"prefs":{"color":"red"},"plan":"pro"}`,
+		do("/x", form, body(`user[name]=Sue&user[email]=sue%40example.com&user[address][city]=Paris&prefs[color]=red&plan=pro`)))
+	assert.Equal(t, `200->{"user":{"name":"Joe","email":""},"plan":""}`,
+		do("/x", form, body(`user%5Bname%5D=Joe`)), "brackets can be escaped")
+	assert.Equal(t, `200->{"user":{"name":"","email":""},"plan":""}`,
+		do("/x", form, body(`user[]=x&user[name]x=y&[name]=w`)), "malformed keys are ignored")
+	assert.Equal(t, `200->{"user":{"name":"Ann","email":""},"plan":"free"}`,
+		do("/x", header("Content-Type", "application/json"), body(`{"user":{"name":"Ann"},"plan":"free"}`)),
+		"JSON still works")
+
+	doField := captureOutput("/x", func(s struct {
+		User  formUser `json:"user" nvelope:"query,formOnly,name=user,deepObject=true"`
+		Query formUser `json:"query" nvelope:"query,name=q,deepObject=true"`
+	},
+	) (nvelope.Response, error) {
+		return s, nil
+	})
+	assert.Equal(t, `200->{"user":{"name":"Sue","email":"","address":{"city":"Oslo","zip":"0150"}},"query":{"name":"Q","email":""}}`,
+		doField("/x?q[name]=Q&q[address][city]=Rome", form, body(`user[name]=Sue&user[address][city]=Oslo&user[address][zip]=0150`)),
+		"nested keys are only for form bodies")
+}
+
+type SharedParams struct {
+	Verbose bool   `json:",omitempty" nvelope:"query,name=verbose"`
+	Trace   string `json:",omitempty" nvelope:"header,name=X-Trace"`
+}
+
+type MoreSharedParams struct {
+	SharedParams
+	Limit int `json:",omitempty" nvelope:"query,name=limit"`
+}
+
+type splitPath struct {
+	ID int `json:"id" nvelope:"path,name=id"`
+}
+
+type splitQuery struct {
+	Limit  int               `json:"limit,omitempty" nvelope:"query,name=limit"`
+	Filter map[string]string `json:"filter,omitempty" nvelope:"query,name=filter,deepObject=true"`
+}
+
+type splitBody struct {
+	Body  map[string]int `json:"body" nvelope:"model"`
+	Trace string         `json:"trace,omitempty" nvelope:"header,name=X-Trace"`
+}
+
+func TestDecodeSeparateModels(t *testing.T) {
+	chain := func(opts ...nvelope.DecodeInputsGeneratorOpt) func(string, ...mod) string {
+		return captureOutputChain("/x/{id}",
+			nvelope.NoLogger,
+			nvelope.InjectWriter,
+			nvelope.EncodeJSON,
+			nvelope.ReadBody,
+			nvelope.GenerateDecoder(append([]nvelope.DecodeInputsGeneratorOpt{
+				nvelope.WithDecoder("application/json", json.Unmarshal),
+				nvelope.WithPathVarsFunction(func(r *http.Request) nvelope.RouteVarLookup {
+					vars := mux.Vars(r)
+					return func(name string) string { return vars[name] }
+				}),
+			}, opts...)...),
+			func(p splitPath, q *splitQuery, b splitBody) (nvelope.Response, error) {
+				return []interface{}{p, q, b}, nil
+			},
+		)
+	}
+	jsonBody := []mod{header("Content-Type", "application/json"), header("X-Trace", "t1"), body(`{"a":1}`)}
+	want := `200->[{"id":3},{"limit":7,"filter":{"k":"v"}},{"body":{"a":1},"trace":"t1"}]`
+
+	do := chain()
+	assert.Equal(t, want, do("/x/3?limit=7&filter[k]=v", jsonBody...))
+
+	do = chain(nvelope.RejectUnknownQueryParameters(true))
+	assert.Equal(t, want, do("/x/3?limit=7&filter[k]=v", jsonBody...),
+		"query parameters for one model are not unknown to the others")
+	assert.Regexp(t, `^400->.*query parameter 'offset' not supported`, do("/x/3?limit=7&offset=2", jsonBody...))
+
+	do = chain(nvelope.RejectUnknownQueryParameters(true), nvelope.ListKnownQueryParameters(true))
+	assert.Regexp(t, `^400->.*'offset' not supported, supported parameters are: filter\[\], limit$`,
+		do("/x/3?offset=2", jsonBody...))
+}
+
+func TestDecodeMaxQueryParameters(t *testing.T) {
+	type model struct {
+		A []string `nvelope:"query,name=a"`
+		B string   `nvelope:"query,name=b"`
+	}
+	opts := []nvelope.DecodeInputsGeneratorOpt{nvelope.MaxQueryParameters(3), nvelope.MaxHeaders(4)}
+	decode := func(r *http.Request) error {
+		var m model
+		return nvelope.DecodeRequest(r, &m, opts...)
+	}
+
+	require.NoError(t, decode(httptest.NewRequest("GET", "/?a=1&a=2&b=3", nil)), "at the limit")
+
+	err := decode(httptest.NewRequest("GET", "/?a=1&a=2&a=3&b=4", nil))
+	require.Error(t, err, "repeated values count")
+	assert.Contains(t, err.Error(), "too many query parameters (the limit is 3)")
+	assert.Equal(t, 400, nvelope.GetReturnCode(err))
+
+	r := httptest.NewRequest("POST", "/", strings.NewReader("a=1&a=2&a=3&a=4"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var form struct {
+		A []string `nvelope:"query,name=a,form=true"`
+	}
+	err = nvelope.DecodeRequest(r, &form, opts...)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "too many form values (the limit is 3)")
+	assert.Equal(t, 400, nvelope.GetReturnCode(err))
+
+	r = httptest.NewRequest("GET", "/", nil)
+	for _, h := range []string{"X-A", "X-B", "X-C", "X-D", "X-E"} {
+		r.Header.Set(h, "1")
+	}
+	err = decode(r)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "too many headers (the limit is 4)")
+	assert.Equal(t, 400, nvelope.GetReturnCode(err))
+}
+
+func TestDecodeEcho(t *testing.T) {
+	var called int
+	do := captureOutputChain("/x/{id}",
+		nvelope.NoLogger,
+		nvelope.InjectWriter,
+		nvelope.EncodeJSON,
+		nvelope.CatchPanic,
+		nvelope.ReadBody,
+		nvelope.GenerateDecoder(
+			nvelope.WithDecoder("application/json", json.Unmarshal),
+			nvelope.WithPathVarsFunction(func(r *http.Request) nvelope.RouteVarLookup {
+				vars := mux.Vars(r)
+				return func(name string) string { return vars[name] }
+			}),
+			nvelope.WithDecodeEcho("X-Debug-Decode"),
+		),
+		func(s struct {
+			ID    int            `json:"id" nvelope:"path,name=id"`
+			Tags  []string       `json:"tags" nvelope:"query,name=tags,explode=false"`
+			Trace *string        `json:"trace" nvelope:"header,name=X-Trace"`
+			Body  map[string]int `json:"body" nvelope:"model"`
+		},
+		) (nvelope.Response, error) {
+			called++
+			return "handled", nil
+		},
+	)
+	jsonBody := []mod{header("Content-Type", "application/json"), body(`{"a":1}`)}
+
+	assert.Equal(t, `200->"handled"`, do("/x/3?tags=a,b", jsonBody...))
+	assert.Equal(t, 1, called)
+
+	assert.Equal(t, `200->{"id":3,"tags":["a","b"],"trace":null,"body":{"a":1}}`,
+		do("/x/3?tags=a,b", append(jsonBody, header("X-Debug-Decode", "1"))...))
+	assert.Equal(t, `200->{"id":4,"tags":null,"trace":"t","body":{"a":1}}`,
+		do("/x/4", append(jsonBody, header("x-debug-decode", ""), header("X-Trace", "t"))...),
+		"the header only has to be present")
+	assert.Regexp(t, `^400->.*id`, do("/x/nope", append(jsonBody, header("X-Debug-Decode", "1"))...),
+		"decode errors are reported as usual")
+	assert.Equal(t, 1, called, "the endpoint is not called when echoing")
+}
+
+func TestDecodeEmbeddedStruct(t *testing.T) {
+	do := captureOutput("/x/{id}", func(s struct {
+		MoreSharedParams
+		ID    int `json:",omitempty" nvelope:"path,name=id"`
+		Inner struct {
+			A int `json:",omitempty" nvelope:"a"`
+			B int `json:",omitempty" nvelope:"b"`
+		} `json:",omitempty" nvelope:"query,name=inner,explode=false"`
+	},
+	) (nvelope.Response, error) {
+		return s, nil
+	})
+	assert.Equal(t, `200->{"Verbose":true,"Trace":"abc","Limit":7,"ID":3,"Inner":{"A":1,"B":2}}`,
+		do("/x/3?verbose=true&limit=7&inner=a,1,b,2", header("X-Trace", "abc")))
+	assert.Equal(t, `200->{"Limit":9,"ID":4,"Inner":{}}`, do("/x/4?limit=9"))
+}
+
+func TestDecodeQueryDelimiters(t *testing.T) {
+	do := captureOutput("/x", func(s struct {
+		Semi    []int    `json:",omitempty" nvelope:"query,name=semi,explode=false,delimiter=semicolon"`
+		Tab     []int    `json:",omitempty" nvelope:"query,name=tab,explode=false,delimiter=tab"`
+		Escaped []string `json:",omitempty" nvelope:"query,name=escaped,explode=false,delimiter=%3B"`
+		Comma   []string `json:",omitempty" nvelope:"query,name=comma,explode=false,delimiter=%2C"`
+	},
+	) (nvelope.Response, error) {
+		return s, nil
+	})
+	assert.Equal(t, `200->{"Semi":[1,2,3]}`, do("/x?semi=1%3B2%3B3"))
+	assert.Equal(t, `200->{"Tab":[4,5]}`, do("/x?tab=4%095"))
+	assert.Equal(t, `200->{"Escaped":["a","b"]}`, do("/x?escaped=a%3Bb"))
+	assert.Equal(t, `200->{"Comma":["c","d"]}`, do("/x?comma=c,d"))
+}
+
+func TestDecodeQueryStyles(t *testing.T) {
+	do := captureOutput("/x", func(s struct {
+		Space []int          `json:",omitempty" nvelope:"query,name=space,explode=false,style=spaceDelimited"`
+		Pipe  []int          `json:",omitempty" nvelope:"query,name=pipe,explode=false,style=pipeDelimited"`
+		Form  []int          `json:",omitempty" nvelope:"query,name=form,explode=false,style=form"`
+		Deep  map[string]int `json:",omitempty" nvelope:"query,name=deep,style=deepObject"`
+	},
+	) (nvelope.Response, error) {
+		return s, nil
+	})
+	assert.Equal(t, `200->{"Space":[1,2]}`, do("/x?space=1%202"))
+	assert.Equal(t, `200->{"Pipe":[3,4]}`, do("/x?pipe=3|4"))
+	assert.Equal(t, `200->{"Form":[5,6]}`, do("/x?form=5,6"))
+	assert.Equal(t, `200->{"Deep":{"a":7}}`, do("/x?deep[a]=7"))
+}
+
+func TestDecodeWildcardContentType(t *testing.T) {
+	marker := func(i int) nvelope.Decoder {
+		return func(_ []byte, v interface{}) error {
+			*(v.(*thing)) = thing{I: i}
+			return nil
+		}
+	}
+	do := captureOutputChain("/x",
+		nvelope.NoLogger,
+		nvelope.InjectWriter,
+		nvelope.EncodeJSON,
+		nvelope.ReadBody,
+		nvelope.GenerateDecoder(
+			nvelope.WithDecoder("application/json", json.Unmarshal),
+			nvelope.WithDecoder("application/vnd.exact+json", marker(1)),
+			nvelope.WithDecoder("application/*+json", json.Unmarshal),
+			nvelope.WithDecoder("application/*", marker(2)),
+			nvelope.WithDecoder("*/*", marker(3)),
+		),
+		func(s struct {
+			Body thing `nvelope:"model"`
+		},
+		) (nvelope.Response, error) {
+			return s.Body, nil
+		},
+	)
+	ct := func(contentType string) string {
+		return do("/x", header("Content-Type", contentType), body(`{"I":7}`))
+	}
+	assert.Equal(t, `200->{"I":7}`, ct("application/json"))
+	assert.Equal(t, `200->{"I":7}`, ct("application/json; charset=utf-8"))
+	assert.Equal(t, `200->{"I":1}`, ct("application/vnd.exact+json"))
+	assert.Equal(t, `200->{"I":7}`, ct("application/vnd.api+json"))
+	assert.Equal(t, `200->{"I":7}`, ct("Application/Problem+JSON"))
+	assert.Equal(t, `200->{"I":2}`, ct("application/octet-stream"))
+	assert.Equal(t, `200->{"I":3}`, ct("text/plain"))
+}
+
+func TestDecodeMethodDecoder(t *testing.T) {
+	stored := `{"name":"a","size":3,"tags":["x"]}`
+	// mergePatch applies an RFC 7386 merge patch to the stored document
+	mergePatch := func(data []byte, v interface{}) error {
+		var doc, patch map[string]interface{}
+		if err := json.Unmarshal([]byte(stored), &doc); err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &patch); err != nil {
+			return err
+		}
+		for k, value := range patch {
+			if value == nil {
+				delete(doc, k)
+			} else {
+				doc[k] = value
+			}
+		}
+		merged, err := json.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(merged, v)
+	}
+	h, err := nvelopetest.Handler(
+		nvelope.NoLogger,
+		nvelope.InjectWriter,
+		nvelope.EncodeJSON,
+		nvelope.ReadBody,
+		nvelope.GenerateDecoder(
+			nvelope.WithDecoder("application/json", json.Unmarshal),
+			nvelope.WithMethodDecoder("patch", "application/merge-patch+json", mergePatch),
+		),
+		func(s struct {
+			Body map[string]interface{} `nvelope:"model"`
+		},
+		) (nvelope.Response, error) {
+			return s.Body, nil
+		},
+	)
+	require.NoError(t, err)
+	do := func(method string, contentType string, body string) string {
+		return nvelopetest.Do(h, method, "/", nvelopetest.Header("Content-Type", contentType), nvelopetest.Body(body)).String()
+	}
+
+	assert.Equal(t, `200->{"name":"b","tags":["x"]}`,
+		do("PATCH", "application/merge-patch+json", `{"name":"b","size":null}`))
+	assert.Equal(t, `200->{"name":"b","size":null}`,
+		do("PUT", "application/json", `{"name":"b","size":null}`))
+	assert.Equal(t, `200->{"name":"b","size":null}`,
+		do("PATCH", "application/json", `{"name":"b","size":null}`),
+		"other content types fall back to WithDecoder")
+	assert.Regexp(t, `^400->.*No body decoder for content type application/merge-patch\+json`,
+		do("PUT", "application/merge-patch+json", `{"name":"b"}`))
+}
+
+type shape interface {
+	Area() float64
+}
+
+type circle struct {
+	Type   string  `json:"type"`
+	Radius float64 `json:"radius"`
+}
+
+func (c circle) Area() float64 { return 3 * c.Radius * c.Radius }
+
+type square struct {
+	Type string  `json:"type"`
+	Side float64 `json:"side"`
+}
+
+func (s *square) Area() float64 { return s.Side * s.Side }
+
+func TestDecodeDiscriminator(t *testing.T) {
+	do := captureOutputChain("/x",
+		nvelope.NoLogger,
+		nvelope.InjectWriter,
+		nvelope.EncodeJSON,
+		nvelope.ReadBody,
+		nvelope.GenerateDecoder(
+			nvelope.WithDecoder("application/json", json.Unmarshal),
+			nvelope.WithDiscriminator("type", map[string]reflect.Type{
+				"circle": reflect.TypeOf(circle{}),
+				"square": reflect.TypeOf(square{}),
+				"thing":  reflect.TypeOf(thing{}),
+			}),
+		),
+		func(s struct {
+			Shape shape `nvelope:"model"`
+		},
+		) (nvelope.Response, error) {
+			return fmt.Sprintf("%T %v", s.Shape, s.Shape.Area()), nil
+		},
+	)
+	ct := header("Content-Type", "application/json")
+	assert.Equal(t, `200->"nvelope_test.circle 12"`, do("/x", ct, body(`{"type":"circle","radius":2}`)))
+	assert.Equal(t, `200->"*nvelope_test.square 9"`, do("/x", ct, body(`{"type":"square","side":3}`)))
+	assert.Regexp(t, `^400->.*discriminator 'type' value 'thing' is not supported$`, do("/x", ct, body(`{"type":"thing"}`)))
+	assert.Regexp(t, `^400->.*discriminator 'type' is missing$`, do("/x", ct, body(`{"side":3}`)))
+	assert.Regexp(t, `^400->.*discriminator 'type' must be a string$`, do("/x", ct, body(`{"type":7}`)))
+}
+
+func TestDecodeCollectAllErrors(t *testing.T) {
+	do := captureOutputChain("/x",
+		nvelope.NoLogger,
+		nvelope.InjectWriter,
+		nvelope.EncodeJSON,
+		nvelope.ReadBody,
+		nvelope.GenerateDecoder(
+			nvelope.WithDecoder("application/json", json.Unmarshal),
+			nvelope.CollectAllErrors(true),
+		),
+		func(s struct {
+			Age   int      `json:"age" nvelope:"query,name=age,minimum=18,errMsg=adults only"`
+			Email string   `json:"email" nvelope:"query,name=email,required=true,format=email"`
+			Name  string   `json:"name" nvelope:"header,name=X-Name,minLength=2"`
+			Tags  []string `json:"tags" nvelope:"query,name=tag,maxLength=3"`
+		},
+		) (nvelope.Response, error) {
+			return s, nil
+		},
+	)
+	assert.Equal(t, `200->{"age":20,"email":"a@b.c","name":"","tags":null}`, do("/x?age=20&email=a@b.c"))
+	assert.Equal(t, `400->{"errors":{"X-Name":["x must be of length at least 2"],"age":["adults only"],"email":["query parameter 'email' is required"],"tag":["long must be of length at most 3"]}}`,
+		do("/x?age=3&tag=ok&tag=long", header("X-Name", "x")))
+	assert.Equal(t, `400->{"errors":{"email":["nope must be a valid email"]}}`, do("/x?email=nope"))
+
+	var m struct {
+		Age   int    `nvelope:"query,name=age,minimum=18"`
+		Email string `nvelope:"query,name=email,format=email"`
+	}
+	err := nvelope.DecodeRequest(httptest.NewRequest("GET", "/?age=3&email=x", nil), &m, nvelope.CollectAllErrors(true))
+	require.Error(t, err)
+	var ve nvelope.ValidationErrors
+	require.True(t, errors.As(err, &ve))
+	assert.Len(t, ve, 2)
+	assert.Len(t, ve.Unwrap(), 2)
+	assert.Equal(t, map[string][]string{
+		"age":   {"3 must be at least 18"},
+		"email": {"x must be a valid email"},
+	}, ve.FieldErrors())
+	assert.Equal(t, 400, nvelope.GetReturnCode(err))
+	assert.Equal(t, "age: 3 must be at least 18; email: x must be a valid email", nvelope.ClientMessage(err))
+}
+
+func TestDecodeErrMsg(t *testing.T) {
+	logger := &testLogger{}
+	do := captureOutputChain("/x",
+		logger.provider(),
+		nvelope.InjectWriter,
+		nvelope.EncodeJSON,
+		nvelope.ReadBody,
+		nvelope.GenerateDecoder(
+			nvelope.WithDecoder("application/json", json.Unmarshal),
+		),
+		func(s struct {
+			Age   int    `json:"age" nvelope:"query,name=age,maximum=150,errMsg=please provide your age in years"`
+			Email string `json:"email" nvelope:"query,name=email,required=true,errMsg=please provide an email address"`
+			Other int    `json:"other" nvelope:"query,name=other"`
+		},
+		) (nvelope.Response, error) {
+			return s, nil
+		},
+	)
+	assert.Equal(t, `200->{"age":3,"email":"a@b","other":0}`, do("/x?age=3&email=a@b"))
+	assert.Equal(t, `400->please provide your age in years`, do("/x?age=old&email=a@b"))
+	assert.Equal(t, `400->please provide your age in years`, do("/x?age=200&email=a@b"))
+	assert.Equal(t, `400->please provide an email address`, do("/x?age=3"))
+	assert.Regexp(t, `^400->.*decode query other: strconv.ParseInt`, do("/x?other=x&email=a@b"))
+	require.NotEmpty(t, logger.logged)
+	assert.Contains(t, logger.logged[0], "decode query age: strconv.ParseInt", "technical error is logged")
+}
+
+func TestDecodeTagDecoder(t *testing.T) {
+	dateDecoder := func(data []byte, target interface{}, tags nvelope.Tags) error {
+		layout := tags.Layout
+		if layout == "" {
+			layout = time.RFC3339
+		}
+		tm, err := time.Parse(layout, string(data))
+		if err != nil {
+			return err
+		}
+		*(target.(*time.Time)) = tm
+		return nil
+	}
+	do := captureOutputChain("/x",
+		nvelope.NoLogger,
+		nvelope.InjectWriter,
+		nvelope.EncodeJSON,
+		nvelope.ReadBody,
+		nvelope.GenerateDecoder(
+			nvelope.WithDecoder("application/json", json.Unmarshal),
+			nvelope.WithTagDecoder("application/date", dateDecoder),
+		),
+		func(s struct {
+			Day   time.Time `json:"day" nvelope:"query,name=day,content=application/date,layout=2006-01-02"`
+			Stamp time.Time `json:"stamp" nvelope:"query,name=stamp,content=application/date"`
+			Nums  []int     `json:"nums" nvelope:"query,name=nums,explode=false,content=application/json"`
+		},
+		) (nvelope.Response, error) {
+			return s, nil
+		},
+	)
+	assert.Equal(t, `200->{"day":"2022-03-04T00:00:00Z","stamp":"2021-01-02T03:04:05Z","nums":[1,2]}`,
+		do("/x?day=2022-03-04&stamp=2021-01-02T03:04:05Z&nums=[1,2]"))
+	assert.Regexp(t, `^400->.*parsing time`, do("/x?day=2022-03-04T00:00:00Z"))
+}
+
+func TestInjectBodyStatus(t *testing.T) {
+	var decoded bool
+	do := captureOutputChain("/x",
+		nvelope.NoLogger,
+		nvelope.InjectWriter,
+		nvelope.InjectBodyStatus,
+		func(inner func(), status *nvelope.BodyStatus) {
+			inner()
+			decoded = status.Decoded
+		},
+		nvelope.EncodeJSON,
+		nvelope.ReadBody,
+		nvelope.GenerateDecoder(
+			nvelope.WithDecoder("application/json", json.Unmarshal),
+			nvelope.WithDefaultContentType("application/json"),
+		),
+		func(s struct {
+			Body *thing `nvelope:"model"`
+		},
+		) (nvelope.Response, error) {
+			return s.Body, nil
+		},
+	)
+	assert.Equal(t, `200->{"I":1}`, do("/x", body(`{"I":1}`)))
+	assert.True(t, decoded, "decoded")
+	assert.Regexp(t, `^400->`, do("/x", body(`{"I":`)))
+	assert.False(t, decoded, "bad body")
+}
+
+func TestReadBodyNoRewrap(t *testing.T) {
+	endpoint := func(b nvelope.Body, r *http.Request) (nvelope.Response, error) {
+		again, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		return fmt.Sprintf("%s/%s", string(b), string(again)), nil
+	}
+	chain := func(reader nject.Provider) func(string, ...mod) string {
+		return captureOutputChain("/x",
+			nvelope.NoLogger,
+			nvelope.InjectWriter,
+			nvelope.EncodeJSON,
+			reader,
+			endpoint,
+		)
+	}
+	assert.Equal(t, `200->"hello/hello"`, chain(nvelope.ReadBody)("/x", body("hello")))
+	assert.Equal(t, `200->"hello/"`, chain(nvelope.ReadBodyNoRewrap)("/x", body("hello")))
+}
+
+type truncatedBody struct {
+	io.Reader
+}
+
+func (b truncatedBody) Read(p []byte) (int, error) {
+	n, err := b.Reader.Read(p)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func TestCheckContentLength(t *testing.T) {
+	handler := func(reader nject.Provider) http.Handler {
+		h, err := nvelopetest.Handler(
+			nvelope.NoLogger,
+			nvelope.InjectWriter,
+			nvelope.EncodeJSON,
+			reader,
+			func(b nvelope.Body) (nvelope.Response, error) {
+				return string(b), nil
+			},
+		)
+		require.NoError(t, err)
+		return h
+	}
+	length := func(n int64) nvelopetest.Mod {
+		return func(r *http.Request) {
+			r.ContentLength = n
+		}
+	}
+	truncated := func(r *http.Request) {
+		r.Body = io.NopCloser(truncatedBody{Reader: strings.NewReader("hel")})
+	}
+	checked := handler(nvelope.MakeBodyReader(nvelope.CheckContentLength(true)))
+	unchecked := handler(nvelope.MakeBodyReader())
+
+	assert.Equal(t, `200->"hello"`, nvelopetest.Do(checked, "POST", "/", nvelopetest.Body("hello")).String())
+	assert.Equal(t, `200->""`, nvelopetest.Do(checked, "GET", "/").String())
+	assert.Equal(t, `200->"hello"`, nvelopetest.Do(checked, "POST", "/", nvelopetest.Body("hello"), length(-1)).String(), "unknown length")
+
+	res := nvelopetest.Do(checked, "POST", "/", nvelopetest.Body("hello"), length(10))
+	assert.Equal(t, 400, res.Status)
+	assert.Contains(t, res.Body, "request body is 5 bytes but Content-Length is 10")
+	res = nvelopetest.Do(checked, "POST", "/", nvelopetest.Body("hello"), length(3))
+	assert.Equal(t, 400, res.Status)
+	res = nvelopetest.Do(checked, "POST", "/", nvelopetest.Body("hello"), truncated)
+	assert.Equal(t, 400, res.Status)
+	assert.Contains(t, res.Body, "request body is truncated")
+
+	assert.Equal(t, `200->"hello"`, nvelopetest.Do(unchecked, "POST", "/", nvelopetest.Body("hello"), length(10)).String(), "not checked by default")
+	assert.Equal(t, 500, nvelopetest.Do(unchecked, "POST", "/", nvelopetest.Body("hello"), truncated).Status)
+}
+
+func TestDecodeSliceModel(t *testing.T) {
+	do := captureOutputChain("/x",
+		nvelope.NoLogger,
+		nvelope.InjectWriter,
+		nvelope.EncodeJSON,
+		nvelope.ReadBody,
+		nvelope.GenerateDecoder(
+			nvelope.WithDecoder("application/json", json.Unmarshal),
+			nvelope.WithDefaultContentType("application/json"),
+		),
+		func(s struct {
+			Items    []thing   `nvelope:"model"`
+			Pointers *[]*thing `nvelope:"model"`
+			Limit    int       `nvelope:"query,name=limit"`
+		},
+		) (nvelope.Response, error) {
+			return s, nil
+		},
+	)
+	assert.Equal(t, `200->{"Items":[{"I":1},{"F":2.5}],"Pointers":[{"I":1},{"F":2.5}],"Limit":2}`, do("/x?limit=2", body(`[{"I":1},{"F":2.5}]`)))
+	assert.Equal(t, `200->{"Items":[],"Pointers":[],"Limit":0}`, do("/x", body(`[]`)))
+	assert.Regexp(t, `^400->.*cannot unmarshal object into Go value of type \[\]nvelope_test.thing`, do("/x", body(`{"I":1}`)))
+}
+
+func TestDecodeMissingReadBody(t *testing.T) {
+	var invoke func(http.ResponseWriter, *http.Request)
+	err := nject.Sequence("test",
+		nvelope.NoLogger,
+		nvelope.InjectWriter,
+		nvelope.EncodeJSON,
+		nape.DecodeJSON,
+		func(s struct {
+			Body thing `nvelope:"model"`
+		},
+		) (nvelope.Response, error) {
+			return s, nil
+		},
+	).Bind(&invoke, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "use nvelope.ReadBody to provide it")
+
+	err = nject.Sequence("test",
+		nvelope.NoLogger,
+		nvelope.InjectWriter,
+		nvelope.EncodeJSON,
+		nvelope.ReadBody,
+		nape.DecodeJSON,
+		func(s struct {
+			Body thing `nvelope:"model"`
+		},
+		) (nvelope.Response, error) {
+			return s, nil
+		},
+	).Bind(&invoke, nil)
+	require.NoError(t, err)
+
+	var invokeWithBody func(http.ResponseWriter, *http.Request, nvelope.Body)
+	err = nject.Sequence("test",
+		nvelope.NoLogger,
+		nvelope.InjectWriter,
+		nvelope.EncodeJSON,
+		nape.DecodeJSON,
+		func(s struct {
+			Body thing `nvelope:"model"`
+		},
+		) (nvelope.Response, error) {
+			return s, nil
+		},
+	).Bind(&invokeWithBody, nil)
+	require.NoError(t, err, "body provided by the invoke function")
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/", nil)
+	r.Header.Set("Content-Type", "application/json")
+	invokeWithBody(w, r, nvelope.Body(`{"I":3}`))
+	assert.Equal(t, `{"Body":{"I":3}}`, w.Body.String())
+}
+
+func TestDecodeLogErrors(t *testing.T) {
+	logger := &testLogger{}
+	do := captureOutputChain("/x",
+		logger.provider(),
+		nvelope.InjectWriter,
+		nvelope.EncodeJSON,
+		nvelope.ReadBody,
+		nvelope.GenerateDecoder(
+			nvelope.WithDecoder("application/json", json.Unmarshal),
+			nvelope.LogDecodeErrors(true),
+		),
+		func(s struct {
+			I int `json:",omitempty" nvelope:"query,name=i"`
+		},
+		) (nvelope.Response, error) {
+			return s, nil
+		},
+	)
+	assert.Equal(t, `200->{"I":3}`, do("/x?i=3"))
+	assert.Empty(t, logger.logged)
+	assert.Regexp(t, `^400->`, do("/x?i=x"))
+	require.NotEmpty(t, logger.logged)
+	assert.Contains(t, logger.logged[0], "debug: could not decode request")
+	assert.Contains(t, logger.logged[0], "query parameter i into field I")
+}
+
+func TestDecodeCookieCodec(t *testing.T) {
+	key := []byte("sekrit")
+	sign := func(v string) string {
+		mac := hmac.New(sha256.New, key)
+		_, _ = mac.Write([]byte(v))
+		return base64.RawURLEncoding.EncodeToString([]byte(v)) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	}
+	codec := func(name, raw string) (string, error) {
+		parts := strings.SplitN(raw, ".", 2)
+		if len(parts) != 2 {
+			return "", nvelope.Unauthorized(fmt.Errorf("unsigned cookie"))
+		}
+		v, err := base64.RawURLEncoding.DecodeString(parts[0])
+		if err != nil {
+			return "", err
+		}
+		if sign(string(v)) != raw {
+			return "", nvelope.Unauthorized(fmt.Errorf("bad signature"))
+		}
+		return string(v), nil
+	}
+	do := captureOutputChain("/x",
+		nvelope.NoLogger,
+		nvelope.InjectWriter,
+		nvelope.EncodeJSON,
+		nvelope.ReadBody,
+		nvelope.GenerateDecoder(
+			nvelope.WithDecoder("application/json", json.Unmarshal),
+			nvelope.WithCookieCodec(codec),
+		),
+		func(s struct {
+			User string `json:",omitempty" nvelope:"cookie,name=user"`
+		},
+		) (nvelope.Response, error) {
+			return s, nil
+		},
+	)
+	assert.Equal(t, `200->{"User":"joe"}`, do("/x", cookie("user", sign("joe"))))
+	assert.Regexp(t, `^401->.*bad signature$`, do("/x", cookie("user", sign("joe")[:10]+"x"+sign("joe")[11:])))
+	assert.Regexp(t, `^401->.*unsigned cookie$`, do("/x", cookie("user", "joe")))
+	assert.Regexp(t, `^400->`, do("/x", cookie("user", "%%%.sig")))
+}
+
+func TestDecodeUnknownQueryParameterHandler(t *testing.T) {
+	var ignored []string
+	handler := func(key string, values []string) error {
+		switch {
+		case strings.HasPrefix(key, "utm_"):
+			ignored = append(ignored, key+"="+strings.Join(values, ","))
+			return nil
+		case key == "admin":
+			return nvelope.Forbidden(fmt.Errorf("no %s for you", key))
+		default:
+			return fmt.Errorf("unexpected parameter %s", key)
+		}
+	}
+	do := captureOutputChain("/x",
+		nvelope.NoLogger,
+		nvelope.InjectWriter,
+		nvelope.EncodeJSON,
+		nvelope.ReadBody,
+		nvelope.GenerateDecoder(
+			nvelope.WithDecoder("application/json", json.Unmarshal),
+			nvelope.RejectUnknownQueryParameters(true),
+			nvelope.WithUnknownQueryParameterHandler(handler),
+		),
+		func(s struct {
+			A int `json:",omitempty" nvelope:"query,name=a"`
+		},
+		) (nvelope.Response, error) {
+			return s, nil
+		},
+	)
+	assert.Equal(t, `200->{"A":3}`, do("/x?a=3&utm_source=mail&utm_source=web"))
+	assert.Equal(t, []string{"utm_source=mail,web"}, ignored)
+	assert.Regexp(t, `^403->.*no admin for you$`, do("/x?a=3&admin=1"))
+	assert.Regexp(t, `^400->.*unexpected parameter b$`, do("/x?a=3&b=1"))
+}
+
+func TestDecodeListKnownQueryParameters(t *testing.T) {
+	type model struct {
+		Limit  int               `nvelope:"query,name=limit"`
+		Cursor string            `nvelope:"query,name=cursor"`
+		Filter map[string]string `nvelope:"query,name=filter,deepObject=true"`
+		Auth   string            `nvelope:"header,name=Authorization"`
+	}
+	decode := func(target string, opts ...nvelope.DecodeInputsGeneratorOpt) error {
+		var m model
+		return nvelope.DecodeRequest(httptest.NewRequest("GET", target, nil), &m, opts...)
+	}
+	err := decode("/?limt=3", nvelope.RejectUnknownQueryParameters(true), nvelope.ListKnownQueryParameters(true))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "query parameter 'limt' not supported, supported parameters are: cursor, filter[], limit")
+	assert.Equal(t, 400, nvelope.GetReturnCode(err))
+
+	err = decode("/?limt=3", nvelope.RejectUnknownQueryParameters(true))
+	require.Error(t, err)
+	assert.NotContains(t, err.Error(), "supported parameters")
+
+	assert.NoError(t, decode("/?limit=3&filter[a]=b", nvelope.RejectUnknownQueryParameters(true), nvelope.ListKnownQueryParameters(true)))
+}
+
+func TestDecodeDottedQueryKeys(t *testing.T) {
+	type model struct {
+		Filter struct {
+			Name  string `json:",omitempty" nvelope:"name"`
+			Range *struct {
+				Min int `nvelope:"min"`
+				Max int `nvelope:"max"`
+			} `json:",omitempty" nvelope:"range"`
+			Labels map[string]string `json:",omitempty" nvelope:"labels"`
+			Dotted string            `json:",omitempty" nvelope:"a.b"`
+		} `nvelope:"query,name=filter,deepObject=true"`
+		Version string `json:",omitempty" nvelope:"query,name=api.version"`
+	}
+	decode := func(target string, opts ...nvelope.DecodeInputsGeneratorOpt) string {
+		var m model
+		err := nvelope.DecodeRequest(httptest.NewRequest("GET", target, nil), &m, opts...)
+		if err != nil {
+			return err.Error()
+		}
+		enc, err := json.Marshal(m)
+		require.NoError(t, err)
+		return string(enc)
+	}
+	dotted := nvelope.DottedQueryKeys(true)
+	assert.Equal(t, `{"Filter":{"Name":"x"}}`, decode("/?filter.name=x", dotted))
+	assert.Equal(t, `{"Filter":{"Name":"x"}}`, decode("/?filter[name]=x", dotted), "brackets still work")
+	assert.Equal(t, `{"Filter":{"Range":{"Min":3,"Max":9}}}`, decode("/?filter.range.min=3&filter.range.max=9", dotted))
+	assert.Equal(t, `{"Filter":{"Range":{"Min":3,"Max":0}}}`, decode("/?filter[range.min]=3", dotted))
+	assert.Equal(t, `{"Filter":{"Labels":{"a.b":"x","c":"y"}}}`, decode("/?filter.labels.a.b=x&filter.labels.c=y", dotted))
+	assert.Equal(t, `{"Filter":{"Dotted":"x"}}`, decode("/?filter.a.b=x", dotted), "exact member name wins")
+	assert.Equal(t, `{"Filter":{},"Version":"2"}`, decode("/?api.version=2", dotted), "exact parameter name wins")
+	assert.Contains(t, decode("/?filter.range.min=x", dotted), "Range")
+	assert.Contains(t, decode("/?filter.range.mid=3", dotted, nvelope.RejectUnknownQueryParameters(true)), "mid")
+
+	assert.Equal(t, `{"Filter":{}}`, decode("/?filter.name=x"), "without DottedQueryKeys")
+	assert.Contains(t, decode("/?filter.name=x", nvelope.RejectUnknownQueryParameters(true)), "'filter.name' not supported")
+}
+
+func TestDecodeHeaderFold(t *testing.T) {
+	do := captureOutput("/x", func(s struct {
+		Accept []string `json:",omitempty" nvelope:"header,name=Accept,fold=true"`
+		Raw    []string `json:",omitempty" nvelope:"header,name=X-Raw"`
+	},
+	) (nvelope.Response, error) {
+		return s, nil
+	})
+	assert.Equal(t, `200->{"Accept":["text/html","application/json"]}`, do("/x", header("Accept", "text/html, application/json")))
+	assert.Equal(t, `200->{"Accept":["text/html","application/json","text/plain"]}`, do("/x", header("Accept", "text/html"), header("Accept", "application/json ,text/plain")))
+	assert.Equal(t, `200->{"Raw":["a, b","c"]}`, do("/x", header("X-Raw", "a, b"), header("X-Raw", "c")))
+}
+
+func TestDecodePresence(t *testing.T) {
+	do := captureOutput("/x", func(s struct {
+		Feature bool `json:"feature" nvelope:"header,name=X-Feature,presence=true"`
+		Debug   bool `json:"debug" nvelope:"query,name=debug,presence=true"`
+	},
+	) (nvelope.Response, error) {
+		return s, nil
+	})
+	assert.Equal(t, `200->{"feature":false,"debug":false}`, do("/x"))
+	assert.Equal(t, `200->{"feature":true,"debug":false}`, do("/x", header("X-Feature", "")))
+	assert.Equal(t, `200->{"feature":true,"debug":false}`, do("/x", header("X-Feature", "false")))
+	assert.Equal(t, `200->{"feature":false,"debug":true}`, do("/x?debug"))
+	assert.Equal(t, `200->{"feature":false,"debug":true}`, do("/x?debug=no"))
+}
+
+func TestDecodeJoin(t *testing.T) {
+	do := captureOutput("/x", func(s struct {
+		Tags   string  `json:"tags,omitempty" nvelope:"query,name=tags,join=comma"`
+		Piped  *string `json:"piped,omitempty" nvelope:"query,name=p,join=pipe"`
+		Accept string  `json:"accept,omitempty" nvelope:"header,name=Accept,join=comma"`
+	},
+	) (nvelope.Response, error) {
+		return s, nil
+	})
+	assert.Equal(t, `200->{"tags":"a,b"}`, do("/x?tags=a&tags=b"))
+	assert.Equal(t, `200->{"tags":"a"}`, do("/x?tags=a"))
+	assert.Equal(t, `200->{"piped":"x|y|z"}`, do("/x?p=x&p=y&p=z"))
+	assert.Equal(t, `200->{"accept":"text/html,text/plain"}`, do("/x", header("Accept", "text/html"), header("Accept", "text/plain")))
+}
+
+func TestDecodeHeaderNameCase(t *testing.T) {
+	do := captureOutput("/x", func(s struct {
+		Lower    string `json:"lower,omitempty" nvelope:"header,name=x-custom"`
+		Upper    int    `json:"upper,omitempty" nvelope:"header,name=X-REQUEST-COUNT,required=true"`
+		Presence bool   `json:"presence,omitempty" nvelope:"header,name=x-flag,presence=true"`
+	},
+	) (nvelope.Response, error) {
+		return s, nil
+	})
+	assert.Equal(t, `200->{"lower":"a","upper":3,"presence":true}`, do("/x", header("X-Custom", "a"), header("X-Request-Count", "3"), header("X-Flag", "")))
+	assert.Regexp(t, `^400->.*header 'X-Request-Count' is required$`, do("/x"))
+}
+
+func TestDecodeHeaderPrefix(t *testing.T) {
+	do := captureOutput("/x", func(s struct {
+		Meta   map[string]string   `json:"meta,omitempty" nvelope:"header,prefix=X-Meta-"`
+		Multi  map[string][]string `json:"multi,omitempty" nvelope:"header,prefix=x-multi-"`
+		Counts *map[string]int     `json:"counts,omitempty" nvelope:"header,prefix=X-Count-"`
+		Other  string              `json:"other,omitempty" nvelope:"header,name=X-Meta"`
+	},
+	) (nvelope.Response, error) {
+		return s, nil
+	})
+	assert.Equal(t, `200->{"meta":{"Request-Id":"abc","Trace":"t1, t2"},"other":"o"}`,
+		do("/x", header("X-Meta-Request-Id", "abc"), header("X-Meta-Trace", "t1"), header("X-Meta-Trace", "t2"),
+			header("X-Meta", "o"), header("X-Metadata", "no"), header("Content-Length", "0")))
+	assert.Equal(t, `200->{"multi":{"A":["1","2"]},"counts":{"B":3}}`,
+		do("/x", header("X-Multi-A", "1"), header("X-Multi-A", "2"), header("X-Count-B", "3")))
+	assert.Equal(t, `200->{}`, do("/x", header("X-Meta-", "empty name")), "maps are left nil without matches")
+	assert.Regexp(t, `^400->.*X-Count-B`, do("/x", header("X-Count-B", "three")))
+}
+
+func TestDecodeMethod(t *testing.T) {
+	type verb string
+	type model struct {
+		Method string `nvelope:"method"`
+		Verb   *verb  `nvelope:"method"`
+		ID     int    `nvelope:"query,name=id"`
+	}
+	for _, method := range []string{"GET", "POST", "DELETE"} {
+		var m model
+		require.NoError(t, nvelope.DecodeRequest(httptest.NewRequest(method, "/?id=3", nil), &m))
+		assert.Equal(t, method, m.Method)
+		if assert.NotNil(t, m.Verb) {
+			assert.Equal(t, verb(method), *m.Verb)
+		}
+		assert.Equal(t, 3, m.ID)
+	}
+
+	do := captureOutput("/x", func(s struct {
+		Method string `nvelope:"method"`
+	},
+	) (nvelope.Response, error) {
+		return s.Method, nil
+	})
+	assert.Equal(t, `200->"POST"`, do("/x"))
+
+	var bad struct {
+		Method int `nvelope:"method"`
+	}
+	err := nvelope.DecodeRequest(httptest.NewRequest("GET", "/", nil), &bad)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "decode method into field Method")
+}
+
+func TestDecodeHostScheme(t *testing.T) {
+	type model struct {
+		Host   string `nvelope:"host"`
+		Scheme string `nvelope:"scheme"`
+	}
+	decode := func(r *http.Request, opts ...nvelope.DecodeInputsGeneratorOpt) string {
+		var m model
+		require.NoError(t, nvelope.DecodeRequest(r, &m, opts...))
+		return m.Scheme + "://" + m.Host
+	}
+	forwarded := func(target string) *http.Request {
+		r := httptest.NewRequest("GET", target, nil)
+		r.Header.Set("X-Forwarded-Host", "acme.example.com, proxy.internal")
+		r.Header.Set("X-Forwarded-Proto", "HTTPS")
+		return r
+	}
+	trust := nvelope.TrustForwardedHeaders(true)
+
+	assert.Equal(t, "http://tenant.example.com:8080", decode(httptest.NewRequest("GET", "http://tenant.example.com:8080/x", nil)))
+	assert.Equal(t, "https://secure.example.com", decode(httptest.NewRequest("GET", "https://secure.example.com/x", nil)))
+	assert.Equal(t, "http://internal:8080", decode(forwarded("http://internal:8080/x")), "forwarding headers ignored by default")
+	assert.Equal(t, "https://acme.example.com", decode(forwarded("http://internal:8080/x"), trust))
+	assert.Equal(t, "http://internal:8080", decode(httptest.NewRequest("GET", "http://internal:8080/x", nil), trust), "no forwarding headers")
+}
+
+func TestDecodeHeaderBearer(t *testing.T) {
+	do := captureOutput("/x", func(s struct {
+		Token string `json:",omitempty" nvelope:"header,name=Authorization,scheme=Bearer"`
+	},
+	) (nvelope.Response, error) {
+		return s, nil
+	})
+	assert.Equal(t, `200->{"Token":"xyz"}`, do("/x", header("Authorization", "Bearer xyz")))
+	assert.Equal(t, `200->{"Token":"abc"}`, do("/x", header("Authorization", "bearer abc")))
+	assert.Regexp(t, `^401->.*Bearer authorization required$`, do("/x", header("Authorization", "Basic abc")))
+	assert.Equal(t, `200->{}`, do("/x"))
+}
+
+func TestDecodeHeaderBasic(t *testing.T) {
+	do := captureResponse("/x", func(s struct {
+		Auth struct {
+			User string
+			Pass string
+		} `nvelope:"header,name=Authorization,scheme=Basic"`
+	},
+	) (nvelope.Response, error) {
+		return s.Auth, nil
+	})
+	check := func(auth string, code int, want string) {
+		var mods []mod
+		if auth != "" {
+			mods = append(mods, header("Authorization", auth))
+		}
+		res, err := do("/x", mods...)
+		require.NoError(t, err, auth)
+		defer res.Body.Close()
+		b, err := io.ReadAll(res.Body)
+		require.NoError(t, err, auth)
+		assert.Equal(t, code, res.StatusCode, auth)
+		if code == 401 {
+			assert.Equal(t, "Basic", res.Header.Get("WWW-Authenticate"), auth)
+			assert.Contains(t, string(b), want, auth)
+		} else {
+			assert.Equal(t, want, string(b), auth)
+		}
+	}
+	check("Basic "+base64.StdEncoding.EncodeToString([]byte("joe:se:cret")), 200, `{"User":"joe","Pass":"se:cret"}`)
+	check("", 200, `{"User":"","Pass":""}`)
+	check("Basic "+base64.StdEncoding.EncodeToString([]byte("joe")), 401, "invalid Basic credentials")
+	check("Basic ***", 401, "invalid Basic credentials")
+	check("Bearer xyz", 401, "Basic authorization required")
+}
+
+func TestDecodeModelPostProcess(t *testing.T) {
+	type model struct {
+		Email  string `json:",omitempty" nvelope:"query,name=email"`
+		Domain string `json:",omitempty"`
+	}
+	do := captureOutputChain("/x",
+		nvelope.NoLogger,
+		nvelope.InjectWriter,
+		nvelope.EncodeJSON,
+		nvelope.ReadBody,
+		nvelope.GenerateDecoder(
+			nvelope.WithDecoder("application/json", json.Unmarshal),
+			nvelope.WithModelPostProcess(func(i interface{}, r *http.Request) error {
+				m := i.(*model)
+				m.Email = strings.ToLower(strings.TrimSpace(m.Email))
+				return nil
+			}),
+			nvelope.WithModelPostProcess(func(i interface{}, r *http.Request) error {
+				m := i.(*model)
+				_, domain, ok := strings.Cut(m.Email, "@")
+				if !ok {
+					return nvelope.ReturnCode(fmt.Errorf("invalid email"), 422)
+				}
+				m.Domain = domain
+				return nil
+			}),
+		),
+		func(m model) (nvelope.Response, error) {
+			return m, nil
+		},
+	)
+	assert.Equal(t, `200->{"Email":"joe@example.com","Domain":"example.com"}`, do("/x?email="+e(" Joe@Example.COM")))
+	assert.Equal(t, `422->nvelope_test.model model: invalid email`, do("/x?email=joe"))
+}
+
+func TestDecodeUnprocessableEntity(t *testing.T) {
+	type model struct {
+		Body struct {
+			Name string `json:"name"`
+			Age  int    `json:"age"`
+		} `nvelope:"model"`
+	}
+	endpoint := func(m model) (nvelope.Response, error) {
+		return m.Body, nil
+	}
+	validator := nvelope.WithModelValidator(func(i interface{}) error {
+		if i.(*model).Body.Name == "" {
+			return fmt.Errorf("name is required")
+		}
+		return nil
+	})
+	chain := func(opts ...nvelope.DecodeInputsGeneratorOpt) func(string, ...mod) string {
+		return captureOutputChain("/x",
+			nvelope.NoLogger,
+			nvelope.InjectWriter,
+			nvelope.EncodeJSON,
+			nvelope.ReadBody,
+			nvelope.GenerateDecoder(append(opts,
+				nvelope.WithDecoder("application/json", json.Unmarshal),
+				nvelope.WithDefaultContentType("application/json"),
+				validator,
+			)...),
+			endpoint,
+		)
+	}
+
+	do := chain(nvelope.UseUnprocessableEntity(true))
+	assert.Equal(t, `200->{"name":"joe","age":3}`, do("/x", body(`{"name":"joe","age":3}`)))
+	assert.Regexp(t, `^400->.*invalid character`, do("/x", body(`{"name":}`)))
+	assert.Regexp(t, `^422->.*cannot unmarshal string`, do("/x", body(`{"name":"joe","age":"old"}`)))
+	assert.Regexp(t, `^422->.*name is required`, do("/x", body(`{"age":3}`)))
+
+	do = chain()
+	assert.Regexp(t, `^400->.*invalid character`, do("/x", body(`{"name":}`)))
+	assert.Regexp(t, `^400->.*cannot unmarshal string`, do("/x", body(`{"name":"joe","age":"old"}`)))
+	assert.Regexp(t, `^400->.*name is required`, do("/x", body(`{"age":3}`)))
+}
+
+type (
+	namedStatus   string
+	namedCount    int
+	namedTags     []string
+	namedStatuses []namedStatus
+	namedLabels   map[string]string
+	namedCounts   map[namedStatus]namedCount
+	namedPair     [2]namedCount
+)
+
+func TestDecodeNamedTypes(t *testing.T) {
+	type model struct {
+		Status   namedStatus   `nvelope:"query,name=status"`
+		Count    namedCount    `nvelope:"query,name=count,minimum=1"`
+		Tags     namedTags     `nvelope:"query,name=tags"`
+		Statuses namedStatuses `nvelope:"query,name=statuses,explode=false"`
+		Labels   namedLabels   `nvelope:"query,name=labels,deepObject=true"`
+		Counts   namedCounts   `nvelope:"query,name=counts,explode=false"`
+		Pair     namedPair     `nvelope:"query,name=pair,explode=false"`
+		Ptr      *namedStatus  `nvelope:"query,name=ptr"`
+		Default  namedStatus   `nvelope:"query,name=default,default=pending"`
+		Header   namedTags     `nvelope:"header,name=X-Tags,explode=true"`
+		Cookie   namedLabels   `nvelope:"cookie,name=labels"`
+	}
+	r := httptest.NewRequest("GET", "/?status=ok&count=3&tags=a&tags=b&statuses=x,y"+
+		"&labels[a]=b&counts=ok,3,bad,4&pair=1,2&ptr=p", nil)
+	r.Header.Add("X-Tags", "q")
+	r.Header.Add("X-Tags", "r")
+	r.AddCookie(&http.Cookie{Name: "labels", Value: "c,1,d,2"})
+	var m model
+	require.NoError(t, nvelope.DecodeRequest(r, &m))
+	ptr := namedStatus("p")
+	assert.Equal(t, model{
+		Status:   "ok",
+		Count:    3,
+		Tags:     namedTags{"a", "b"},
+		Statuses: namedStatuses{"x", "y"},
+		Labels:   namedLabels{"a": "b"},
+		Counts:   namedCounts{"ok": 3, "bad": 4},
+		Pair:     namedPair{1, 2},
+		Ptr:      &ptr,
+		Default:  "pending",
+		Header:   namedTags{"q", "r"},
+		Cookie:   namedLabels{"c": "1", "d": "2"},
+	}, m)
+
+	err := nvelope.DecodeRequest(httptest.NewRequest("GET", "/?count=0", nil), &m)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "at least 1", "range checks apply to named numbers")
+	err = nvelope.DecodeRequest(httptest.NewRequest("GET", "/?counts=ok,x", nil), &m)
+	require.Error(t, err)
+	assert.Equal(t, 400, nvelope.GetReturnCode(err))
+}
+
+// levelFlag is a flag.Value with a string underlying type
+type levelFlag string
+
+func (l *levelFlag) String() string { return string(*l) }
+
+func (l *levelFlag) Set(s string) error {
+	switch s {
+	case "debug", "info", "warn":
+		*l = levelFlag(strings.ToUpper(s))
+		return nil
+	}
+	return fmt.Errorf("unknown level %q", s)
+}
+
+// rangeFlag is a flag.Value with a struct underlying type
+type rangeFlag struct {
+	Lo, Hi int
+}
+
+func (r *rangeFlag) String() string { return fmt.Sprintf("%d-%d", r.Lo, r.Hi) }
+
+func (r *rangeFlag) Set(s string) error {
+	_, err := fmt.Sscanf(s, "%d-%d", &r.Lo, &r.Hi)
+	return err
+}
+
+type namedBool bool
+
+func TestDecodeLenientBool(t *testing.T) {
+	type model struct {
+		Strict  bool        `nvelope:"query,name=strict"`
+		Lenient bool        `nvelope:"query,name=lenient,boolStyle=lenient"`
+		Ptr     *bool       `nvelope:"query,name=ptr,boolStyle=lenient"`
+		Named   namedBool   `nvelope:"query,name=named,boolStyle=lenient"`
+		List    []bool      `nvelope:"query,name=list,explode=false,boolStyle=lenient"`
+		Header  bool        `nvelope:"header,name=X-Debug,boolStyle=lenient"`
+		Default bool        `nvelope:"query,name=default,boolStyle=lenient,default=on"`
+		Plain   []namedBool `nvelope:"query,name=plain,explode=false,boolStyle=strict"`
+	}
+	r := httptest.NewRequest("GET", "/?strict=true&lenient=YES&ptr=off&named=y&list=on,no,1,F&plain=t,0", nil)
+	r.Header.Set("X-Debug", "On")
+	var m model
+	require.NoError(t, nvelope.DecodeRequest(r, &m))
+	f := false
+	assert.Equal(t, model{
+		Strict:  true,
+		Lenient: true,
+		Ptr:     &f,
+		Named:   true,
+		List:    []bool{true, false, true, false},
+		Header:  true,
+		Default: true,
+		Plain:   []namedBool{true, false},
+	}, m)
+
+	for _, query := range []string{"strict=yes", "strict=on", "plain=no", "lenient=maybe", "lenient=", "list=on,sure"} {
+		var m model
+		err := nvelope.DecodeRequest(httptest.NewRequest("GET", "/?"+query, nil), &m)
+		assert.Error(t, err, query)
+	}
+}
+
+func TestDecodeFlagValueSlices(t *testing.T) {
+	type model struct {
+		Level    levelFlag    `nvelope:"query,name=level"`
+		Levels   []levelFlag  `nvelope:"query,name=levels,explode=false"`
+		Exploded []levelFlag  `nvelope:"query,name=exploded"`
+		Range    rangeFlag    `nvelope:"query,name=range"`
+		Ranges   []rangeFlag  `nvelope:"query,name=ranges,delimiter=pipe,explode=false"`
+		Ptrs     []*rangeFlag `nvelope:"query,name=ptrs"`
+		Array    [2]rangeFlag `nvelope:"query,name=array,explode=false"`
+		Header   []rangeFlag  `nvelope:"header,name=X-Ranges,explode=false"`
+		Cookie   []levelFlag  `nvelope:"cookie,name=levels"`
+	}
+	r := httptest.NewRequest("GET", "/?level=debug&levels=info,warn&exploded=warn&exploded=debug"+
+		"&range=1-2&ranges=3-4|5-6&ptrs=7-8&ptrs=9-10&array=1-1,2-2", nil)
+	r.Header.Set("X-Ranges", "1-3,4-6")
+	r.AddCookie(&http.Cookie{Name: "levels", Value: "info,debug"})
+	var m model
+	require.NoError(t, nvelope.DecodeRequest(r, &m))
+	assert.Equal(t, model{
+		Level:    "DEBUG",
+		Levels:   []levelFlag{"INFO", "WARN"},
+		Exploded: []levelFlag{"WARN", "DEBUG"},
+		Range:    rangeFlag{Lo: 1, Hi: 2},
+		Ranges:   []rangeFlag{{Lo: 3, Hi: 4}, {Lo: 5, Hi: 6}},
+		Ptrs:     []*rangeFlag{{Lo: 7, Hi: 8}, {Lo: 9, Hi: 10}},
+		Array:    [2]rangeFlag{{Lo: 1, Hi: 1}, {Lo: 2, Hi: 2}},
+		Header:   []rangeFlag{{Lo: 1, Hi: 3}, {Lo: 4, Hi: 6}},
+		Cookie:   []levelFlag{"INFO", "DEBUG"},
+	}, m)
+
+	err := nvelope.DecodeRequest(httptest.NewRequest("GET", "/?levels=info,loud", nil), &m)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `unknown level "loud"`, "each element goes through Set")
+	err = nvelope.DecodeRequest(httptest.NewRequest("GET", "/?ranges=3-4|x", nil), &m)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "ranges")
+}
+
+func TestDecodeURL(t *testing.T) {
+	type model struct {
+		Callback url.URL    `nvelope:"query,name=callback"`
+		Redirect *url.URL   `nvelope:"query,name=redirect"`
+		Mirrors  []*url.URL `nvelope:"query,name=mirrors,explode=false"`
+		Origin   *url.URL   `nvelope:"header,name=X-Origin"`
+	}
+	r := httptest.NewRequest("GET", "/?callback="+url.QueryEscape("https://example.com/hook?x=1")+
+		"&redirect="+url.QueryEscape("../next#top")+"&mirrors=http://a.test,/b", nil)
+	r.Header.Set("X-Origin", "https://origin.test")
+	var m model
+	require.NoError(t, nvelope.DecodeRequest(r, &m))
+	assert.Equal(t, "https://example.com/hook?x=1", m.Callback.String())
+	assert.Equal(t, "example.com", m.Callback.Host)
+	require.NotNil(t, m.Redirect)
+	assert.False(t, m.Redirect.IsAbs(), "relative URLs are accepted")
+	assert.Equal(t, "../next", m.Redirect.Path)
+	assert.Equal(t, "top", m.Redirect.Fragment)
+	if assert.Len(t, m.Mirrors, 2) {
+		assert.Equal(t, "a.test", m.Mirrors[0].Host)
+		assert.Equal(t, "/b", m.Mirrors[1].Path)
+	}
+	assert.Equal(t, "origin.test", m.Origin.Host)
+
+	var empty model
+	require.NoError(t, nvelope.DecodeRequest(httptest.NewRequest("GET", "/", nil), &empty))
+	assert.Nil(t, empty.Redirect)
+
+	err := nvelope.DecodeRequest(httptest.NewRequest("GET", "/?redirect="+url.QueryEscape("http://[::1"), nil), &m)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "redirect")
+	assert.Equal(t, 400, nvelope.GetReturnCode(err))
+}
+
+func TestDecodeExactItems(t *testing.T) {
+	type model struct {
+		Point  [2]int    `nvelope:"query,name=point,explode=false,exactItems=true"`
+		RGB    *[3]uint8 `nvelope:"query,name=rgb,exactItems=true"`
+		Loose  [3]int    `nvelope:"query,name=loose,explode=false"`
+		Header [2]string `nvelope:"header,name=X-Pair,exactItems=true"`
+	}
+	decode := func(query string) (model, error) {
+		r := httptest.NewRequest("GET", "/?"+query, nil)
+		r.Header.Add("X-Pair", "a")
+		r.Header.Add("X-Pair", "b")
+		var m model
+		err := nvelope.DecodeRequest(r, &m)
+		return m, err
+	}
+
+	m, err := decode("point=3,4&rgb=1&rgb=2&rgb=3&loose=7")
+	require.NoError(t, err, "exact counts")
+	assert.Equal(t, [2]int{3, 4}, m.Point)
+	assert.Equal(t, &[3]uint8{1, 2, 3}, m.RGB)
+	assert.Equal(t, [3]int{7, 0, 0}, m.Loose, "arrays without exactItems are still padded")
+	assert.Equal(t, [2]string{"a", "b"}, m.Header)
+
+	for _, tc := range []struct {
+		query string
+		want  string
+	}{
+		{"point=3", "exactly 2 values are required, got 1"},
+		{"point=3,4,5", "exactly 2 values are required, got 3"},
+		{"point=3,4&rgb=1&rgb=2", "exactly 3 values are required, got 2"},
+		{"point=3,4&rgb=1&rgb=2&rgb=3&rgb=4", "exactly 3 values are required, got 4"},
+	} {
+		_, err := decode(tc.query)
+		if assert.Error(t, err, tc.query) {
+			assert.Contains(t, err.Error(), tc.want, tc.query)
+			assert.Equal(t, 400, nvelope.GetReturnCode(err), tc.query)
+		}
+	}
+
+	var deep struct {
+		A [2]int `nvelope:"query,name=a,deepObject=true,exactItems=true"`
+	}
+	err = nvelope.DecodeRequest(httptest.NewRequest("GET", "/", nil), &deep)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "exactItems=true cannot be combined with deepObject=true")
+}
+
+func TestDecodeGroupedNumbers(t *testing.T) {
+	type model struct {
+		Amount float64   `nvelope:"query,name=amount,numberFormat=grouped"`
+		Count  int       `nvelope:"query,name=count,numberFormat=grouped,maximum=5000"`
+		Size   *uint32   `nvelope:"header,name=X-Size,numberFormat=grouped"`
+		Prices []float64 `nvelope:"query,name=prices,numberFormat=grouped"`
+		Piped  []int     `nvelope:"query,name=piped,numberFormat=grouped,explode=false,delimiter=pipe"`
+		Strict int       `nvelope:"query,name=strict"`
+	}
+	decode := func(query string) (model, error) {
+		r := httptest.NewRequest("GET", "/?"+query, nil)
+		r.Header.Set("X-Size", "65,536")
+		var m model
+		err := nvelope.DecodeRequest(r, &m)
+		return m, err
+	}
+
+	m, err := decode("amount=" + url.QueryEscape("1,234.56") + "&count=-1,234&prices=1,000.5&prices=7&piped=1,000|2|3,000,000")
+	require.NoError(t, err)
+	size := uint32(65536)
+	assert.Equal(t, model{
+		Amount: 1234.56,
+		Count:  -1234,
+		Size:   &size,
+		Prices: []float64{1000.5, 7},
+		Piped:  []int{1000, 2, 3000000},
+	}, m)
+
+	m, err = decode("amount=12.5&count=42&strict=7")
+	require.NoError(t, err, "plain numbers are still accepted")
+	assert.Equal(t, 12.5, m.Amount)
+	assert.Equal(t, 42, m.Count)
+	assert.Equal(t, 7, m.Strict)
+
+	for _, tc := range []struct {
+		query string
+		want  string
+	}{
+		{"amount=1,23.4", "'1,23.4' is not a correctly grouped number"},
+		{"amount=1234,567", "'1234,567' is not a correctly grouped number"},
+		{"amount=1,234.5,6", "'1,234.5,6' is not a correctly grouped number"},
+		{"amount=,123", "',123' is not a correctly grouped number"},
+		{"count=6,000", "6000"},
+		{"strict=1,000", "strict"},
+	} {
+		_, err := decode(tc.query)
+		if assert.Error(t, err, tc.query) {
+			assert.Contains(t, err.Error(), tc.want, tc.query)
+			assert.Equal(t, 400, nvelope.GetReturnCode(err), tc.query)
+		}
+	}
+
+	var comma struct {
+		IDs []int `nvelope:"query,name=ids,numberFormat=grouped,explode=false"`
+	}
+	err = nvelope.DecodeRequest(httptest.NewRequest("GET", "/", nil), &comma)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "numberFormat=grouped requires a delimiter other than comma")
+}
+
+type PageParams struct {
+	Limit  int    `json:"limit" nvelope:"query,name=limit"`
+	Cursor string `json:"cursor,omitempty" nvelope:"query,name=cursor"`
+}
+
+type SortParams struct {
+	*PageParams
+	Sort string `json:"sort" nvelope:"query,name=sort,default=name"`
+}
+
+func TestDecodeEmbeddedPointer(t *testing.T) {
+	type model struct {
+		*PageParams
+		ID int `json:"id" nvelope:"path,name=id"`
+	}
+	decode := func(target string, m interface{}) string {
+		err := nvelope.DecodeRequest(httptest.NewRequest("GET", target, nil), m,
+			nvelope.WithPathVarsFunction(func(r *http.Request) nvelope.RouteVarLookup {
+				return func(string) string { return "7" }
+			}))
+		if err != nil {
+			return err.Error()
+		}
+		enc, err := json.Marshal(m)
+		require.NoError(t, err)
+		return string(enc)
+	}
+	assert.Equal(t, `{"limit":10,"cursor":"abc","id":7}`, decode("/?limit=10&cursor=abc", &model{}))
+	var m model
+	assert.Equal(t, `{"id":7}`, decode("/", &m))
+	assert.Nil(t, m.PageParams, "not allocated when nothing is filled")
+
+	existing := model{PageParams: &PageParams{Cursor: "keep"}}
+	assert.Equal(t, `{"limit":3,"cursor":"keep","id":7}`, decode("/?limit=3", &existing))
+
+	var nested struct {
+		*SortParams
+	}
+	assert.Equal(t, `{"limit":5,"sort":"date"}`, decode("/?limit=5&sort=date", &nested))
+	nested.SortParams = nil
+	assert.Equal(t, `{"sort":"name"}`, decode("/", &nested), "default allocates")
+	assert.Nil(t, nested.PageParams)
+
+	type inner struct {
+		B int `nvelope:"query,name=b"`
+	}
+	var unexported struct {
+		*inner
+		A int `nvelope:"query,name=a"`
+	}
+	assert.Equal(t, "cannot fill tagged fields through unexported embedded pointer *nvelope_test.inner",
+		decode("/?b=3", &unexported))
+	type untagged struct {
+		B int
+	}
+	var ignored struct {
+		*untagged
+		A int `json:"a" nvelope:"query,name=a"`
+	}
+	assert.Equal(t, `{"a":2}`, decode("/?a=2", &ignored), "untagged fields are not a problem")
+}
+
+func TestDecodeStrictMapPairs(t *testing.T) {
+	type model struct {
+		IntBool map[int]bool      `json:",omitempty" nvelope:"query,name=ib,explode=false"`
+		Strings map[string]string `json:",omitempty" nvelope:"query,name=ss,explode=false"`
+		Object  *struct {
+			A string `json:",omitempty" nvelope:"a"`
+			B int    `json:",omitempty" nvelope:"b"`
+		} `json:",omitempty" nvelope:"query,name=obj,explode=false"`
+		Exploded map[string]string `json:",omitempty" nvelope:"header,name=X-M,explode=true"`
+	}
+	decode := func(target string, opts ...nvelope.DecodeInputsGeneratorOpt) string {
+		var m model
+		r := httptest.NewRequest("GET", target, nil)
+		r.Header.Set("X-M", "k")
+		err := nvelope.DecodeRequest(r, &m, opts...)
+		if err != nil {
+			return err.Error()
+		}
+		enc, err := json.Marshal(m)
+		require.NoError(t, err)
+		return string(enc)
+	}
+	strict := nvelope.StrictMapPairs(true)
+	assert.Equal(t, `{"IntBool":{"-9":false,"7":true},"Exploded":{"k":""}}`, decode("/?ib=7,true,-9,false", strict))
+	assert.Equal(t, `{"Strings":{"a":"","b":"c"},"Exploded":{"k":""}}`, decode("/?ss=b,c,a"), "lenient by default")
+	assert.Contains(t, decode("/?ss=b,c,a", strict), "odd number of elements (3)")
+	assert.Contains(t, decode("/?ib=7,true,-9", strict), "odd number of elements (3)")
+	assert.Contains(t, decode("/?obj=a,x,b", strict), "odd number of elements (3)")
+	assert.Equal(t, `{"Object":{"A":"x","B":2},"Exploded":{"k":""}}`, decode("/?obj=a,x,b,2", strict))
+	assert.Equal(t, `{"Exploded":{"k":""}}`, decode("/", strict), "key=value lists are not affected")
+}
+
+func TestDecodeRejectUnknownPerModel(t *testing.T) {
+	type strict struct {
+		_     struct{} `nvelope:"options,rejectUnknown=true"`
+		Limit int      `nvelope:"query,name=limit"`
+		Obj   struct {
+			A string `nvelope:"a"`
+		} `nvelope:"query,name=obj,explode=false"`
+	}
+	type lenient struct {
+		_     struct{} `nvelope:"options,rejectUnknown=false"`
+		Limit int      `nvelope:"query,name=limit"`
+	}
+	type plain struct {
+		Limit int `nvelope:"query,name=limit"`
+	}
+	decode := func(target string, model interface{}, opts ...nvelope.DecodeInputsGeneratorOpt) error {
+		return nvelope.DecodeRequest(httptest.NewRequest("GET", target, nil), model, opts...)
+	}
+	for _, global := range []bool{false, true} {
+		opt := nvelope.RejectUnknownQueryParameters(global)
+		err := decode("/?limit=3&extra=1", &strict{}, opt)
+		if assert.Error(t, err, "strict, global=%v", global) {
+			assert.Contains(t, err.Error(), "query parameter 'extra' not supported")
+		}
+		err = decode("/?obj=b,x", &strict{}, opt)
+		if assert.Error(t, err, "strict object, global=%v", global) {
+			assert.Contains(t, err.Error(), "No struct member to receive key 'b'")
+		}
+		var l lenient
+		assert.NoError(t, decode("/?limit=3&extra=1", &l, opt), "lenient, global=%v", global)
+		assert.Equal(t, 3, l.Limit)
+		err = decode("/?limit=3&extra=1", &plain{}, opt)
+		assert.Equal(t, global, err != nil, "plain follows the global setting, global=%v", global)
+	}
+
+	// both models in one decoder
+	do := captureOutputChain("/x",
+		nvelope.NoLogger,
+		nvelope.InjectWriter,
+		nvelope.EncodeJSON,
+		nvelope.ReadBody,
+		nvelope.GenerateDecoder(),
+		func(s strict, l lenient) (nvelope.Response, error) {
+			return []int{s.Limit, l.Limit}, nil
+		},
+	)
+	assert.Equal(t, `200->[3,3]`, do("/x?limit=3"))
+	assert.Regexp(t, `^400->.*'extra' not supported`, do("/x?limit=3&extra=1"))
+
+	var conflicting struct {
+		A struct{} `nvelope:"options,rejectUnknown=true"`
+		B struct{} `nvelope:"options,rejectUnknown=false"`
+	}
+	assert.Error(t, decode("/", &conflicting))
+}
+
+func TestDecodeProfile(t *testing.T) {
+	var calls []string
+	profile := nvelope.DecodeProfile(
+		nvelope.WithTag("api"),
+		nvelope.RejectUnknownQueryParameters(true),
+		nvelope.WithModelPostProcess(func(interface{}, *http.Request) error {
+			calls = append(calls, "profile")
+			return nil
+		}),
+	)
+	type model struct {
+		A string `api:"query,name=a" other:"query,name=b"`
+	}
+	decode := func(target string, opts ...nvelope.DecodeInputsGeneratorOpt) string {
+		calls = nil
+		var m model
+		err := nvelope.DecodeRequest(httptest.NewRequest("GET", target, nil), &m, opts...)
+		if err != nil {
+			return err.Error()
+		}
+		return m.A
+	}
+	assert.Equal(t, "x", decode("/?a=x", profile))
+	assert.Equal(t, []string{"profile"}, calls)
+	assert.Contains(t, decode("/?a=x&c=y", profile), "'c' not supported")
+	assert.Equal(t, "x", decode("/?a=x&c=y", profile, nvelope.RejectUnknownQueryParameters(false)), "later option overrides")
+	assert.Equal(t, "y", decode("/?a=x&b=y", profile, nvelope.WithTag("other"), nvelope.RejectUnknownQueryParameters(false)), "later tag wins")
+	assert.Equal(t, "x", decode("/?a=x&b=y", nvelope.WithTag("other"), profile, nvelope.RejectUnknownQueryParameters(false)), "profile tag wins when last")
+	assert.Equal(t, "x", decode("/?a=x", profile, nvelope.WithModelPostProcess(func(interface{}, *http.Request) error {
+		calls = append(calls, "override")
+		return nil
+	})))
+	assert.Equal(t, []string{"profile", "override"}, calls, "post processors accumulate")
+}
+
+func TestDecodeErrorStatus(t *testing.T) {
+	do := captureOutputChain("/x",
+		nvelope.NoLogger,
+		nvelope.InjectWriter,
+		nvelope.EncodeJSON,
+		nvelope.ReadBody,
+		nvelope.GenerateDecoder(
+			nvelope.WithDecoder("application/json", json.Unmarshal),
+			nvelope.WithDecodeErrorStatus(422),
+			nvelope.WithModelPostProcess(func(i interface{}, r *http.Request) error {
+				return nvelope.ReturnCode(fmt.Errorf("teapot"), 418)
+			}),
+		),
+		func(s struct {
+			Count int `json:"count" nvelope:"query,name=count"`
+		},
+		) (nvelope.Response, error) {
+			return s, nil
+		},
+	)
+	assert.Regexp(t, `^422->.*strconv.ParseInt`, do("/x?count=many"))
+	assert.Regexp(t, `^418->.*teapot`, do("/x?count=3"))
+}
+
+func TestDecodeModelFactory(t *testing.T) {
+	type model struct {
+		Color  string            `json:",omitempty" nvelope:"query,name=color"`
+		Size   int               `json:",omitempty" nvelope:"query,name=size"`
+		Labels map[string]string `json:",omitempty"`
+	}
+	do := captureOutputChain("/x",
+		nvelope.NoLogger,
+		nvelope.InjectWriter,
+		nvelope.EncodeJSON,
+		nvelope.ReadBody,
+		nvelope.GenerateDecoder(
+			nvelope.WithDecoder("application/json", json.Unmarshal),
+			nvelope.WithModelFactory(reflect.TypeOf(&model{}), func() interface{} {
+				return model{
+					Color:  "blue",
+					Size:   3,
+					Labels: map[string]string{"a": "b"},
+				}
+			}),
+		),
+		func(m *model) (nvelope.Response, error) {
+			return m, nil
+		},
+	)
+	assert.Equal(t, `200->{"Color":"blue","Size":3,"Labels":{"a":"b"}}`, do("/x"))
+	assert.Equal(t, `200->{"Color":"red","Size":3,"Labels":{"a":"b"}}`, do("/x?color=red"))
+}
+
+func TestDecodeModelFactoryErrors(t *testing.T) {
+	type model struct {
+		Color string `nvelope:"query,name=color"`
+	}
+	type otherModel struct{}
+	bind := func(factory func() interface{}) error {
+		var invoke func(http.ResponseWriter, *http.Request)
+		return nject.Sequence("test",
+			nvelope.NoLogger,
+			nvelope.InjectWriter,
+			nvelope.EncodeJSON,
+			nvelope.GenerateDecoder(
+				nvelope.WithModelFactory(reflect.TypeOf(model{}), factory),
+			),
+			func(m model) (nvelope.Response, error) {
+				return m, nil
+			},
+		).Bind(&invoke, nil)
+	}
+	err := bind(func() interface{} { return &otherModel{} })
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "must return")
+	err = bind(func() interface{} { return nil })
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "returned nil")
+	err = bind(func() interface{} { return (*model)(nil) })
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "returned a nil pointer")
+
+	var calls int
+	do := captureOutputChain("/x",
+		nvelope.NoLogger,
+		nvelope.InjectWriter,
+		nvelope.EncodeJSON,
+		nvelope.GenerateDecoder(
+			nvelope.WithModelFactory(reflect.TypeOf(model{}), func() interface{} {
+				calls++
+				switch calls {
+				case 1:
+					return &model{}
+				case 2:
+					return nil
+				default:
+					return otherModel{}
+				}
+			}),
+		),
+		func(m model) (nvelope.Response, error) {
+			return m, nil
+		},
+	)
+	assert.Regexp(t, `^500->.*returned nil`, do("/x?color=red"))
+	assert.Regexp(t, `^500->.*returned nvelope_test.otherModel`, do("/x?color=red"))
+}
+
+func TestDecodeRequired(t *testing.T) {
+	do := captureOutput("/x", func(s struct {
+		Value            int  `json:"value" nvelope:"query,name=v"`
+		Pointer          *int `json:"pointer" nvelope:"query,name=p"`
+		RequiredValue    int  `json:"rvalue" nvelope:"query,name=rv,required=true"`
+		RequiredPointer  *int `json:"rpointer" nvelope:"query,name=rp,required=true"`
+		RequiredHeader   int  `json:"-" nvelope:"header,name=X-Required,required=true"`
+		RequiredCookieP  *int `json:"-" nvelope:"cookie,name=c,required=true"`
+		OptionalHeaderP  *int `json:"-" nvelope:"header,name=X-Optional"`
+		OptionalCookie   int  `json:"-" nvelope:"cookie,name=o"`
+		DefaultedValue   int  `json:"-" nvelope:"query,name=d,default=7"`
+		DefaultedPointer *int `json:"-" nvelope:"query,name=dp,default=8"`
+	},
+	) (nvelope.Response, error) {
+		return s, nil
+	})
+	present := func(path string) string {
+		return do(path, header("X-Required", "1"), cookie("c", "2"))
+	}
+	cases := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"all present", present("/x?v=1&p=2&rv=3&rp=4"), `200->{"value":1,"pointer":2,"rvalue":3,"rpointer":4}`},
+		{"optional absent", present("/x?rv=3&rp=4"), `200->{"value":0,"pointer":null,"rvalue":3,"rpointer":4}`},
+		{"required present but zero", present("/x?rv=0&rp=0"), `200->{"value":0,"pointer":null,"rvalue":0,"rpointer":0}`},
+	}
+	for _, tc := range cases {
+		assert.Equal(t, tc.want, tc.got, tc.name)
+	}
+
+	assert.Regexp(t, `^400->.*query parameter 'rv' is required`, present("/x?rp=4"))
+	assert.Regexp(t, `^400->.*query parameter 'rp' is required`, present("/x?rv=3"))
+	assert.Regexp(t, `^400->.*header 'X-Required' is required`, do("/x?rv=3&rp=4", cookie("c", "2")))
+	assert.Regexp(t, `^400->.*cookie parameter 'c' is required`, do("/x?rv=3&rp=4", header("X-Required", "1")))
+
+	doPath := captureOutput("/x/{a}", func(s struct {
+		A string `json:"a" nvelope:"path,name=a,required=true"`
+		B string `json:"b" nvelope:"path,name=b"`
+	},
+	) (nvelope.Response, error) {
+		return s, nil
+	})
+	assert.Equal(t, `200->{"a":"foo","b":""}`, doPath("/x/foo"))
+
+	doBody := captureOutput("/x", func(s struct {
+		Body *thing `json:"body" nvelope:"model,required=true"`
+	},
+	) (nvelope.Response, error) {
+		return s, nil
+	})
+	assert.Regexp(t, `^400->.*request body is required`, doBody("/x"))
+}
+
+func TestDecodeRequiredSources(t *testing.T) {
+	do := captureOutput("/x", func(s struct {
+		Query      string            `json:"-" nvelope:"query,name=q,required=true"`
+		Form       string            `json:"-" nvelope:"query,name=f,form=true,required=true"`
+		FormOnly   string            `json:"-" nvelope:"query,name=o,formOnly=true,required=true"`
+		DeepQuery  map[string]string `json:"-" nvelope:"query,name=dq,deepObject=true,required=true"`
+		DeepForm   map[string]string `json:"-" nvelope:"query,name=df,deepObject=true,form=true,required=true"`
+		DeepFormOn map[string]string `json:"-" nvelope:"query,name=do,deepObject=true,formOnly=true,required=true"`
+	},
+	) (nvelope.Response, error) {
+		return "ok", nil
+	})
+	params := map[string]string{
+		"q":  "q=1",
+		"f":  "f=1",
+		"o":  "o=1",
+		"dq": "dq[a]=1",
+		"df": "df[a]=1",
+		"do": "do[a]=1",
+	}
+	// reads lists the sources each parameter is read from
+	reads := map[string][2]bool{
+		"q":  {true, false},
+		"f":  {true, true},
+		"o":  {false, true},
+		"dq": {true, false},
+		"df": {true, true},
+		"do": {false, true},
+	}
+	sources := []struct {
+		name        string
+		query, form bool
+	}{
+		{"neither", false, false},
+		{"query", true, false},
+		{"form", false, true},
+		{"both", true, true},
+	}
+	for name, param := range params {
+		for _, source := range sources {
+			// every other parameter is sent in both places
+			var query, form []string
+			for other, p := range params {
+				if other != name {
+					query = append(query, p)
+					form = append(form, p)
+				}
+			}
+			if source.query {
+				query = append(query, param)
+			}
+			if source.form {
+				form = append(form, param)
+			}
+			got := do("/x?"+strings.Join(query, "&"),
+				header("Content-Type", "application/x-www-form-urlencoded"),
+				body(strings.Join(form, "&")))
+			if (source.query && reads[name][0]) || (source.form && reads[name][1]) {
+				assert.Equal(t, `200->"ok"`, got, "%s in %s", name, source.name)
+			} else {
+				assert.Regexp(t, `^400->.*query parameter '`+name+`' is required`, got, "%s in %s", name, source.name)
+			}
+		}
+	}
+}
+
+func TestDecodeRequiredModel(t *testing.T) {
+	chain := func(endpoint interface{}) func(string, ...mod) string {
+		return captureOutputChain("/x",
+			nvelope.NoLogger,
+			nvelope.InjectWriter,
+			nvelope.EncodeJSON,
+			nvelope.ReadBody,
+			nvelope.GenerateDecoder(
+				nvelope.WithDecoder("application/json", json.Unmarshal),
+			),
+			endpoint,
+		)
+	}
+	do := chain(func(s struct {
+		Body thing `nvelope:"model,required=true"`
+	},
+	) (nvelope.Response, error) {
+		return s.Body, nil
+	})
+	assert.Regexp(t, `^400->.* model: request body is required$`, do("/x"), "no content type")
+	assert.Regexp(t, `^400->.* model: request body is required$`, do("/x", header("Content-Type", "application/json")))
+	assert.Equal(t, `200->{"I":3}`, do("/x", header("Content-Type", "application/json"), body(`{"I":3}`)))
+	assert.Regexp(t, `^400->.*No body decoder for content type`, do("/x", body(`{"I":3}`)),
+		"a missing content type is still reported when there is a body")
+
+	optional := chain(func(s struct {
+		Body thing `nvelope:"model"`
+	},
+	) (nvelope.Response, error) {
+		return s.Body, nil
+	})
+	assert.Regexp(t, `^400->.*No body decoder for content type`, optional("/x"), "without required=true")
+
+	withMsg := chain(func(s struct {
+		Body *thing `nvelope:"model,required=true,errMsg=please send a thing"`
+	},
+	) (nvelope.Response, error) {
+		return s.Body, nil
+	})
+	assert.Equal(t, `400->please send a thing`, withMsg("/x"))
+}
+
+func TestDecodeRequiredWithDefault(t *testing.T) {
+	var invoke func(http.ResponseWriter, *http.Request)
+	err := nject.Sequence("test",
+		nvelope.NoLogger,
+		nvelope.InjectWriter,
+		nvelope.EncodeJSON,
+		nape.DecodeJSON,
+		func(s struct {
+			V int `nvelope:"query,name=v,required=true,default=3"`
+		},
+		) (nvelope.Response, error) {
+			return s, nil
+		},
+	).Bind(&invoke, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "required and default cannot both be set")
+}
+
+func BenchmarkDecode(b *testing.B) {
+	var invoke func(http.ResponseWriter, *http.Request)
+	nject.MustBind(nject.Sequence("bench",
+		nvelope.NoLogger,
+		nvelope.MinimalErrorHandler,
+		nvelope.ReadBody,
+		nvelope.GenerateDecoder(
+			nvelope.WithDecoder("application/json", json.Unmarshal),
+			nvelope.WithPathVarsFunction(func(r *http.Request) nvelope.RouteVarLookup {
+				return func(string) string { return "38" }
+			}),
+		),
+		func(s struct {
+			ID     int            `nvelope:"path,name=id"`
+			Limit  int            `nvelope:"query,name=limit"`
+			Tags   []string       `nvelope:"query,name=tags"`
+			Filter map[string]int `nvelope:"query,name=filter,deepObject=true"`
+			Trace  string         `nvelope:"header,name=X-Trace"`
+		},
+		) error {
+			return nil
+		},
+	), &invoke, nil)
+	req := httptest.NewRequest("GET", "/x/38?limit=10&tags=a&tags=b&filter[x]=1&filter[y]=2", nil)
+	req.Header.Set("X-Trace", "abc")
+	w := httptest.NewRecorder()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		invoke(w, req)
+	}
+}
+
+type benchBindModel struct {
+	ID     int            `nvelope:"path,name=id"`
+	Limit  int            `nvelope:"query,name=limit"`
+	Tags   []string       `nvelope:"query,name=tags"`
+	Filter map[string]int `nvelope:"query,name=filter,deepObject=true"`
+	Trace  string         `nvelope:"header,name=X-Trace"`
+	Body   struct {
+		Name  string `json:"name"`
+		Count int    `json:"count"`
+	} `nvelope:"model"`
+}
+
+// benchmarkBindEndpoints binds many endpoints that share a model.  The
+// filler cache only helps when the endpoints share a decoder.
+func benchmarkBindEndpoints(b *testing.B, shared bool, reset bool) {
+	newDecoder := func() interface{} {
+		return nvelope.GenerateDecoder(
+			nvelope.WithDecoder("application/json", json.Unmarshal),
+			nvelope.WithPathVarsFunction(func(r *http.Request) nvelope.RouteVarLookup {
+				return func(string) string { return "38" }
+			}),
+		)
+	}
+	decoder := newDecoder()
+	const endpoints = 20
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for e := 0; e < endpoints; e++ {
+			if reset {
+				nvelope.ResetDecoderCache()
+			}
+			if !shared {
+				decoder = newDecoder()
+			}
+			var invoke func(http.ResponseWriter, *http.Request)
+			nject.MustBind(nject.Sequence("bench",
+				nvelope.NoLogger,
+				nvelope.MinimalErrorHandler,
+				nvelope.ReadBody,
+				decoder,
+				func(m benchBindModel) error {
+					return nil
+				},
+			), &invoke, nil)
+		}
+	}
+}
+
+func BenchmarkBindEndpointsSharedDecoder(b *testing.B)    { benchmarkBindEndpoints(b, true, false) }
+func BenchmarkBindEndpointsUncached(b *testing.B)         { benchmarkBindEndpoints(b, true, true) }
+func BenchmarkBindEndpointsSeparateDecoders(b *testing.B) { benchmarkBindEndpoints(b, false, false) }
+
+func TestDecodeRequest(t *testing.T) {
+	type model struct {
+		ID    int      `nvelope:"path,name=id"`
+		Limit int      `nvelope:"query,name=limit,default=10"`
+		Tags  []string `nvelope:"query,name=tag"`
+		Trace string   `nvelope:"header,name=X-Trace"`
+		Body  thing    `nvelope:"model"`
+	}
+	opts := []nvelope.DecodeInputsGeneratorOpt{
+		nvelope.WithDecoder("application/json", json.Unmarshal),
+		nvelope.WithPathVarsFunction(func(r *http.Request) nvelope.RouteVarLookup {
+			return func(string) string { return strings.TrimPrefix(r.URL.Path, "/x/") }
+		}),
+	}
+	cases := []struct {
+		name   string
+		target string
+		body   string
+		want   model
+		errRE  string
+	}{
+		{
+			name:   "everything",
+			target: "/x/38?tag=a&tag=b&limit=3",
+			body:   `{"I":4}`,
+			want:   model{ID: 38, Limit: 3, Tags: []string{"a", "b"}, Trace: "t", Body: thing{I: 4}},
+		},
+		{
+			name:   "defaults",
+			target: "/x/7",
+			body:   `{}`,
+			want:   model{ID: 7, Limit: 10, Trace: "t"},
+		},
+		{
+			name:   "bad limit",
+			target: "/x/7?limit=x",
+			body:   `{}`,
+			errRE:  `query parameter limit into field Limit`,
+		},
+	}
+	for _, tc := range cases {
+		r := httptest.NewRequest("POST", tc.target, strings.NewReader(tc.body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("X-Trace", "t")
+		var got model
+		err := nvelope.DecodeRequest(r, &got, opts...)
+		if tc.errRE != "" {
+			if assert.Error(t, err, tc.name) {
+				assert.Regexp(t, tc.errRE, err.Error(), tc.name)
+				assert.Equal(t, 400, nvelope.GetReturnCode(err), tc.name)
+			}
+			continue
+		}
+		if assert.NoError(t, err, tc.name) {
+			assert.Equal(t, tc.want, got, tc.name)
+		}
+		b, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		assert.Equal(t, tc.body, string(b), "body is still readable")
+	}
+
+	var m model
+	assert.Error(t, nvelope.DecodeRequest(httptest.NewRequest("GET", "/", nil), m, opts...))
+	assert.Error(t, nvelope.DecodeRequest(httptest.NewRequest("GET", "/x/3", nil), &m))
+}
+
+func TestDecodeRangeError(t *testing.T) {
+	var m struct {
+		Count int     `nvelope:"query,name=count,minimum=1,maximum=10"`
+		Ratio float64 `nvelope:"header,name=X-Ratio,maximum=0.5"`
+	}
+	cases := []struct {
+		target string
+		header string
+		want   nvelope.DecodeError
+		msg    string
+	}{
+		{"/?count=0", "", nvelope.DecodeError{Constraint: "minimum", Bound: 1.0, Value: 0}, "decode query count: 0 must be at least 1"},
+		{"/?count=11", "", nvelope.DecodeError{Constraint: "maximum", Bound: 10.0, Value: 11}, "decode query count: 11 must be at most 10"},
+		{"/?count=5", "0.75", nvelope.DecodeError{Constraint: "maximum", Bound: 0.5, Value: 0.75}, "decode header X-Ratio: 0.75 must be at most 0.5"},
+	}
+	for _, tc := range cases {
+		r := httptest.NewRequest("GET", tc.target, nil)
+		if tc.header != "" {
+			r.Header.Set("X-Ratio", tc.header)
+		}
+		err := nvelope.DecodeRequest(r, &m)
+		require.Error(t, err, tc.target)
+		var de *nvelope.DecodeError
+		if assert.True(t, errors.As(err, &de), tc.target) {
+			assert.Equal(t, tc.want, *de, tc.target)
+		}
+		assert.Contains(t, err.Error(), tc.msg, tc.target)
+		assert.Equal(t, 400, nvelope.GetReturnCode(err), tc.target)
+	}
+}
+
+func TestDecodeExclusiveRange(t *testing.T) {
+	type model struct {
+		Count int     `nvelope:"query,name=count,exclusiveMin=0,exclusiveMax=10"`
+		Ratio float64 `nvelope:"query,name=ratio,exclusiveMin=0,exclusiveMax=1"`
+	}
+	for _, target := range []string{"/?count=1", "/?count=9", "/?ratio=0.01", "/?ratio=0.99"} {
+		var m model
+		assert.NoError(t, nvelope.DecodeRequest(httptest.NewRequest("GET", target, nil), &m), target)
+	}
+	cases := []struct {
+		target string
+		msg    string
+	}{
+		{"/?count=0", "decode query count: 0 must be greater than 0"},
+		{"/?count=10", "decode query count: 10 must be less than 10"},
+		{"/?ratio=0", "decode query ratio: 0 must be greater than 0"},
+		{"/?ratio=1", "decode query ratio: 1 must be less than 1"},
+	}
+	for _, tc := range cases {
+		var m model
+		err := nvelope.DecodeRequest(httptest.NewRequest("GET", tc.target, nil), &m)
+		require.Error(t, err, tc.target)
+		assert.Contains(t, err.Error(), tc.msg, tc.target)
+		assert.Equal(t, 400, nvelope.GetReturnCode(err), tc.target)
+	}
+}
+
+func TestDecodeLargeIntegerRange(t *testing.T) {
+	type model struct {
+		Signed   int64  `nvelope:"query,name=signed,maximum=9007199254740992,minimum=-9007199254740992"`
+		Unsigned uint64 `nvelope:"query,name=unsigned,exclusiveMax=18014398509481984"`
+		Fraction int64  `nvelope:"query,name=fraction,minimum=2.5,maximum=4.5"`
+	}
+	for _, target := range []string{
+		"/?signed=9007199254740992",
+		"/?signed=-9007199254740992",
+		"/?unsigned=18014398509481983",
+		"/?fraction=3&fraction=4",
+	} {
+		var m model
+		assert.NoError(t, nvelope.DecodeRequest(httptest.NewRequest("GET", target, nil), &m), target)
+	}
+	cases := []struct {
+		target string
+		msg    string
+	}{
+		{"/?signed=9007199254740993", "9007199254740993 must be at most 9.007199254740992e+15"},
+		{"/?signed=-9007199254740993", "-9007199254740993 must be at least -9.007199254740992e+15"},
+		{"/?unsigned=18014398509481984", "18014398509481984 must be less than 1.8014398509481984e+16"},
+		{"/?unsigned=18014398509481985", "18014398509481985 must be less than"},
+		{"/?fraction=2", "2 must be at least 2.5"},
+		{"/?fraction=5", "5 must be at most 4.5"},
+	}
+	for _, tc := range cases {
+		var m model
+		err := nvelope.DecodeRequest(httptest.NewRequest("GET", tc.target, nil), &m)
+		require.Error(t, err, tc.target)
+		assert.Contains(t, err.Error(), tc.msg, tc.target)
+		assert.Equal(t, 400, nvelope.GetReturnCode(err), tc.target)
+	}
+}
+
+func TestSplitDeepObjectKey(t *testing.T) {
+	// splitDeepObjectKey replaced this regular expression
+	deepObjectRE := regexp.MustCompile(`^([^\[]+)\[([^\]]+)\]$`)
+	for _, key := range []string{
+		"a[b]", "id[name]", "a[b[c]", "a[[b]", "a[b]]", "a[b][c]", "a[]", "[b]", "a[b", "ab]", "a",
+		"", "[", "]", "[]", "a[b]c", "a[ ]", "a b[c d]", "a[b[]", "a[]]", "user[address][city]",
+		"a\n[b]", "a[b\n]", "é[ü]", "a[b]\n",
+	} {
+		name, inner, ok := nvelope.SplitDeepObjectKey(key)
+		m := deepObjectRE.FindStringSubmatch(key)
+		if m == nil {
+			assert.False(t, ok, "%q", key)
+			continue
+		}
+		if assert.True(t, ok, "%q", key) {
+			assert.Equal(t, m[1], name, "%q", key)
+			assert.Equal(t, m[2], inner, "%q", key)
+		}
+	}
+}
+
+func TestDecodeMultipleOf(t *testing.T) {
+	type model struct {
+		Count int     `nvelope:"query,name=count,multipleOf=5"`
+		Price float64 `nvelope:"query,name=price,multipleOf=0.01"`
+		Big   int64   `nvelope:"query,name=big,multipleOf=5"`
+		Size  uint64  `nvelope:"query,name=size,multipleOf=3"`
+		Half  int     `nvelope:"query,name=half,multipleOf=0.5"`
+		Small float32 `nvelope:"query,name=small,multipleOf=0.1"`
+	}
+	for _, target := range []string{
+		"/?count=0", "/?count=15", "/?count=-10", "/?price=19.99", "/?price=0.07", "/?price=1234567.89",
+		"/?price=10000000.01", "/?price=99999999999.99", "/?big=9007199254740995", "/?size=18446744073709551615",
+		"/?half=7", "/?small=0.3", "/?small=12345.6",
+	} {
+		var m model
+		assert.NoError(t, nvelope.DecodeRequest(httptest.NewRequest("GET", target, nil), &m), target)
+	}
+	cases := []struct {
+		target string
+		msg    string
+	}{
+		{"/?count=7", "decode query count: 7 must be a multiple of 5"},
+		{"/?price=19.995", "decode query price: 19.995 must be a multiple of 0.01"},
+		{"/?count=2000000001", "decode query count: 2000000001 must be a multiple of 5"},
+		{"/?price=10000000.005", "decode query price: 1.0000000005e+07 must be a multiple of 0.01"},
+		{"/?big=9007199254740993", "decode query big: 9007199254740993 must be a multiple of 5"},
+		{"/?size=18446744073709551614", "decode query size: 18446744073709551614 must be a multiple of 3"},
+		{"/?small=0.35", "must be a multiple of 0.1"},
+	}
+	for _, tc := range cases {
+		var m model
+		err := nvelope.DecodeRequest(httptest.NewRequest("GET", tc.target, nil), &m)
+		require.Error(t, err, tc.target)
+		assert.Contains(t, err.Error(), tc.msg, tc.target)
+		assert.Equal(t, 400, nvelope.GetReturnCode(err), tc.target)
+	}
+}
+
+func TestDecodeFormat(t *testing.T) {
+	nvelope.RegisterFormat("even-length", func(s string) error {
+		if len(s)%2 != 0 {
+			return fmt.Errorf("odd")
+		}
+		return nil
+	})
+	type model struct {
+		Email string   `nvelope:"query,name=email,format=email"`
+		IDs   []string `nvelope:"query,name=id,format=uuid"`
+		Pair  string   `nvelope:"header,name=X-Pair,format=even-length"`
+	}
+	valid := []string{
+		"/?email=joe@example.com",
+		"/?id=123e4567-e89b-12d3-a456-426614174000&id=00000000-0000-0000-0000-000000000000",
+	}
+	for _, target := range valid {
+		var m model
+		assert.NoError(t, nvelope.DecodeRequest(httptest.NewRequest("GET", target, nil), &m), target)
+	}
+	cases := []struct {
+		target string
+		msg    string
+	}{
+		{"/?email=joe", "decode query email: joe must be a valid email"},
+		{"/?email=" + e("Joe <joe@example.com>"), "must be a valid email"},
+		{"/?id=123e4567-e89b-12d3-a456-426614174000&id=nope", "decode query id: nope must be a valid uuid"},
+		{"/?id=123e4567e89b12d3a456426614174000", "must be a valid uuid"},
+	}
+	for _, tc := range cases {
+		var m model
+		err := nvelope.DecodeRequest(httptest.NewRequest("GET", tc.target, nil), &m)
+		require.Error(t, err, tc.target)
+		assert.Contains(t, err.Error(), tc.msg, tc.target)
+		assert.Equal(t, 400, nvelope.GetReturnCode(err), tc.target)
+		var de *nvelope.DecodeError
+		if assert.True(t, errors.As(err, &de), tc.target) {
+			assert.Equal(t, "format", de.Constraint, tc.target)
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Pair", "abc")
+	var m model
+	assert.Contains(t, fmt.Sprint(nvelope.DecodeRequest(r, &m)), "abc must be a valid even-length")
+
+	var bad struct {
+		Count int `nvelope:"query,name=count,format=email"`
+	}
+	assert.Error(t, nvelope.DecodeRequest(httptest.NewRequest("GET", "/", nil), &bad), "format on a number")
+	var unknown struct {
+		S string `nvelope:"query,name=s,format=nope"`
+	}
+	assert.Error(t, nvelope.DecodeRequest(httptest.NewRequest("GET", "/", nil), &unknown), "unknown format")
+}
+
+func TestDecodeLength(t *testing.T) {
+	type model struct {
+		Name  string `nvelope:"query,name=name,minLength=2,maxLength=4"`
+		Bytes string `nvelope:"query,name=bytes,maxLength=4,byteLength=true"`
+	}
+	valid := []string{"/?name=ab", "/?name=abcd", "/?name=" + e("日本語!"), "/?bytes=abcd", "/?bytes=" + e("é")}
+	for _, target := range valid {
+		var m model
+		assert.NoError(t, nvelope.DecodeRequest(httptest.NewRequest("GET", target, nil), &m), target)
+	}
+	cases := []struct {
+		target string
+		msg    string
+	}{
+		{"/?name=a", "decode query name: a must be of length at least 2"},
+		{"/?name=abcde", "decode query name: abcde must be of length at most 4"},
+		{"/?name=" + e("日本語です!"), "must be of length at most 4"},
+		{"/?name=" + e("日"), "must be of length at least 2"},
+		{"/?bytes=" + e("日本"), "must be of length at most 4"},
+	}
+	for _, tc := range cases {
+		var m model
+		err := nvelope.DecodeRequest(httptest.NewRequest("GET", tc.target, nil), &m)
+		require.Error(t, err, tc.target)
+		assert.Contains(t, err.Error(), tc.msg, tc.target)
+		assert.Equal(t, 400, nvelope.GetReturnCode(err), tc.target)
+	}
+}
@@ -1,6 +1,7 @@
 package nvelope
 
 import (
+	"bytes"
 	"io"
 	"net/http"
 
@@ -18,6 +19,13 @@ type DeferredWriter struct {
 	status      int
 	resetHeader http.Header
 	flushed     bool
+	threshold   int
+	bypassed    bool
+	// sentStatus and sentBytes are what has been passed to base,
+	// for AfterResponse
+	sentStatus  int
+	sentBytes   int
+	responseErr error
 }
 
 // NewDeferredWriter returns a DeferredWriter based on a
@@ -33,6 +41,27 @@ func NewDeferredWriter(w http.ResponseWriter) (*DeferredWriter, http.ResponseWri
 	return dw, dw
 }
 
+// NewThresholdDeferredWriter returns a DeferredWriter that skips buffering
+// for small responses: if nothing has been buffered yet and a write is
+// smaller than threshold bytes, the DeferredWriter is flushed and
+// the write is passed straight through to the base writer.  Larger
+// writes are buffered as usual.
+//
+// This avoids copying small responses at the cost of the Reset contract:
+// once a small write has passed through, the DeferredWriter is in
+// passthrough mode and Reset() will return an error.  Only use it for
+// endpoints that write their response all at once, like those
+// using MakeResponseEncoder.
+func NewThresholdDeferredWriter(w http.ResponseWriter, threshold int) (*DeferredWriter, http.ResponseWriter) {
+	dw := &DeferredWriter{
+		base:        w,
+		header:      w.Header().Clone(),
+		resetHeader: w.Header().Clone(),
+		threshold:   threshold,
+	}
+	return dw, dw
+}
+
 // Header is the same as http.ResponseWriter.Header
 func (w *DeferredWriter) Header() http.Header {
 	if w.passthrough {
@@ -45,7 +74,18 @@ func (w *DeferredWriter) Header() http.Header {
 // except that the action is delayed until Flush() is called.
 func (w *DeferredWriter) Write(b []byte) (int, error) {
 	if w.passthrough {
-		return w.base.Write(b)
+		n, err := w.base.Write(b)
+		w.sentBytes += n
+		return n, err
+	}
+	if len(b) < w.threshold && len(w.buffer) == 0 {
+		if err := w.Flush(); err != nil {
+			return 0, err
+		}
+		w.bypassed = true
+		n, err := w.base.Write(b)
+		w.sentBytes += n
+		return n, err
 	}
 	w.buffer = append(w.buffer, b...)
 	return len(b), nil
@@ -55,6 +95,9 @@ func (w *DeferredWriter) Write(b []byte) (int, error) {
 // except that the action is delayed until Flush() is called.
 func (w *DeferredWriter) WriteHeader(statusCode int) {
 	if w.passthrough {
+		if w.sentStatus == 0 {
+			w.sentStatus = statusCode
+		}
 		w.base.WriteHeader(statusCode)
 	} else {
 		w.status = statusCode
@@ -115,7 +158,13 @@ func (w *DeferredWriter) UnderlyingWriter() http.ResponseWriter {
 // You can only flush once.  After a flush, all further calls are passed
 // through to be base writer.  WriteHeader() will be called on the base
 // writer even if there is no buffered data.
+//
+// Calling Flush again after a Flush is a no-op that returns nil. Calling
+// Flush after UnderlyingWriter (without a prior Flush) returns an error.
 func (w *DeferredWriter) Flush() error {
+	if w.flushed {
+		return nil
+	}
 	if w.passthrough {
 		return errors.New("Attempt flush deferred writer that is not deferred")
 	}
@@ -123,9 +172,11 @@ func (w *DeferredWriter) Flush() error {
 	base := w.UnderlyingWriter()
 	if w.status != 0 {
 		base.WriteHeader(w.status)
+		w.sentStatus = w.status
 	}
 	for i := 0; i < len(w.buffer)-1; {
 		amt, err := base.Write(w.buffer[i:])
+		w.sentBytes += amt
 		if err != nil {
 			// Is this handling of short writes necessary?  Perhaps
 			// so since a follow-up write will probably give a
@@ -157,11 +208,36 @@ func (w *DeferredWriter) Done() bool {
 
 // Body returns the internal buffer used by DeferredWriter.  Do not modify it.
 // It also returns the status code (if set).
-// If UnderlyingWriter() has been called, then Body() will return an error since
+// If UnderlyingWriter() has been called, or if a write skipped the buffer
+// (see NewThresholdDeferredWriter), then Body() will return an error since
 // the underlying buffer does not represent what has been written.
 func (w *DeferredWriter) Body() ([]byte, int, error) {
-	if w.passthrough && !w.flushed {
-		return nil, 0, errors.New("unable to provide body because DeferredWriter is operating in passthrough mode")
+	if err := w.bodyUnavailable(); err != nil {
+		return nil, 0, err
 	}
 	return w.buffer, w.status, nil
 }
+
+// BodyReader returns a reader over the internal buffer used by
+// DeferredWriter.  Unlike Body(), the buffer cannot be modified through
+// the reader.  Writes made after BodyReader is called are not seen
+// by the reader.  Like Body(), it returns an error if UnderlyingWriter()
+// has been called or if a write skipped the buffer.
+func (w *DeferredWriter) BodyReader() (io.Reader, error) {
+	if err := w.bodyUnavailable(); err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(w.buffer), nil
+}
+
+// bodyUnavailable returns an error if the buffer does not hold what
+// has been written
+func (w *DeferredWriter) bodyUnavailable() error {
+	if w.bypassed {
+		return errors.New("unable to provide body because DeferredWriter passed a small write through without buffering it")
+	}
+	if w.passthrough && !w.flushed {
+		return errors.New("unable to provide body because DeferredWriter is operating in passthrough mode")
+	}
+	return nil
+}
@@ -3,9 +3,14 @@ package nvelope_test
 import (
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"syscall"
 	"testing"
 
+	"github.com/muir/nject"
 	"github.com/muir/nvelope"
 
 	"github.com/stretchr/testify/assert"
@@ -93,6 +98,31 @@ func TestFlush(t *testing.T) {
 	assert.Equal(t, []byte("howdy"), body, code, "body")
 }
 
+func TestBodyReader(t *testing.T) {
+	tw := &testResponseWriter{header: make(http.Header)}
+	w, _ := nvelope.NewDeferredWriter(tw)
+	_, _ = w.Write([]byte("howdy"))
+	r, err := w.BodyReader()
+	require.NoError(t, err, "body reader")
+	_, _ = w.Write([]byte(" there"))
+	b, err := io.ReadAll(r)
+	require.NoError(t, err, "read")
+	assert.Equal(t, "howdy", string(b), "later writes not seen")
+	body, _, err := w.Body()
+	require.NoError(t, err, "body")
+	assert.Equal(t, "howdy there", string(body), "buffer untouched by reading")
+
+	r, err = w.BodyReader()
+	require.NoError(t, err, "second body reader")
+	b, err = io.ReadAll(r)
+	require.NoError(t, err, "second read")
+	assert.Equal(t, "howdy there", string(b), "new reader sees all writes")
+
+	_ = w.UnderlyingWriter()
+	_, err = w.BodyReader()
+	assert.Error(t, err, "body reader after Underlying")
+}
+
 func TestReset(t *testing.T) {
 	tw := &testResponseWriter{header: make(http.Header)}
 	tw.Header().Set("a", "b")
@@ -164,3 +194,161 @@ func TestPreserveHeader(t *testing.T) {
 	assert.Equal(t, "d", tw.Header().Get("c"), "new header written - c")
 	assert.Equal(t, "", tw.Header().Get("d"), "new header written - d")
 }
+
+func TestDoubleFlush(t *testing.T) {
+	tw := &testResponseWriter{header: make(http.Header)}
+	w, _ := nvelope.NewDeferredWriter(tw)
+	_, _ = w.Write([]byte("howdy"))
+	w.WriteHeader(201)
+	require.NoError(t, w.Flush(), "first flush")
+	require.NoError(t, w.Flush(), "second flush")
+	require.NoError(t, w.FlushIfNotFlushed(), "flush if not flushed")
+	assert.Equal(t, "howdy", string(tw.buffer), "written once")
+	assert.Equal(t, 201, tw.code, "code")
+
+	tw = &testResponseWriter{header: make(http.Header)}
+	w, _ = nvelope.NewDeferredWriter(tw)
+	_ = w.UnderlyingWriter()
+	assert.Error(t, w.Flush(), "flush after UnderlyingWriter")
+}
+
+func TestLoggingAutoFlushWriterError(t *testing.T) {
+	tw := &testResponseWriter{
+		header:             make(http.Header),
+		simulateWriteError: fmt.Errorf("broken pipe"),
+	}
+	logger := &testLogger{}
+	var handlerRan bool
+	nject.MustRun("test",
+		func() http.ResponseWriter { return tw },
+		logger.provider(),
+		nvelope.InjectWriter,
+		nvelope.LoggingAutoFlushWriter,
+		func(w http.ResponseWriter) {
+			handlerRan = true
+			_, _ = w.Write([]byte("hi"))
+		},
+	)
+	assert.True(t, handlerRan, "handler ran")
+	require.Len(t, logger.logged, 1, "logged")
+	assert.Equal(t, "warn: Cannot flush response error=flush buffered writer: broken pipe", logger.logged[0])
+	assert.Empty(t, tw.buffer, "nothing written")
+}
+
+func TestAutoFlushWriterWithoutLogger(t *testing.T) {
+	tw := &testResponseWriter{
+		header:             make(http.Header),
+		simulateWriteError: fmt.Errorf("broken pipe"),
+	}
+	var handlerRan bool
+	nject.MustRun("test",
+		func() http.ResponseWriter { return tw },
+		nvelope.InjectWriter,
+		nvelope.AutoFlushWriter,
+		func(w http.ResponseWriter) {
+			handlerRan = true
+			_, _ = w.Write([]byte("hi"))
+		},
+	)
+	assert.True(t, handlerRan, "binds without a BasicLogger")
+
+	tw = &testResponseWriter{header: make(http.Header)}
+	nject.MustRun("test",
+		func() http.ResponseWriter { return tw },
+		nvelope.InjectWriter,
+		nvelope.AutoFlushWriter,
+		func(w http.ResponseWriter) {
+			_, _ = w.Write([]byte("hi"))
+		},
+	)
+	assert.Equal(t, "hi", string(tw.buffer), "flushed")
+}
+
+func TestClientDisconnect(t *testing.T) {
+	tw := &testResponseWriter{
+		header:             make(http.Header),
+		simulateWriteError: &net.OpError{Op: "write", Net: "tcp", Err: os.NewSyscallError("write", syscall.ECONNRESET)},
+	}
+	logger := &testLogger{}
+	nject.MustRun("test",
+		func() http.ResponseWriter { return tw },
+		func() *http.Request { return httptest.NewRequest("GET", "/x", nil) },
+		logger.provider(),
+		nvelope.InjectWriter,
+		nvelope.EncodeJSON,
+		func() (nvelope.Response, error) {
+			return "hi", nil
+		},
+	)
+	require.Len(t, logger.logged, 1, "logged")
+	assert.Equal(t, "info: Client disconnected error=flush buffered writer: write tcp: write: connection reset by peer method=GET uri=/x", logger.logged[0])
+
+	tw.simulateWriteError = os.NewSyscallError("write", syscall.EPIPE)
+	logger.logged = nil
+	nject.MustRun("test",
+		func() http.ResponseWriter { return tw },
+		logger.provider(),
+		nvelope.InjectWriter,
+		nvelope.LoggingAutoFlushWriter,
+		func(w http.ResponseWriter) {
+			_, _ = w.Write([]byte("hi"))
+		},
+	)
+	require.Len(t, logger.logged, 1, "logged")
+	assert.Equal(t, "info: Client disconnected error=flush buffered writer: write: broken pipe", logger.logged[0])
+}
+
+func TestThresholdDeferredWriter(t *testing.T) {
+	tw := &testResponseWriter{header: make(http.Header)}
+	w, _ := nvelope.NewThresholdDeferredWriter(tw, 10)
+	w.Header().Set("a", "b")
+	w.WriteHeader(202)
+	_, _ = w.Write([]byte("small"))
+	assert.True(t, w.Done(), "passthrough after small write")
+	assert.Equal(t, "small", string(tw.buffer), "written immediately")
+	assert.Equal(t, 202, tw.code, "code")
+	assert.Equal(t, "b", tw.Header().Get("a"), "header")
+	assert.Error(t, w.Reset(), "reset after passthrough")
+	require.NoError(t, w.FlushIfNotFlushed())
+	_, _, err := w.Body()
+	assert.Error(t, err, "body after small write")
+	_, err = w.BodyReader()
+	assert.Error(t, err, "body reader after small write")
+
+	tw = &testResponseWriter{header: make(http.Header)}
+	w, _ = nvelope.NewThresholdDeferredWriter(tw, 10)
+	_, _ = w.Write([]byte("a large write"))
+	_, _ = w.Write([]byte("tiny"))
+	assert.False(t, w.Done(), "buffering after large write")
+	assert.Empty(t, tw.buffer, "nothing written yet")
+	require.NoError(t, w.Reset())
+	_, _ = w.Write([]byte("replacement!"))
+	require.NoError(t, w.Flush())
+	assert.Equal(t, "replacement!", string(tw.buffer), "reset worked")
+	body, _, err := w.Body()
+	require.NoError(t, err, "body after buffered write")
+	assert.Equal(t, "replacement!", string(body), "body")
+}
+
+func benchmarkDeferredWriter(b *testing.B, newWriter func(http.ResponseWriter) (*nvelope.DeferredWriter, http.ResponseWriter)) {
+	body := []byte(`{"status":"ok"}`)
+	tw := &testResponseWriter{header: make(http.Header)}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tw.buffer = tw.buffer[:0]
+		w, _ := newWriter(tw)
+		_, _ = w.Write(body)
+		_ = w.FlushIfNotFlushed()
+	}
+}
+
+func BenchmarkDeferredWriter(b *testing.B) {
+	benchmarkDeferredWriter(b, nvelope.NewDeferredWriter)
+}
+
+func BenchmarkThresholdDeferredWriter(b *testing.B) {
+	benchmarkDeferredWriter(b, func(w http.ResponseWriter) (*nvelope.DeferredWriter, http.ResponseWriter) {
+		return nvelope.NewThresholdDeferredWriter(w, 1024)
+	})
+}
@@ -0,0 +1,136 @@
+package nvelope
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/muir/nject"
+)
+
+type dumpOptions struct {
+	enabled func(*http.Request) bool
+	redact  map[string]struct{}
+}
+
+// DumpOpt are options for DumpRequestResponse
+type DumpOpt func(*dumpOptions)
+
+// DumpIf limits dumping to requests for which enabled returns true,
+// for example requests that have a debugging header.
+func DumpIf(enabled func(r *http.Request) bool) DumpOpt {
+	return func(o *dumpOptions) {
+		o.enabled = enabled
+	}
+}
+
+// DumpRedactHeaders replaces the list of headers whose values are
+// replaced by "[REDACTED]" in dumps.  The default list is Authorization,
+// Proxy-Authorization, Cookie, and Set-Cookie.
+func DumpRedactHeaders(names ...string) DumpOpt {
+	return func(o *dumpOptions) {
+		o.redact = make(map[string]struct{}, len(names))
+		for _, name := range names {
+			o.redact[http.CanonicalHeaderKey(name)] = struct{}{}
+		}
+	}
+}
+
+// DumpRequestResponse generates a wrapper that logs each request and
+// its response at debug level.  The request is logged before it is
+// handled: its request line, headers, and body.  The response is
+// logged after it has been written: its status, headers, and body.
+// It is meant as a debugging aid.  The response body is taken from the
+// DeferredWriter's buffer so it does not have to be copied.
+//
+// DumpRequestResponse must be downstream from InjectWriter and upstream
+// from the response encoder.
+//
+//	nvelope.InjectWriter,
+//	nvelope.DumpRequestResponse(log,
+//		nvelope.DumpIf(func(r *http.Request) bool {
+//			return r.Header.Get("X-Debug") != ""
+//		})),
+//	nvelope.EncodeJSON,
+//
+// The values of sensitive headers are redacted (see DumpRedactHeaders)
+// but bodies are logged as they are.  Responses written with
+// NewThresholdDeferredWriter that skipped the buffer are logged without
+// their body.
+func DumpRequestResponse(log BasicLogger, opts ...DumpOpt) nject.Provider {
+	o := dumpOptions{}
+	DumpRedactHeaders("Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie")(&o)
+	for _, f := range opts {
+		f(&o)
+	}
+	return nject.Provide("dump-request-response", func(inner func(), w *DeferredWriter, r *http.Request) {
+		if o.enabled != nil && !o.enabled(r) {
+			inner()
+			return
+		}
+		fields := map[string]interface{}{
+			"request": fmt.Sprintf("%s %s %s", r.Method, r.URL.RequestURI(), r.Proto),
+			"host":    r.Host,
+			"headers": o.headers(r.Header),
+		}
+		if r.Body != nil {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				fields["bodyError"] = err.Error()
+				r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), errorReader{err: err}))
+			} else {
+				r.Body = io.NopCloser(bytes.NewReader(body))
+			}
+			fields["body"] = string(body)
+		}
+		log.Debug("HTTP request", fields)
+
+		inner()
+
+		status := w.sentStatus
+		if status == 0 {
+			status = w.status
+		}
+		if status == 0 {
+			status = http.StatusOK
+		}
+		fields = map[string]interface{}{
+			"request": fields["request"],
+			"status":  status,
+			"headers": o.headers(w.Header()),
+		}
+		body, _, err := w.Body()
+		switch {
+		case err != nil:
+			fields["body"] = "(not available: " + err.Error() + ")"
+		case w.sentBytes > len(body):
+			fields["body"] = "(not buffered)"
+		default:
+			fields["body"] = string(body)
+		}
+		log.Debug("HTTP response", fields)
+	})
+}
+
+// headers formats headers in wire format with sensitive values redacted
+func (o dumpOptions) headers(h http.Header) string {
+	redacted := make(http.Header, len(h))
+	for key, values := range h {
+		if _, ok := o.redact[http.CanonicalHeaderKey(key)]; ok {
+			values = []string{"[REDACTED]"}
+		}
+		redacted[key] = values
+	}
+	var buf bytes.Buffer
+	_ = redacted.Write(&buf)
+	return buf.String()
+}
+
+// errorReader returns err once the request body has been consumed so
+// that downstream readers see the same failure
+type errorReader struct {
+	err error
+}
+
+func (e errorReader) Read([]byte) (int, error) { return 0, e.err }
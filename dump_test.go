@@ -0,0 +1,82 @@
+package nvelope_test
+
+import (
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/muir/nvelope"
+	"github.com/muir/nvelope/nvelopetest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDumpRequestResponse(t *testing.T) {
+	makeHandler := func(log *testLogger, opts ...nvelope.DumpOpt) http.HandlerFunc {
+		h, err := nvelopetest.Handler(
+			nvelope.NoLogger,
+			nvelope.InjectWriter,
+			nvelope.DumpRequestResponse(log, opts...),
+			nvelope.EncodeJSON,
+			func(w http.ResponseWriter, r *http.Request) (nvelope.Response, error) {
+				body, err := io.ReadAll(r.Body)
+				if err != nil {
+					return nil, err
+				}
+				http.SetCookie(w, &http.Cookie{Name: "session", Value: "s3cret"})
+				w.Header().Set("X-Echo", "yes")
+				return map[string]string{"got": string(body)}, nil
+			},
+		)
+		require.NoError(t, err)
+		return h
+	}
+
+	var log testLogger
+	res := nvelopetest.Do(makeHandler(&log), "POST", "/things?id=3",
+		nvelopetest.Body(`{"a":1}`),
+		nvelopetest.Header("Authorization", "Bearer t0ken"),
+		nvelopetest.Cookie("session", "c00kie"),
+		nvelopetest.Header("X-Trace", "abc"),
+	)
+	assert.Equal(t, `200->{"got":"{\"a\":1}"}`, res.String(), "the endpoint still sees the request body")
+	require.Len(t, log.logged, 2)
+	request, response := log.logged[0], log.logged[1]
+
+	assert.Contains(t, request, "debug: HTTP request")
+	assert.Contains(t, request, "request=POST /things?id=3 HTTP/1.1")
+	assert.Contains(t, request, `body={"a":1}`)
+	assert.Contains(t, request, "Authorization: [REDACTED]")
+	assert.Contains(t, request, "Cookie: [REDACTED]")
+	assert.Contains(t, request, "X-Trace: abc")
+	assert.NotContains(t, request, "t0ken")
+	assert.NotContains(t, request, "c00kie")
+
+	assert.Contains(t, response, "debug: HTTP response")
+	assert.Contains(t, response, "request=POST /things?id=3 HTTP/1.1")
+	assert.Contains(t, response, "status=200")
+	assert.Contains(t, response, `body={"got":"{\"a\":1}"}`)
+	assert.Contains(t, response, "Set-Cookie: [REDACTED]")
+	assert.Contains(t, response, "X-Echo: yes")
+	assert.NotContains(t, response, "s3cret")
+
+	log = testLogger{}
+	nvelopetest.Do(makeHandler(&log, nvelope.DumpRedactHeaders("x-trace")), "GET", "/",
+		nvelopetest.Header("Authorization", "Bearer t0ken"),
+		nvelopetest.Header("X-Trace", "abc"),
+	)
+	require.Len(t, log.logged, 2)
+	assert.Contains(t, log.logged[0], "Authorization: Bearer t0ken", "the default list is replaced")
+	assert.Contains(t, log.logged[0], "X-Trace: [REDACTED]")
+	assert.NotContains(t, log.logged[0], "abc")
+
+	log = testLogger{}
+	h := makeHandler(&log, nvelope.DumpIf(func(r *http.Request) bool {
+		return r.Header.Get("X-Debug") != ""
+	}))
+	nvelopetest.Do(h, "GET", "/")
+	assert.Empty(t, log.logged)
+	nvelopetest.Do(h, "GET", "/", nvelopetest.Header("X-Debug", "1"))
+	assert.Len(t, log.logged, 2)
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"encoding/xml"
 	"net/http"
+	"reflect"
+	"syscall"
 
 	"github.com/muir/nject"
 
@@ -14,13 +16,88 @@ import (
 // InjectWriter injects a DeferredWriter
 var InjectWriter = nject.Provide("writer", NewDeferredWriter)
 
+// InjectThresholdWriter injects a DeferredWriter created with
+// NewThresholdDeferredWriter.  Use it instead of InjectWriter.
+func InjectThresholdWriter(threshold int) nject.Provider {
+	return nject.Provide("threshold-writer", func(w http.ResponseWriter) (*DeferredWriter, http.ResponseWriter) {
+		return NewThresholdDeferredWriter(w, threshold)
+	})
+}
+
 // AutoFlushWriter calls Flush on the deferred writer if it hasn't
-// already been done
+// already been done.  Errors from flushing are ignored: use
+// LoggingAutoFlushWriter to log them.
 var AutoFlushWriter = nject.Provide("autoflush-writer", func(inner func(), w *DeferredWriter) {
 	inner()
 	_ = w.FlushIfNotFlushed()
 })
 
+// LoggingAutoFlushWriter is like AutoFlushWriter but errors from
+// flushing, usually because the client has disconnected, are logged.
+// It requires a BasicLogger.  Since the response cannot be delivered,
+// no error response is attempted.
+var LoggingAutoFlushWriter = nject.Provide("logging-autoflush-writer", func(inner func(), w *DeferredWriter, log BasicLogger) {
+	inner()
+	if err := w.FlushIfNotFlushed(); err != nil {
+		logWriteError(log, "Cannot flush response", err, map[string]interface{}{})
+	}
+})
+
+// AfterResponse generates a wrapper that calls a function after the
+// response has been written and flushed.  Use it to record metrics or
+// release resources.  The function is given the HTTP status that was
+// sent, the number of body bytes that were sent, and the error, if any,
+// that the response encoder turned into the response.  Like
+// AutoFlushWriter, it flushes the DeferredWriter if that hasn't been done.
+// If there was no other error and that flush fails, the error from
+// flushing is passed to the function.  No BasicLogger is required.
+//
+// AfterResponse must be downstream from InjectWriter and upstream from
+// the response encoder.  Panics are only seen as errors if they are
+// caught by CatchPanic downstream from the encoder.
+//
+//	nvelope.InjectWriter,
+//	nvelope.AfterResponse(func(status int, size int, err error) {
+//		metrics.Record(status, size)
+//	}),
+//	nvelope.EncodeJSON,
+//	nvelope.CatchPanic,
+//
+// Bytes written directly to the writer returned by
+// DeferredWriter.UnderlyingWriter() are not counted.
+func AfterResponse(callback func(status int, size int, err error)) nject.Provider {
+	return nject.Provide("after-response", func(inner func(), w *DeferredWriter) {
+		inner()
+		err := w.responseErr
+		if flushErr := w.FlushIfNotFlushed(); flushErr != nil && err == nil {
+			err = flushErr
+		}
+		status := w.sentStatus
+		if status == 0 {
+			status = http.StatusOK
+		}
+		callback(status, w.sentBytes, err)
+	})
+}
+
+// logWriteError logs an error from writing a response.  A client
+// that disconnects before the response is written is not a problem
+// with the server, so that is logged at info level.
+func logWriteError(log BasicLogger, msg string, err error, fields map[string]interface{}) {
+	fields["error"] = err.Error()
+	if isClientDisconnect(err) {
+		logInfo(log, "Client disconnected", fields)
+		return
+	}
+	log.Warn(msg, fields)
+}
+
+// isClientDisconnect returns true for errors that result from writing
+// to a connection that the client has closed
+func isClientDisconnect(err error) bool {
+	return errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET)
+}
+
 // Response is an empty interface that is the expected return value
 // from endpoints.
 type Response interface{}
@@ -50,10 +127,17 @@ var EncodeXML = MakeResponseEncoder("XML",
 	))
 
 type encoderOptions struct {
-	encoders         map[string]specificEncoder
-	contentOffers    []string
-	defaultEncoder   string
-	errorTransformer ErrorTranformer
+	encoders            map[string]specificEncoder
+	contentOffers       []string
+	defaultEncoder      string
+	errorTransformer    ErrorTranformer
+	suppressErrorBody   map[int]bool
+	rawBytesPassthrough bool
+	streamJSONArrays    bool
+	envelope            reflect.Type
+	resetOnError        bool
+	keepOnError         []string
+	errorLocalizer      ErrorLocalizer
 }
 
 type specificEncoder struct {
@@ -105,6 +189,125 @@ func WithErrorModel(errorTransformer ErrorTranformer) ResponseEncoderFuncArg {
 	}
 }
 
+// SuppressErrorBodyFor prevents error details from being sent to
+// clients for the listed HTTP status codes.  Instead of the error, the
+// client receives a generic message from http.StatusText.  The message
+// is encoded like any other error so WithErrorModel is given an error
+// whose Error() is the generic message.  The full error is still logged.
+func SuppressErrorBodyFor(codes ...int) ResponseEncoderFuncArg {
+	return func(o *encoderOptions) {
+		if o.suppressErrorBody == nil {
+			o.suppressErrorBody = make(map[int]bool)
+		}
+		for _, code := range codes {
+			o.suppressErrorBody[code] = true
+		}
+	}
+}
+
+// ResetOnError causes the DeferredWriter to be reset before an
+// error response is written so that a partially written response
+// and headers set by the failing handler are not sent along with the
+// error.  Headers saved with DeferredWriter.PreserveHeader survive the
+// reset.  So do the headers named in keepHeaders if they were set
+// when the error happened.  This is useful for CORS headers:
+//
+//	nvelope.ResetOnError("Access-Control-Allow-Origin", "Vary")
+func ResetOnError(keepHeaders ...string) ResponseEncoderFuncArg {
+	return func(o *encoderOptions) {
+		o.resetOnError = true
+		for _, name := range keepHeaders {
+			o.keepOnError = append(o.keepOnError, http.CanonicalHeaderKey(name))
+		}
+	}
+}
+
+// resetForError resets w, keeping the named headers
+func resetForError(w *DeferredWriter, keep []string) {
+	saved := make(http.Header)
+	for _, name := range keep {
+		if v, ok := w.Header()[name]; ok {
+			saved[name] = v
+		}
+	}
+	if w.Reset() != nil {
+		return
+	}
+	for name, v := range saved {
+		w.Header()[name] = v
+	}
+}
+
+// RawBytesPassthrough true causes []byte responses to be sent
+// as-is rather than being encoded.  The Content-Type is set
+// using http.DetectContentType.
+//
+// Handlers can send bytes that they have already compressed by setting
+// the Content-Encoding header (for example to "gzip") on the DeferredWriter.
+// The bytes are not compressed again and, since detecting the type
+// of compressed data is not useful, the negotiated Content-Type is used.
+// Error responses are never compressed, so Content-Encoding is removed
+// when an error is sent.
+func RawBytesPassthrough(b bool) ResponseEncoderFuncArg {
+	return func(o *encoderOptions) {
+		o.rawBytesPassthrough = b
+	}
+}
+
+// WithEnvelope wraps successful responses in an object so that
+// the response is encoded as {"key": response}.  Use WithMeta in a handler
+// to add a "meta" member next to the response.  Error responses are
+// not wrapped.  Use WithErrorModel to control their shape.  Streamed
+// responses (see StreamJSONArrays) are not wrapped either.
+//
+//	nvelope.MakeResponseEncoder("JSON",
+//		nvelope.WithEncoder("application/json", json.Marshal),
+//		nvelope.WithEnvelope("data"),
+//	)
+func WithEnvelope(key string) ResponseEncoderFuncArg {
+	return func(o *encoderOptions) {
+		o.envelope = envelopeType(key)
+	}
+}
+
+// envelopeType builds a struct type with a Data field named key
+// and an optional Meta field
+func envelopeType(key string) reflect.Type {
+	return reflect.StructOf([]reflect.StructField{
+		{
+			Name: "Data",
+			Type: reflect.TypeOf((*interface{})(nil)).Elem(),
+			Tag:  reflect.StructTag(`json:"` + key + `" xml:"` + key + `" yaml:"` + key + `"`),
+		},
+		{
+			Name: "Meta",
+			Type: reflect.TypeOf((*interface{})(nil)).Elem(),
+			Tag:  `json:"meta,omitempty" xml:"meta,omitempty" yaml:"meta,omitempty"`,
+		},
+	})
+}
+
+// envelop wraps model in an envelope if one is needed
+func (o encoderOptions) envelop(model Response, meta interface{}, hasMeta bool) Response {
+	et := o.envelope
+	if et == nil {
+		if !hasMeta {
+			return model
+		}
+		et = defaultEnvelope
+	}
+	e := reflect.New(et).Elem()
+	if model != nil {
+		e.Field(0).Set(reflect.ValueOf(model))
+	}
+	if meta != nil {
+		e.Field(1).Set(reflect.ValueOf(meta))
+	}
+	return e.Interface()
+}
+
+var defaultEnvelope = envelopeType("data")
+
 // WithEncoderErrorTransform provides an encoder-specific function to
 // transform errors before
 // encoding them using the normal encoder.  The return values are the model
@@ -165,10 +368,67 @@ func MakeResponseEncoder(
 			r *http.Request,
 		) {
 			model, err := inner()
+			w.responseErr = err
 			if w.Done() {
 				return
 			}
+			if c, ok := model.(cookieResponse); ok {
+				model = c.model
+				if err == nil {
+					for _, cookie := range c.cookies {
+						http.SetCookie(w, cookie)
+					}
+				}
+			}
 			contentType := httputil.NegotiateContentType(r, o.contentOffers, o.defaultEncoder)
+			if err == nil {
+				special, ok, specialErr := getSpecialResponse(model)
+				if specialErr != nil {
+					err = specialErr
+				} else if ok {
+					enforcerType := contentType
+					if as, ok := model.(asResponse); ok {
+						if _, ok := o.encoders[as.contentType]; ok {
+							enforcerType = as.contentType
+						}
+					}
+					err = o.encoders[enforcerType].apiEnforcer(special.status, special.body, special.withHeader(w.Header()), r)
+					if err == nil {
+						if err := special.write(w); err != nil {
+							logWriteError(log, "Cannot write response", err, map[string]interface{}{
+								"method": r.Method,
+								"uri":    r.URL.String(),
+							})
+						}
+						return
+					}
+				}
+			}
+			meta, hasMeta := model.(metaResponse)
+			if hasMeta {
+				model = meta.model
+			}
+			if as, ok := model.(asResponse); ok {
+				model = as.model
+				if _, ok := o.encoders[as.contentType]; ok {
+					contentType = as.contentType
+				} else if err == nil {
+					err = errors.Errorf("no response encoder for %s", as.contentType)
+				}
+			}
+			if err == nil && o.streamJSONArrays {
+				if each, elemType, ok := streamElements(model); ok {
+					if isJSONContentType(contentType) {
+						streamJSONArray(w, r, log, contentType, each)
+						return
+					}
+					model = collectElements(each, elemType)
+				}
+			}
+			if m, ok := model.(metaResponse); ok && !hasMeta {
+				meta, hasMeta = m, true
+				model = m.model
+			}
 			encoder := o.encoders[contentType]
 			w.Header().Set("Content-Type", contentType)
 			var code int
@@ -177,7 +437,26 @@ func MakeResponseEncoder(
 			// handleError will always set enc
 			var handleError func(recurseOkay bool)
 			handleError = func(recurseOkay bool) {
+				w.responseErr = err
+				if o.resetOnError {
+					resetForError(w, o.keepOnError)
+				}
+				// the error is encoded with the negotiated content type
+				// even if RawBytesPassthrough already set another
+				w.Header().Set("Content-Type", contentType)
+				// error bodies are generated here and are never
+				// compressed, even if the handler set Content-Encoding
+				w.Header().Del("Content-Encoding")
 				code = GetReturnCode(err)
+				setErrorHeaders(w.Header(), err)
+				var localized string
+				if o.errorLocalizer != nil {
+					var localizedCode int
+					localizedCode, localized = o.errorLocalizer(err, GetPreferredLanguage(r))
+					if localized != "" && localizedCode != 0 {
+						code = localizedCode
+					}
+				}
 				et := encoder.errorTransformer
 				if et == nil {
 					et = o.errorTransformer
@@ -193,7 +472,30 @@ func MakeResponseEncoder(
 				} else {
 					log.Error("returning server error", logDetails)
 				}
-				if rm, ok := et(err); ok {
+				// replacement errors are encoded like any other
+				// so that WithErrorModel still applies
+				if localized != "" {
+					err = ReturnCode(errors.New(localized), code)
+				} else if o.suppressErrorBody[code] {
+					msg := http.StatusText(code)
+					if msg == "" {
+						msg = "error"
+					}
+					err = ReturnCode(errors.New(msg), code)
+				}
+				var ve ValidationErrors
+				var eb ErrorBody
+				if errors.As(err, &eb) {
+					enc, err = encoder.encode(eb.ErrorBody())
+					if err != nil {
+						err = errors.Wrapf(err, "encode %s error body", contentType)
+						if recurseOkay {
+							handleError(false)
+						} else {
+							enc = []byte(err.Error())
+						}
+					}
+				} else if rm, ok := et(err); ok {
 					enc, err = encoder.encode(rm)
 					if err != nil {
 						err = errors.Wrapf(err, "encode %s response", contentType)
@@ -203,8 +505,13 @@ func MakeResponseEncoder(
 							enc = []byte(err.Error())
 						}
 					}
+				} else if errors.As(err, &ve) && isJSONContentType(contentType) {
+					enc, err = encoder.encode(ve)
+					if err != nil {
+						enc = []byte(ClientMessage(ve))
+					}
 				} else {
-					enc = []byte(err.Error())
+					enc = []byte(ClientMessage(err))
 				}
 			}
 			if err != nil {
@@ -212,9 +519,16 @@ func MakeResponseEncoder(
 			}
 
 			if len(enc) == 0 {
-				enc, err = encoder.encode(model)
-				if err != nil {
-					handleError(true)
+				if b, ok := model.([]byte); ok && o.rawBytesPassthrough {
+					if w.Header().Get("Content-Encoding") == "" {
+						w.Header().Set("Content-Type", http.DetectContentType(b))
+					}
+					enc = b
+				} else {
+					enc, err = encoder.encode(o.envelop(model, meta.meta, hasMeta))
+					if err != nil {
+						handleError(true)
+					}
 				}
 			}
 
@@ -232,19 +546,18 @@ func MakeResponseEncoder(
 				err = e2
 			}
 			if err != nil {
-				log.Warn("Cannot write response",
-					map[string]interface{}{
-						"error":  err.Error(),
-						"method": r.Method,
-						"uri":    r.URL.String(),
-					})
+				logWriteError(log, "Cannot write response", err, map[string]interface{}{
+					"method": r.Method,
+					"uri":    r.URL.String(),
+				})
 			}
 		})
 }
 
 // Nil204 is a wrapper that causes looks for return values of Response and error
 // and if both are nil, writes a 204 header and no data.  It is mean to be used
-// downstream from a response encocder.
+// downstream from a response encocder.  To send a 204 for a non-nil
+// response, return NoContent instead.
 var Nil204 = nject.Desired(nject.Provide("nil-204", nil204))
 
 func nil204(inner func() (Response, error), w *DeferredWriter) {
@@ -0,0 +1,405 @@
+package nvelope_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/muir/nape"
+	"github.com/muir/nject"
+	"github.com/muir/nvelope"
+	"github.com/muir/nvelope/nvelopetest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSuppressErrorBodyFor(t *testing.T) {
+	logger := &testLogger{}
+	do := captureOutputChain("/x/{code}",
+		logger.provider(),
+		nvelope.InjectWriter,
+		nvelope.MakeResponseEncoder("JSON",
+			nvelope.WithEncoder("application/json", json.Marshal),
+			nvelope.SuppressErrorBodyFor(401, 500),
+		),
+		nvelope.ReadBody,
+		nape.DecodeJSON,
+		func(r struct {
+			Code int `nvelope:"path,name=code"`
+		},
+		) (nvelope.Response, error) {
+			return nil, nvelope.ReturnCode(fmt.Errorf("secret details"), r.Code)
+		},
+	)
+	assert.Equal(t, "401->Unauthorized", do("/x/401"))
+	assert.Contains(t, logger.logged[len(logger.logged)-1], "error=secret details")
+	assert.Equal(t, "500->Internal Server Error", do("/x/500"))
+	assert.Contains(t, logger.logged[len(logger.logged)-1], "error=secret details")
+	assert.Equal(t, "403->secret details", do("/x/403"))
+}
+
+func TestRawBytesPassthrough(t *testing.T) {
+	png := []byte("\x89PNG\x0D\x0A\x1A\x0A\x00\x00\x00\x0DIHDR")
+	do := captureResponseChain("/x",
+		nvelope.NoLogger,
+		nvelope.InjectWriter,
+		nvelope.MakeResponseEncoder("JSON",
+			nvelope.WithEncoder("application/json", json.Marshal),
+			nvelope.RawBytesPassthrough(true),
+		),
+		func() (nvelope.Response, error) {
+			return png, nil
+		},
+	)
+	res, err := do("/x")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "image/png", res.Header.Get("Content-Type"))
+	assert.Equal(t, png, b)
+
+	do = captureResponseChain("/x",
+		nvelope.NoLogger,
+		nvelope.InjectWriter,
+		nvelope.MakeResponseEncoder("JSON",
+			nvelope.WithEncoder("application/json", json.Marshal,
+				nvelope.WithAPIEnforcer(func(code int, enc []byte, header http.Header, r *http.Request) error {
+					if header.Get("Content-Type") == "image/png" {
+						return errors.New("images are not in the API")
+					}
+					return nil
+				}),
+			),
+			nvelope.RawBytesPassthrough(true),
+		),
+		func() (nvelope.Response, error) {
+			return png, nil
+		},
+	)
+	res, err = do("/x")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	b, err = io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, 500, res.StatusCode, "rejected by the enforcer")
+	assert.Equal(t, "application/json", res.Header.Get("Content-Type"), "error is not sent as an image")
+	assert.Contains(t, string(b), "images are not in the API")
+
+	assert.Equal(t, `200->"AQI="`, captureOutputChain("/x",
+		nvelope.NoLogger,
+		nvelope.InjectWriter,
+		nvelope.EncodeJSON,
+		func() (nvelope.Response, error) {
+			return []byte{1, 2}, nil
+		},
+	)("/x"), "without passthrough")
+}
+
+func TestPrecompressedResponse(t *testing.T) {
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	_, err := zw.Write([]byte(`{"big":"response"}`))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	h, err := nvelopetest.Handler(
+		nvelope.NoLogger,
+		nvelope.InjectWriter,
+		nvelope.MakeResponseEncoder("JSON",
+			nvelope.WithEncoder("application/json", json.Marshal),
+			nvelope.RawBytesPassthrough(true),
+		),
+		func(w *nvelope.DeferredWriter, r *http.Request) (nvelope.Response, error) {
+			w.Header().Set("Content-Encoding", "gzip")
+			switch r.URL.Query().Get("kind") {
+			case "stored":
+				return nvelope.StoredResponse{
+					Header: http.Header{"Content-Type": {"application/json"}},
+					Body:   gz.Bytes(),
+				}, nil
+			case "error":
+				return gz.Bytes(), errors.New("oops")
+			}
+			return gz.Bytes(), nil
+		},
+	)
+	require.NoError(t, err)
+
+	for _, target := range []string{"/x", "/x?kind=stored"} {
+		res := nvelopetest.Do(h, "GET", target, nvelopetest.Header("Accept-Encoding", "gzip"))
+		assert.Equal(t, 200, res.Status, target)
+		assert.Equal(t, "gzip", res.Header.Get("Content-Encoding"), target)
+		assert.Equal(t, "application/json", res.Header.Get("Content-Type"), target)
+		assert.Equal(t, gz.String(), res.Body, "%s: not compressed again", target)
+		zr, err := gzip.NewReader(strings.NewReader(res.Body))
+		require.NoError(t, err, target)
+		b, err := io.ReadAll(zr)
+		require.NoError(t, err, target)
+		assert.Equal(t, `{"big":"response"}`, string(b), target)
+	}
+
+	res := nvelopetest.Do(h, "GET", "/x?kind=error", nvelopetest.Header("Accept-Encoding", "gzip"))
+	assert.Equal(t, 500, res.Status)
+	assert.Empty(t, res.Header.Get("Content-Encoding"), "error bodies are not compressed")
+	assert.Equal(t, "oops", res.Body)
+}
+
+type streamItem struct {
+	N int `json:"n" xml:"n"`
+}
+
+type badItem struct{}
+
+func (badItem) MarshalJSON() ([]byte, error) { return nil, fmt.Errorf("bad item") }
+
+func TestStreamJSONArrays(t *testing.T) {
+	logger := &testLogger{}
+	stream := func(f func(kind string) nvelope.Response) func(string, ...mod) string {
+		return captureOutputChain("/x/{kind}",
+			logger.provider(),
+			nvelope.InjectWriter,
+			nvelope.MakeResponseEncoder("JSON",
+				nvelope.WithEncoder("application/json", json.Marshal),
+				nvelope.WithEncoder("application/xml", xml.Marshal),
+				nvelope.StreamJSONArrays(true),
+			),
+			nvelope.ReadBody,
+			nape.DecodeJSON,
+			func(r struct {
+				Kind string `nvelope:"path,name=kind"`
+			},
+			) (nvelope.Response, error) {
+				return f(r.Kind), nil
+			},
+		)
+	}
+	do := stream(func(kind string) nvelope.Response {
+		switch kind {
+		case "chan":
+			c := make(chan streamItem, 3)
+			for i := 1; i <= 3; i++ {
+				c <- streamItem{N: i}
+			}
+			close(c)
+			return (<-chan streamItem)(c)
+		case "empty":
+			c := make(chan int)
+			close(c)
+			return c
+		case "iter":
+			return func(yield func(streamItem) bool) {
+				for i := 1; i <= 3; i++ {
+					if !yield(streamItem{N: i}) {
+						return
+					}
+				}
+			}
+		case "bad":
+			return func(yield func(interface{}) bool) {
+				_ = yield(streamItem{N: 1}) && yield(badItem{}) && yield(streamItem{N: 3})
+			}
+		case "xml":
+			c := make(chan streamItem, 2)
+			c <- streamItem{N: 1}
+			c <- streamItem{N: 2}
+			close(c)
+			return nvelope.As("application/xml", c)
+		}
+		return nil
+	})
+
+	var decoded []streamItem
+	out := do("/x/chan")
+	require.Equal(t, "200->", out[:5])
+	require.NoError(t, json.Unmarshal([]byte(out[5:]), &decoded))
+	assert.Equal(t, []streamItem{{N: 1}, {N: 2}, {N: 3}}, decoded)
+
+	out = do("/x/iter")
+	require.Equal(t, "200->", out[:5])
+	decoded = nil
+	require.NoError(t, json.Unmarshal([]byte(out[5:]), &decoded))
+	assert.Equal(t, []streamItem{{N: 1}, {N: 2}, {N: 3}}, decoded)
+
+	assert.Equal(t, "200->[]", do("/x/empty"))
+
+	out = do("/x/bad")
+	assert.Equal(t, "200->[{\"n\":1}\n,", out, "stream is cut off")
+	require.NotEmpty(t, logger.logged)
+	assert.Contains(t, logger.logged[len(logger.logged)-1], "bad item")
+
+	assert.Equal(t, "200-><streamItem><n>1</n></streamItem><streamItem><n>2</n></streamItem>", do("/x/xml"))
+}
+
+func TestWithEnvelope(t *testing.T) {
+	type page struct {
+		Total int `json:"total"`
+	}
+	do := captureOutputChain("/x/{kind}",
+		nvelope.NoLogger,
+		nvelope.InjectWriter,
+		nvelope.MakeResponseEncoder("JSON",
+			nvelope.WithEncoder("application/json", json.Marshal),
+			nvelope.WithEnvelope("result"),
+		),
+		nvelope.ReadBody,
+		nape.DecodeJSON,
+		func(r struct {
+			Kind string `nvelope:"path,name=kind"`
+		},
+		) (nvelope.Response, error) {
+			switch r.Kind {
+			case "meta":
+				return nvelope.WithMeta([]int{1, 2}, page{Total: 10}), nil
+			case "error":
+				return nil, nvelope.ReturnCode(fmt.Errorf("nope"), 404)
+			}
+			return map[string]int{"a": 1}, nil
+		},
+	)
+	assert.Equal(t, `200->{"result":{"a":1}}`, do("/x/plain"))
+	assert.Equal(t, `200->{"result":[1,2],"meta":{"total":10}}`, do("/x/meta"))
+	assert.Equal(t, `404->nope`, do("/x/error"))
+
+	assert.Equal(t, `200->{"data":"x","meta":3}`, captureOutputChain("/x",
+		nvelope.NoLogger,
+		nvelope.InjectWriter,
+		nvelope.EncodeJSON,
+		func() (nvelope.Response, error) {
+			return nvelope.WithMeta("x", 3), nil
+		},
+	)("/x"), "meta without WithEnvelope")
+}
+
+func TestResetOnError(t *testing.T) {
+	do := captureResponseChain("/x/{fail}",
+		nvelope.NoLogger,
+		nvelope.InjectWriter,
+		nject.Required(func(w *nvelope.DeferredWriter) {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			w.Header().Set("X-Request-Id", "r1")
+			w.PreserveHeader()
+			w.Header().Set("Access-Control-Allow-Origin", "example.com")
+		}),
+		nvelope.MakeResponseEncoder("JSON",
+			nvelope.WithEncoder("application/json", json.Marshal),
+			nvelope.ResetOnError("access-control-allow-origin"),
+		),
+		nvelope.ReadBody,
+		nape.DecodeJSON,
+		func(w *nvelope.DeferredWriter, r struct {
+			Fail bool `nvelope:"path,name=fail"`
+		},
+		) (nvelope.Response, error) {
+			w.Header().Set("X-Partial", "yes")
+			_, _ = w.Write([]byte("partial "))
+			if r.Fail {
+				return nil, fmt.Errorf("boom")
+			}
+			return "ok", nil
+		},
+	)
+	check := func(path string, status int, body string, partial string) {
+		res, err := do(path)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		b, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		assert.Equal(t, status, res.StatusCode, path)
+		assert.Equal(t, body, string(b), path)
+		assert.Equal(t, "example.com", res.Header.Get("Access-Control-Allow-Origin"), path)
+		assert.Equal(t, "r1", res.Header.Get("X-Request-Id"), path)
+		assert.Equal(t, partial, res.Header.Get("X-Partial"), path)
+	}
+	check("/x/false", 200, `partial "ok"`, "yes")
+	check("/x/true", 500, `boom`, "")
+}
+
+func TestAfterResponse(t *testing.T) {
+	type call struct {
+		status int
+		size   int
+		err    error
+	}
+	var calls []call
+	h, err := nvelopetest.Handler(
+		nvelope.NoLogger,
+		nvelope.InjectWriter,
+		nvelope.AfterResponse(func(status int, size int, err error) {
+			calls = append(calls, call{status: status, size: size, err: err})
+		}),
+		nvelope.EncodeJSON,
+		nvelope.CatchPanic,
+		nvelope.Nil204,
+		func(r *http.Request) (nvelope.Response, error) {
+			switch r.URL.Path {
+			case "/conflict":
+				return nil, nvelope.ReturnCode(errors.New("conflict"), http.StatusConflict)
+			case "/panic":
+				panic("boom")
+			case "/empty":
+				return nil, nil
+			}
+			return map[string]int{"a": 1}, nil
+		},
+	)
+	require.NoError(t, err)
+	do := func(path string) call {
+		calls = nil
+		res := nvelopetest.Do(h, "GET", path)
+		require.Len(t, calls, 1, path)
+		assert.Equal(t, res.Status, calls[0].status, path)
+		assert.Equal(t, len(res.Body), calls[0].size, path)
+		return calls[0]
+	}
+
+	c := do("/ok")
+	assert.Equal(t, call{status: 200, size: len(`{"a":1}`)}, c)
+
+	c = do("/conflict")
+	assert.Equal(t, 409, c.status)
+	assert.Equal(t, len("conflict"), c.size)
+	if assert.Error(t, c.err) {
+		assert.Equal(t, "conflict", c.err.Error())
+	}
+
+	c = do("/panic")
+	assert.Equal(t, 500, c.status, "panics caught by CatchPanic are reported")
+	if assert.Error(t, c.err) {
+		assert.Contains(t, c.err.Error(), "panic: boom")
+		assert.Equal(t, "boom", nvelope.RecoverInterface(c.err))
+	}
+
+	c = do("/empty")
+	assert.Equal(t, call{status: 204}, c)
+}
+
+func TestAfterResponseFlushError(t *testing.T) {
+	tw := &testResponseWriter{
+		header:             make(http.Header),
+		simulateWriteError: errors.New("broken pipe"),
+	}
+	var got error
+	nject.MustRun("test",
+		func() http.ResponseWriter { return tw },
+		nvelope.InjectWriter,
+		nvelope.AfterResponse(func(status int, size int, err error) {
+			got = err
+		}),
+		func(w http.ResponseWriter) {
+			_, _ = w.Write([]byte("hi"))
+		},
+	)
+	if assert.Error(t, got, "binds without a BasicLogger and reports the flush error") {
+		assert.Contains(t, got.Error(), "broken pipe")
+	}
+}
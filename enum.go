@@ -0,0 +1,63 @@
+package nvelope
+
+import (
+	"reflect"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	enumsLock sync.RWMutex
+	enums     = map[reflect.Type]func(string) (interface{}, error){}
+)
+
+// RegisterEnum adds a parser for decoding parameters into a type that
+// can't decode itself, like a generated enum with String() and
+// ParseColor(string) but no UnmarshalText.  The parser is used for the
+// type everywhere that a parameter is decoded from a string, including
+// pointers to it and slices and maps of it.  It takes precedence over
+// encoding.TextUnmarshaler and flag.Value.  The value returned by
+// parse must be assignable or convertible to the registered type.
+// Registering a type again replaces its parser.  Enums must be
+// registered before the decoders that use them are generated.
+//
+//	nvelope.RegisterEnum(reflect.TypeOf(Color(0)), func(s string) (interface{}, error) {
+//		return ParseColor(s)
+//	})
+//
+// Range constraints like minimum= are not applied to registered types.
+func RegisterEnum(t reflect.Type, parse func(string) (interface{}, error)) {
+	enumsLock.Lock()
+	defer enumsLock.Unlock()
+	enums[t] = parse
+}
+
+func lookupEnum(t reflect.Type) (func(string) (interface{}, error), bool) {
+	enumsLock.RLock()
+	defer enumsLock.RUnlock()
+	parse, ok := enums[t]
+	return parse, ok
+}
+
+// enumUnpacker sets the target using a parser from RegisterEnum
+func enumUnpacker(fieldType reflect.Type, name string, parse func(string) (interface{}, error)) unpack {
+	return unpack{single: func(from string, target reflect.Value, value string) error {
+		parsed, err := parse(value)
+		if err != nil {
+			return wrapDecodeError(err, from, name)
+		}
+		v := reflect.ValueOf(parsed)
+		switch {
+		case !v.IsValid():
+			return wrapDecodeError(errors.Errorf("parser for %s returned nil", fieldType), from, name)
+		case v.Type().AssignableTo(fieldType):
+			target.Set(v)
+		case v.Type().ConvertibleTo(fieldType):
+			target.Set(v.Convert(fieldType))
+		default:
+			return wrapDecodeError(errors.Errorf("parser for %s returned a %s", fieldType, v.Type()), from, name)
+		}
+		return nil
+	}}
+}
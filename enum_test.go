@@ -0,0 +1,84 @@
+package nvelope_test
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/muir/nvelope"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// color is like a generated enum: it has String() and parseColor()
+// but it does not implement encoding.TextUnmarshaler
+type color int
+
+const (
+	red color = iota + 1
+	green
+	blue
+)
+
+var colorNames = map[color]string{red: "red", green: "green", blue: "blue"}
+
+func (c color) String() string { return colorNames[c] }
+
+func parseColor(s string) (color, error) {
+	for c, name := range colorNames {
+		if strings.EqualFold(name, s) {
+			return c, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown color %q", s)
+}
+
+type badEnum int
+
+func init() {
+	nvelope.RegisterEnum(reflect.TypeOf(color(0)), func(s string) (interface{}, error) {
+		return parseColor(s)
+	})
+	nvelope.RegisterEnum(reflect.TypeOf(badEnum(0)), func(s string) (interface{}, error) {
+		return s, nil
+	})
+}
+
+func TestRegisterEnum(t *testing.T) {
+	type model struct {
+		Color   color            `nvelope:"query,name=color"`
+		Ptr     *color           `nvelope:"query,name=ptr"`
+		Colors  []color          `nvelope:"query,name=colors,explode=false"`
+		ByName  map[string]color `nvelope:"query,name=byName,deepObject=true"`
+		Header  color            `nvelope:"header,name=X-Color"`
+		Default color            `nvelope:"query,name=default,default=green"`
+	}
+	r := httptest.NewRequest("GET", "/?color=Blue&ptr=red&colors=red,green&byName[sky]=blue", nil)
+	r.Header.Set("X-Color", "green")
+	var m model
+	require.NoError(t, nvelope.DecodeRequest(r, &m))
+	ptr := red
+	assert.Equal(t, model{
+		Color:   blue,
+		Ptr:     &ptr,
+		Colors:  []color{red, green},
+		ByName:  map[string]color{"sky": blue},
+		Header:  green,
+		Default: green,
+	}, m)
+
+	err := nvelope.DecodeRequest(httptest.NewRequest("GET", "/?colors=red,mauve", nil), &m)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `unknown color "mauve"`)
+	assert.Equal(t, 400, nvelope.GetReturnCode(err))
+
+	var bad struct {
+		Bad badEnum `nvelope:"query,name=bad"`
+	}
+	err = nvelope.DecodeRequest(httptest.NewRequest("GET", "/?bad=x", nil), &bad)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "parser for nvelope_test.badEnum returned a string")
+}
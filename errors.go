@@ -2,8 +2,11 @@ package nvelope
 
 import (
 	"encoding"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/muir/nject"
 )
@@ -23,8 +26,9 @@ func minimalErrorHandler(inner func() error, w http.ResponseWriter) {
 	if err == nil {
 		return
 	}
+	setErrorHeaders(w.Header(), err)
 	w.WriteHeader(GetReturnCode(err))
-	_, _ = w.Write([]byte(err.Error()))
+	_, _ = w.Write([]byte(ClientMessage(err)))
 }
 
 // ReturnCode associates an HTTP return code with a error.
@@ -85,9 +89,346 @@ func GetReturnCode(err error) int {
 	return 500
 }
 
+// WithClientMessage annotates an error with a message that should be
+// sent to the client instead of the error's own text.  The error's own
+// text is still used for logging.  If err is nil, then nil is returned.
+func WithClientMessage(err error, msg string) error {
+	if err == nil {
+		return nil
+	}
+	return clientMessage{
+		cause: err,
+		msg:   msg,
+	}
+}
+
+type clientMessage struct {
+	cause error
+	msg   string
+}
+
+func (err clientMessage) Unwrap() error {
+	return err.cause
+}
+
+func (err clientMessage) Cause() error {
+	return err.cause
+}
+
+func (err clientMessage) Error() string {
+	return err.cause.Error()
+}
+
+// ClientMessage returns the message that should be sent to the
+// client for an error: the message from WithClientMessage if there
+// is one, otherwise err.Error().
+func ClientMessage(err error) string {
+	var ve ValidationErrors
+	if errors.As(err, &ve) {
+		return ve.clientMessage()
+	}
+	var cm clientMessage
+	if errors.As(err, &cm) {
+		return cm.msg
+	}
+	return err.Error()
+}
+
+// DecodeError describes a decoded value that does not satisfy a
+// constraint from its nvelope tag, like "minimum=1".  Decoders return it
+// wrapped with the name of the parameter; use errors.As to retrieve it.
+type DecodeError struct {
+	// Constraint is the name of the violated tag option, eg "minimum"
+	Constraint string
+	// Bound is the value of the tag option, eg 1.0 for "minimum=1"
+	Bound interface{}
+	// Value is the decoded value that was rejected
+	Value interface{}
+}
+
+var constraintDescriptions = map[string]string{
+	"minimum":      "at least",
+	"maximum":      "at most",
+	"exclusiveMin": "greater than",
+	"exclusiveMax": "less than",
+	"multipleOf":   "a multiple of",
+	"format":       "a valid",
+	"minLength":    "of length at least",
+	"maxLength":    "of length at most",
+}
+
+func (err *DecodeError) Error() string {
+	description, ok := constraintDescriptions[err.Constraint]
+	if !ok {
+		description = err.Constraint
+	}
+	return fmt.Sprintf("%v must be %s %v", err.Value, description, err.Bound)
+}
+
+// ErrorHeader annotates an error with an HTTP header that should
+// be sent with the error response.  For example:
+//
+//	return nvelope.Unauthorized(nvelope.ErrorHeader(err, "WWW-Authenticate", "Basic"))
+//
+// If err is nil, then nil is returned.
+func ErrorHeader(err error, key, value string) error {
+	if err == nil {
+		return nil
+	}
+	return errorHeader{
+		cause: err,
+		key:   http.CanonicalHeaderKey(key),
+		value: value,
+	}
+}
+
+type errorHeader struct {
+	cause error
+	key   string
+	value string
+}
+
+func (err errorHeader) Unwrap() error {
+	return err.cause
+}
+
+func (err errorHeader) Cause() error {
+	return err.cause
+}
+
+func (err errorHeader) Error() string {
+	return err.cause.Error()
+}
+
+// GetErrorHeaders returns the headers that have been added to
+// an error with ErrorHeader.  It returns nil if there are none.
+func GetErrorHeaders(err error) http.Header {
+	var header http.Header
+	for {
+		var eh errorHeader
+		if !errors.As(err, &eh) {
+			return header
+		}
+		if header == nil {
+			header = make(http.Header)
+		}
+		header.Add(eh.key, eh.value)
+		err = eh.cause
+	}
+}
+
+func setErrorHeaders(header http.Header, err error) {
+	for key, values := range GetErrorHeaders(err) {
+		header[key] = values
+	}
+}
+
 // CanModel represents errors that can transform themselves into a model
 // for logging.
 type CanModel interface {
 	error
 	Model() encoding.TextUnmarshaler
 }
+
+// ErrorBody is implemented by errors that carry their own response
+// body.  When a handler returns an error that is or wraps an ErrorBody,
+// MakeResponseEncoder encodes the ErrorBody's body with the negotiated
+// encoder instead of sending the error message.  The HTTP status still
+// comes from GetReturnCode so an ErrorBody without a ReturnCode is
+// sent as a 500.
+//
+// Precedence for the error response body: a message from WithErrorLocalizer,
+// then SuppressErrorBodyFor, then ErrorBody, then WithErrorModel (and
+// WithEncoderErrorTransform), and finally ClientMessage.  CanModel
+// is not consulted.
+type ErrorBody interface {
+	error
+	ErrorBody() interface{}
+}
+
+// WithErrorBody annotates an error with a body that will be encoded
+// as the response.  If err is nil, then nil is returned.
+//
+//	return nil, nvelope.ReturnCode(nvelope.WithErrorBody(err, Problem{
+//		Title: "out of stock",
+//		SKU:   sku,
+//	}), http.StatusConflict)
+func WithErrorBody(err error, body interface{}) error {
+	if err == nil {
+		return nil
+	}
+	return errorBody{
+		cause: err,
+		body:  body,
+	}
+}
+
+type errorBody struct {
+	cause error
+	body  interface{}
+}
+
+func (err errorBody) Unwrap() error {
+	return err.cause
+}
+
+func (err errorBody) Cause() error {
+	return err.cause
+}
+
+func (err errorBody) Error() string {
+	return err.cause.Error()
+}
+
+func (err errorBody) ErrorBody() interface{} {
+	return err.body
+}
+
+// WithErrorCode annotates an error with an application-level error
+// code, like "USER_NOT_FOUND", for clients that need something more
+// specific than the HTTP status.  It composes with ReturnCode.  If err
+// is nil, then nil is returned.
+//
+// When EncodeJSON (or any JSON encoder whose error transform looks for
+// json.Marshaler) encodes the error, the response body is
+//
+//	{"code": "USER_NOT_FOUND", "error": "message"}
+//
+// where the message comes from ClientMessage.  ErrorBody and
+// WithErrorLocalizer take precedence over this body.
+func WithErrorCode(err error, code string) error {
+	if err == nil {
+		return nil
+	}
+	return errorCode{
+		cause: err,
+		code:  code,
+	}
+}
+
+type errorCode struct {
+	cause error
+	code  string
+}
+
+func (err errorCode) Unwrap() error {
+	return err.cause
+}
+
+func (err errorCode) Cause() error {
+	return err.cause
+}
+
+func (err errorCode) Error() string {
+	return err.cause.Error()
+}
+
+// MarshalJSON encodes the error as {"code": code, "error": message}
+func (err errorCode) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]string{
+		"code":  err.code,
+		"error": ClientMessage(err.cause),
+	})
+}
+
+// GetErrorCode returns the application-level error code that has
+// been added to an error with WithErrorCode.  It returns "" if there
+// is none.
+func GetErrorCode(err error) string {
+	var ec errorCode
+	if errors.As(err, &ec) {
+		return ec.code
+	}
+	return ""
+}
+
+// FieldError is an error about a specific request parameter.  Field
+// is the name of the parameter (for example, the query parameter name),
+// not the name of the struct field.
+type FieldError struct {
+	Field string
+	Err   error
+}
+
+func (err *FieldError) Error() string {
+	return err.Err.Error()
+}
+
+func (err *FieldError) Unwrap() error {
+	return err.Err
+}
+
+// ValidationErrors holds multiple errors from decoding a request.  It
+// is returned (wrapped) by GenerateDecoder when CollectAllErrors is set.
+// When MakeResponseEncoder encodes a ValidationErrors with a JSON
+// encoder, the response body is
+//
+//	{"errors": {"field": ["message", ...], ...}}
+//
+// Errors that are not about a specific parameter are listed under
+// the field "request".
+type ValidationErrors []*FieldError
+
+func (errs ValidationErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Unwrap provides the individual errors
+func (errs ValidationErrors) Unwrap() []error {
+	unwrapped := make([]error, len(errs))
+	for i, err := range errs {
+		unwrapped[i] = err
+	}
+	return unwrapped
+}
+
+// FieldErrors maps field names to the messages for that field.  The
+// messages are the ones meant for clients: see ClientMessage.  For
+// errors from constraints like "minimum=", the message is just the
+// constraint that failed.
+func (errs ValidationErrors) FieldErrors() map[string][]string {
+	m := make(map[string][]string)
+	for _, err := range errs {
+		field := err.Field
+		if field == "" {
+			field = "request"
+		}
+		m[field] = append(m[field], fieldMessage(err.Err))
+	}
+	return m
+}
+
+// MarshalJSON encodes the errors as {"errors": FieldErrors()}
+func (errs ValidationErrors) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"errors": errs.FieldErrors(),
+	})
+}
+
+func (errs ValidationErrors) clientMessage() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		if err.Field == "" {
+			msgs[i] = fieldMessage(err.Err)
+		} else {
+			msgs[i] = err.Field + ": " + fieldMessage(err.Err)
+		}
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func fieldMessage(err error) string {
+	var cm clientMessage
+	if errors.As(err, &cm) {
+		return cm.msg
+	}
+	var de *DecodeError
+	if errors.As(err, &de) {
+		return de.Error()
+	}
+	return err.Error()
+}
@@ -1,9 +1,11 @@
 package nvelope_test
 
 import (
+	"encoding/json"
 	"fmt"
 	"testing"
 
+	"github.com/muir/nape"
 	"github.com/muir/nvelope"
 
 	"github.com/pkg/errors"
@@ -17,3 +19,104 @@ func TestErrors(t *testing.T) {
 	assert.Equal(t, 401, nvelope.GetReturnCode(nvelope.Unauthorized(fmt.Errorf("x"))), "unauth")
 	assert.Equal(t, 403, nvelope.GetReturnCode(nvelope.Forbidden(fmt.Errorf("x"))), "forbid")
 }
+
+func TestErrorHeader(t *testing.T) {
+	err := nvelope.ErrorHeader(nvelope.ErrorHeader(fmt.Errorf("x"), "www-authenticate", "Basic"), "X-Extra", "y")
+	err = nvelope.Unauthorized(errors.Wrap(err, "wrapped"))
+	assert.Equal(t, 401, nvelope.GetReturnCode(err))
+	assert.Equal(t, "wrapped: x", err.Error())
+	h := nvelope.GetErrorHeaders(err)
+	assert.Equal(t, "Basic", h.Get("WWW-Authenticate"))
+	assert.Equal(t, "y", h.Get("X-Extra"))
+	assert.Nil(t, nvelope.GetErrorHeaders(fmt.Errorf("x")))
+	assert.Nil(t, nvelope.ErrorHeader(nil, "a", "b"))
+}
+
+type conflict struct {
+	SKU string `json:"sku"`
+}
+
+func (c conflict) Error() string { return "out of stock: " + c.SKU }
+func (c conflict) ErrorBody() interface{} {
+	return map[string]string{"title": "out of stock", "sku": c.SKU}
+}
+
+func TestErrorBody(t *testing.T) {
+	do := captureOutputChain("/x/{kind}",
+		nvelope.NoLogger,
+		nvelope.InjectWriter,
+		nvelope.MakeResponseEncoder("JSON",
+			nvelope.WithEncoder("application/json", json.Marshal),
+			nvelope.WithErrorModel(func(err error) (interface{}, bool) {
+				return map[string]string{"model": err.Error()}, true
+			}),
+			nvelope.SuppressErrorBodyFor(503),
+		),
+		nvelope.ReadBody,
+		nape.DecodeJSON,
+		func(r struct {
+			Kind string `nvelope:"path,name=kind"`
+		},
+		) (nvelope.Response, error) {
+			switch r.Kind {
+			case "wrapped":
+				return nil, nvelope.ReturnCode(nvelope.WithErrorBody(fmt.Errorf("details"), []int{1, 2}), 409)
+			case "typed":
+				return nil, errors.Wrap(nvelope.ReturnCode(conflict{SKU: "a1"}, 409), "buy")
+			case "nocode":
+				return nil, nvelope.WithErrorBody(fmt.Errorf("details"), "body")
+			case "suppressed":
+				return nil, nvelope.ReturnCode(nvelope.WithErrorBody(fmt.Errorf("details"), "body"), 503)
+			case "unencodable":
+				return nil, nvelope.ReturnCode(nvelope.WithErrorBody(fmt.Errorf("details"), func() {}), 409)
+			}
+			return nil, nvelope.NotFound(fmt.Errorf("plain"))
+		},
+	)
+	assert.Equal(t, `409->[1,2]`, do("/x/wrapped"))
+	assert.Equal(t, `409->{"sku":"a1","title":"out of stock"}`, do("/x/typed"))
+	assert.Equal(t, `500->"body"`, do("/x/nocode"))
+	assert.Equal(t, `503->{"model":"Service Unavailable"}`, do("/x/suppressed"), "suppressed bodies are encoded")
+	assert.Regexp(t, `^500->{"model":"encode application/json error body: json: unsupported type`, do("/x/unencodable"))
+	assert.Equal(t, `404->{"model":"plain"}`, do("/x/other"))
+	assert.Nil(t, nvelope.WithErrorBody(nil, "x"))
+}
+
+func TestErrorCode(t *testing.T) {
+	do := captureOutputChain("/x/{kind}",
+		nvelope.NoLogger,
+		nvelope.InjectWriter,
+		nvelope.EncodeJSON,
+		nvelope.ReadBody,
+		nape.DecodeJSON,
+		func(r struct {
+			Kind string `nvelope:"path,name=kind"`
+		},
+		) (nvelope.Response, error) {
+			switch r.Kind {
+			case "notfound":
+				return nil, nvelope.NotFound(nvelope.WithErrorCode(fmt.Errorf("no user 7"), "USER_NOT_FOUND"))
+			case "outside":
+				return nil, errors.Wrap(nvelope.WithErrorCode(nvelope.ReturnCode(fmt.Errorf("taken"), 409), "NAME_TAKEN"), "create")
+			case "message":
+				return nil, nvelope.BadRequest(nvelope.WithErrorCode(
+					nvelope.WithClientMessage(fmt.Errorf("internal detail"), "bad name"), "BAD_NAME"))
+			case "body":
+				return nil, nvelope.ReturnCode(nvelope.WithErrorBody(nvelope.WithErrorCode(fmt.Errorf("x"), "X"), []int{1}), 409)
+			}
+			return nil, nvelope.NotFound(fmt.Errorf("plain"))
+		},
+	)
+	assert.Equal(t, `404->{"code":"USER_NOT_FOUND","error":"no user 7"}`, do("/x/notfound"))
+	assert.Equal(t, `409->{"code":"NAME_TAKEN","error":"taken"}`, do("/x/outside"), "ReturnCode can be inside or outside")
+	assert.Equal(t, `400->{"code":"BAD_NAME","error":"bad name"}`, do("/x/message"), "client message is used")
+	assert.Equal(t, `409->[1]`, do("/x/body"), "ErrorBody takes precedence")
+	assert.Equal(t, `404->plain`, do("/x/other"))
+
+	err := errors.Wrap(nvelope.BadRequest(nvelope.WithErrorCode(fmt.Errorf("x"), "CODE")), "wrapped")
+	assert.Equal(t, "CODE", nvelope.GetErrorCode(err))
+	assert.Equal(t, 400, nvelope.GetReturnCode(err))
+	assert.Equal(t, "wrapped: x", err.Error())
+	assert.Equal(t, "", nvelope.GetErrorCode(fmt.Errorf("x")))
+	assert.Nil(t, nvelope.WithErrorCode(nil, "CODE"))
+}
@@ -0,0 +1,9 @@
+package nvelope
+
+// ResetDecoderCache discards the model fillers cached by every
+// decoder so that tests can observe a fresh build.
+var ResetDecoderCache = resetFillerCache
+
+// SplitDeepObjectKey is exported for comparing it with the
+// regular expression it replaced
+var SplitDeepObjectKey = splitDeepObjectKey
@@ -0,0 +1,94 @@
+package nvelope
+
+import (
+	"net/mail"
+	"net/url"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	formatsLock sync.RWMutex
+	formats     = map[string]func(string) error{
+		"email":     validateEmail,
+		"uri":       validateURI,
+		"uuid":      validateUUID,
+		"date":      validateDate,
+		"date-time": validateDateTime,
+		"hostname":  validateHostname,
+	}
+)
+
+// RegisterFormat adds a validator for use with the "format=" tag
+// option.  The validator is called with the string value of the
+// parameter and should return an error if the value is not valid.
+// Registering a format that already exists replaces it.  Formats
+// must be registered before the decoders that use them are generated.
+//
+// The built-in formats are "email", "uri", "uuid", "date", "date-time",
+// and "hostname".
+func RegisterFormat(name string, validate func(string) error) {
+	formatsLock.Lock()
+	defer formatsLock.Unlock()
+	formats[name] = validate
+}
+
+func lookupFormat(name string) (func(string) error, bool) {
+	formatsLock.RLock()
+	defer formatsLock.RUnlock()
+	validate, ok := formats[name]
+	return validate, ok
+}
+
+func validateEmail(s string) error {
+	a, err := mail.ParseAddress(s)
+	if err != nil {
+		return err
+	}
+	if a.Address != s {
+		return errors.New("must be a bare address")
+	}
+	return nil
+}
+
+func validateURI(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if u.Scheme == "" {
+		return errors.New("missing scheme")
+	}
+	return nil
+}
+
+var uuidRE = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+func validateUUID(s string) error {
+	if !uuidRE.MatchString(s) {
+		return errors.New("not a UUID")
+	}
+	return nil
+}
+
+func validateDate(s string) error {
+	_, err := time.Parse("2006-01-02", s)
+	return err
+}
+
+func validateDateTime(s string) error {
+	_, err := time.Parse(time.RFC3339, s)
+	return err
+}
+
+var hostnameRE = regexp.MustCompile(`^(?i:[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?)(\.(?i:[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?))*$`)
+
+func validateHostname(s string) error {
+	if len(s) > 253 || !hostnameRE.MatchString(s) {
+		return errors.New("not a hostname")
+	}
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/http/cookiejar"
 	"net/http/httptest"
+	"sort"
 	"strings"
 
 	"github.com/muir/nape"
@@ -56,9 +57,44 @@ func header(k, v string) mod {
 }
 
 func captureOutputFunc(out func(...interface{}), path string, f interface{}) func(string, ...mod) {
-	router := mux.NewRouter()
-	service := nape.RegisterServiceWithMux("example", router)
-	service.RegisterEndpoint(path,
+	return captureOutputChainFunc(out, captureResponse(path, f))
+}
+
+// captureOutputChain is like captureOutput but the entire injection
+// chain is provided by the caller
+func captureOutputChain(path string, chain ...interface{}) func(string, ...mod) string {
+	var o string
+	do := captureOutputChainFunc(func(i ...interface{}) {
+		o += fmt.Sprint(i...)
+	}, captureResponseChain(path, chain...))
+	return func(url string, mods ...mod) string {
+		o = ""
+		do(url, mods...)
+		return o
+	}
+}
+
+func captureOutputChainFunc(out func(...interface{}), do func(string, ...mod) (*http.Response, error)) func(string, ...mod) {
+	return func(url string, mods ...mod) {
+		res, err := do(url, mods...)
+		if err != nil {
+			out("response error:", err)
+			return
+		}
+		b, err := io.ReadAll(res.Body)
+		if err != nil {
+			out("read error:", err)
+			return
+		}
+		res.Body.Close()
+		out(res.StatusCode, "->"+string(b))
+	}
+}
+
+// captureResponse is like captureOutput but returns the
+// *http.Response so that headers can be examined
+func captureResponse(path string, f interface{}) func(string, ...mod) (*http.Response, error) {
+	return captureResponseChain(path,
 		// order matters and this is a correct order
 		nvelope.NoLogger,
 		nvelope.InjectWriter,
@@ -68,16 +104,25 @@ func captureOutputFunc(out func(...interface{}), path string, f interface{}) fun
 		nvelope.ReadBody,
 		nape.DecodeJSON,
 		f,
-	).Methods("POST")
+	)
+}
+
+func captureResponseChain(path string, chain ...interface{}) func(string, ...mod) (*http.Response, error) {
+	router := mux.NewRouter()
+	service := nape.RegisterServiceWithMux("example", router)
+	service.RegisterEndpoint(path, chain...).Methods("POST")
 	ts := httptest.NewServer(router)
 
-	return func(url string, mods ...mod) {
+	return func(url string, mods ...mod) (*http.Response, error) {
 		client := ts.Client()
 		var err error
 		client.Jar, err = cookiejar.New(&cookiejar.Options{})
 		if err != nil {
 			panic("jar")
 		}
+		client.CheckRedirect = func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
 		// nolint:noctx
 		req, err := http.NewRequest("POST", ts.URL+url, io.NopCloser(strings.NewReader("")))
 		if err != nil {
@@ -88,17 +133,48 @@ func captureOutputFunc(out func(...interface{}), path string, f interface{}) fun
 		}
 
 		// nolint:noctx
-		res, err := client.Do(req)
-		if err != nil {
-			out("response error:", err)
-			return
+		return client.Do(req)
+	}
+}
+
+// testLogger is a BasicLogger that remembers what was logged
+type testLogger struct {
+	logged []string
+}
+
+var (
+	_ nvelope.BasicLogger = &testLogger{}
+	_ nvelope.InfoLogger  = &testLogger{}
+)
+
+func (l *testLogger) log(level string, msg string, fields ...map[string]interface{}) {
+	s := level + ": " + msg
+	for _, m := range fields {
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
 		}
-		b, err := io.ReadAll(res.Body)
-		if err != nil {
-			out("read error:", err)
-			return
+		sort.Strings(keys)
+		for _, k := range keys {
+			s += fmt.Sprintf(" %s=%v", k, m[k])
 		}
-		res.Body.Close()
-		out(res.StatusCode, "->"+string(b))
 	}
+	l.logged = append(l.logged, s)
+}
+
+func (l *testLogger) Debug(msg string, fields ...map[string]interface{}) {
+	l.log("debug", msg, fields...)
+}
+func (l *testLogger) Error(msg string, fields ...map[string]interface{}) {
+	l.log("error", msg, fields...)
+}
+func (l *testLogger) Warn(msg string, fields ...map[string]interface{}) {
+	l.log("warn", msg, fields...)
+}
+func (l *testLogger) Info(msg string, fields ...map[string]interface{}) {
+	l.log("info", msg, fields...)
+}
+
+func (l *testLogger) provider() func() nvelope.BasicLogger {
+	return func() nvelope.BasicLogger { return l }
 }
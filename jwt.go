@@ -0,0 +1,247 @@
+package nvelope
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"reflect"
+	"strings"
+	"time"
+
+	// register the hashes used by JWT signatures
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+
+	"github.com/pkg/errors"
+)
+
+// JWTKeyFunc returns the key to verify a JSON Web Token signed
+// with alg (eg "RS256") using the key identified by kid (which may
+// be empty).  The type of key depends on the algorithm:
+//
+//	HS256, HS384, HS512                        []byte
+//	RS256, RS384, RS512, PS256, PS384, PS512   *rsa.PublicKey
+//	ES256, ES384, ES512                        *ecdsa.PublicKey
+//	EdDSA                                      ed25519.PublicKey
+//
+// Return an error to reject tokens that use an unexpected algorithm
+// or key.
+type JWTKeyFunc func(alg string, kid string) (interface{}, error)
+
+// WithJWTKeyFunc provides the keys for verifying the signatures of
+// JSON Web Tokens decoded with the "jwt=true" tag option:
+//
+//	type Request struct {
+//		Claims MyClaims `nvelope:"header,name=Authorization,scheme=Bearer,jwt=true"`
+//	}
+//
+// The claims of a token whose signature verifies are decoded into the
+// field with json.Unmarshal.  Tokens that cannot be verified, and tokens
+// whose "exp" or "nbf" claims show that they are not currently valid,
+// are rejected with a 401.  Tokens with "alg":"none" are always rejected,
+// as are tokens whose header lists critical extensions ("crit").
+//
+// nvelope verifies tokens with the standard library only.  To use a
+// JWT library instead, register it with WithTagDecoder and use
+// content= rather than jwt=true.
+func WithJWTKeyFunc(keyFunc JWTKeyFunc) DecodeInputsGeneratorOpt {
+	return func(o *eigo) {
+		o.jwtKeyFunc = keyFunc
+	}
+}
+
+// TrustUnverifiedJWT true allows "jwt=true" fields to be decoded
+// without verifying the token's signature.  Only use it behind a
+// gateway that has already verified the token.  The "exp" and "nbf"
+// claims are still checked.  If WithJWTKeyFunc is also used, then
+// signatures are verified.
+func TrustUnverifiedJWT(b bool) DecodeInputsGeneratorOpt {
+	return func(o *eigo) {
+		o.trustUnverifiedJWT = b
+	}
+}
+
+type jwtHeader struct {
+	Alg  string   `json:"alg"`
+	Kid  string   `json:"kid"`
+	Crit []string `json:"crit"`
+}
+
+type jwtTimes struct {
+	Exp *float64 `json:"exp"`
+	Nbf *float64 `json:"nbf"`
+}
+
+// jwtUnpacker decodes the claims of a JSON Web Token into the target
+func jwtUnpacker(fieldType reflect.Type, fieldName string, base string, tags tags, options eigo) (unpack, error) {
+	if base != "header" && base != "cookie" {
+		return unpack{}, errors.Errorf("jwt=true is only supported for headers and cookies, field %s", fieldName)
+	}
+	if tags.Content != "" {
+		return unpack{}, errors.Errorf("jwt=true cannot be combined with content=, field %s", fieldName)
+	}
+	if options.jwtKeyFunc == nil && !options.trustUnverifiedJWT {
+		return unpack{}, errors.Errorf("jwt=true requires WithJWTKeyFunc or TrustUnverifiedJWT, field %s", fieldName)
+	}
+	fail := func(err error) error {
+		if tags.Scheme != "" {
+			return authFailure(tags.Scheme, err)
+		}
+		return Unauthorized(err)
+	}
+	return unpack{
+		createMe: true,
+		single: func(from string, target reflect.Value, value string) error {
+			claims, err := parseJWT(value, options.jwtKeyFunc, time.Now())
+			if err != nil {
+				return fail(err)
+			}
+			p := reflect.New(fieldType)
+			if err := json.Unmarshal(claims, p.Interface()); err != nil {
+				return fail(errors.Wrap(err, "invalid token claims"))
+			}
+			target.Set(p.Elem())
+			return nil
+		},
+	}, nil
+}
+
+// parseJWT checks a compact JSON Web Token and returns its claims.
+// The signature is only verified if keyFunc is not nil.
+func parseJWT(token string, keyFunc JWTKeyFunc, now time.Time) ([]byte, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("invalid token: must have three parts")
+	}
+	var segments [3][]byte
+	for i, part := range parts {
+		var err error
+		segments[i], err = base64.RawURLEncoding.DecodeString(part)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid token encoding")
+		}
+	}
+	var header jwtHeader
+	if err := json.Unmarshal(segments[0], &header); err != nil {
+		return nil, errors.Wrap(err, "invalid token header")
+	}
+	// RFC 7515 section 4.1.11: critical extensions that are not
+	// understood must be rejected and none are understood here
+	if header.Crit != nil {
+		return nil, errors.Errorf("token requires unsupported critical header extensions %v", header.Crit)
+	}
+	if keyFunc != nil {
+		if header.Alg == "" || strings.EqualFold(header.Alg, "none") {
+			return nil, errors.New("unsigned tokens are not accepted")
+		}
+		key, err := keyFunc(header.Alg, header.Kid)
+		if err != nil {
+			return nil, errors.Wrap(err, "no key for token")
+		}
+		err = verifyJWTSignature(header.Alg, key, parts[0]+"."+parts[1], segments[2])
+		if err != nil {
+			return nil, err
+		}
+	}
+	var times jwtTimes
+	if err := json.Unmarshal(segments[1], &times); err != nil {
+		return nil, errors.Wrap(err, "invalid token claims")
+	}
+	unix := float64(now.Unix())
+	if times.Exp != nil && unix >= *times.Exp {
+		return nil, errors.New("token is expired")
+	}
+	if times.Nbf != nil && unix < *times.Nbf {
+		return nil, errors.New("token is not valid yet")
+	}
+	return segments[1], nil
+}
+
+var jwtHashes = map[string]crypto.Hash{
+	"256": crypto.SHA256,
+	"384": crypto.SHA384,
+	"512": crypto.SHA512,
+}
+
+// jwtCurveBits are the curve sizes for ES256, ES384, and ES512
+var jwtCurveBits = map[string]int{
+	"256": 256,
+	"384": 384,
+	"512": 521,
+}
+
+func verifyJWTSignature(alg string, key interface{}, signed string, signature []byte) error {
+	invalid := errors.New("invalid token signature")
+	if alg == "EdDSA" {
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return errors.Errorf("key for %s must be ed25519.PublicKey, not %T", alg, key)
+		}
+		if !ed25519.Verify(pub, []byte(signed), signature) {
+			return invalid
+		}
+		return nil
+	}
+	if len(alg) != 5 {
+		return errors.Errorf("token algorithm %s is not supported", alg)
+	}
+	hash, ok := jwtHashes[alg[2:]]
+	if !ok {
+		return errors.Errorf("token algorithm %s is not supported", alg)
+	}
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+	switch alg[:2] {
+	case "HS":
+		secret, ok := key.([]byte)
+		if !ok {
+			return errors.Errorf("key for %s must be []byte, not %T", alg, key)
+		}
+		mac := hmac.New(hash.New, secret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return invalid
+		}
+	case "RS", "PS":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.Errorf("key for %s must be *rsa.PublicKey, not %T", alg, key)
+		}
+		var err error
+		if alg[0] == 'R' {
+			err = rsa.VerifyPKCS1v15(pub, hash, digest, signature)
+		} else {
+			err = rsa.VerifyPSS(pub, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+		if err != nil {
+			return invalid
+		}
+	case "ES":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.Errorf("key for %s must be *ecdsa.PublicKey, not %T", alg, key)
+		}
+		bits := pub.Curve.Params().BitSize
+		if bits != jwtCurveBits[alg[2:]] {
+			return errors.Errorf("key for %s must use a %d bit curve, not %d", alg, jwtCurveBits[alg[2:]], bits)
+		}
+		size := (bits + 7) / 8
+		if len(signature) != 2*size {
+			return invalid
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return invalid
+		}
+	default:
+		return errors.Errorf("token algorithm %s is not supported", alg)
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package nvelope_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/muir/nvelope"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type jwtClaims struct {
+	Subject string   `json:"sub"`
+	Roles   []string `json:"roles"`
+	Expires int64    `json:"exp,omitempty"`
+}
+
+// signJWT builds a compact JSON Web Token
+func signJWT(t *testing.T, alg string, kid string, key interface{}, claims interface{}) string {
+	return signJWTWithHeader(t, map[string]interface{}{"alg": alg, "typ": "JWT", "kid": kid}, key, claims)
+}
+
+// signJWTWithHeader builds a compact JSON Web Token with any header
+func signJWTWithHeader(t *testing.T, header map[string]interface{}, key interface{}, claims interface{}) string {
+	enc := func(v interface{}) string {
+		b, err := json.Marshal(v)
+		require.NoError(t, err)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	alg, _ := header["alg"].(string)
+	signed := enc(header) + "." + enc(claims)
+	digest := sha256.Sum256([]byte(signed))
+	var sig []byte
+	var err error
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		if alg == "PS256" {
+			sig, err = rsa.SignPSS(rand.Reader, k, crypto.SHA256, digest[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		} else {
+			sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		}
+	case *ecdsa.PrivateKey:
+		r, s, signErr := ecdsa.Sign(rand.Reader, k, digest[:])
+		err = signErr
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, []byte(signed))
+	case nil:
+	default:
+		t.Fatalf("unexpected key %T", key)
+	}
+	require.NoError(t, err)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestDecodeJWT(t *testing.T) {
+	secret := []byte("sekrit")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	keyFunc := func(alg string, kid string) (interface{}, error) {
+		switch kid {
+		case "hmac":
+			return secret, nil
+		case "rsa":
+			return &rsaKey.PublicKey, nil
+		case "ec":
+			return &ecKey.PublicKey, nil
+		case "ed":
+			return edPub, nil
+		}
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+
+	type model struct {
+		Claims jwtClaims               `nvelope:"header,name=Authorization,scheme=Bearer,jwt=true"`
+		Cookie *map[string]interface{} `nvelope:"cookie,name=session,jwt=true"`
+	}
+	decode := func(token string, cookie string, opts ...nvelope.DecodeInputsGeneratorOpt) (model, error) {
+		r := httptest.NewRequest("GET", "/", nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		if cookie != "" {
+			r.AddCookie(&http.Cookie{Name: "session", Value: cookie})
+		}
+		var m model
+		err := nvelope.DecodeRequest(r, &m, opts...)
+		return m, err
+	}
+	claims := jwtClaims{Subject: "u1", Roles: []string{"admin"}, Expires: time.Now().Add(time.Hour).Unix()}
+	verify := nvelope.WithJWTKeyFunc(keyFunc)
+
+	for _, tc := range []struct {
+		alg string
+		kid string
+		key interface{}
+	}{
+		{"HS256", "hmac", secret},
+		{"RS256", "rsa", rsaKey},
+		{"PS256", "rsa", rsaKey},
+		{"ES256", "ec", ecKey},
+		{"EdDSA", "ed", edKey},
+	} {
+		m, err := decode(signJWT(t, tc.alg, tc.kid, tc.key, claims), "", verify)
+		if assert.NoError(t, err, tc.alg) {
+			assert.Equal(t, claims, m.Claims, tc.alg)
+			assert.Nil(t, m.Cookie, tc.alg)
+		}
+	}
+
+	m, err := decode("", signJWT(t, "HS256", "hmac", secret, map[string]interface{}{"sub": "c"}), verify)
+	require.NoError(t, err)
+	require.NotNil(t, m.Cookie)
+	assert.Equal(t, map[string]interface{}{"sub": "c"}, *m.Cookie)
+
+	expired := claims
+	expired.Expires = time.Now().Add(-time.Minute).Unix()
+	early := map[string]interface{}{"sub": "u1", "nbf": time.Now().Add(time.Hour).Unix()}
+	good := signJWT(t, "HS256", "hmac", secret, claims)
+	for _, tc := range []struct {
+		name  string
+		token string
+		want  string
+	}{
+		{"expired", signJWT(t, "HS256", "hmac", secret, expired), "token is expired"},
+		{"not before", signJWT(t, "HS256", "hmac", secret, early), "token is not valid yet"},
+		{"wrong secret", signJWT(t, "HS256", "hmac", []byte("guess"), claims), "invalid token signature"},
+		{"wrong key type", signJWT(t, "RS256", "hmac", rsaKey, claims), "must be *rsa.PublicKey"},
+		{"unknown kid", signJWT(t, "HS256", "other", secret, claims), `unknown key "other"`},
+		{"unsigned", signJWT(t, "none", "hmac", nil, claims), "unsigned tokens are not accepted"},
+		{"tampered", good[:len(good)-4] + "AAAA", "invalid token signature"},
+		{"garbage", "not-a-token", "must have three parts"},
+		{"critical extension", signJWTWithHeader(t, map[string]interface{}{
+			"alg": "HS256", "kid": "hmac", "crit": []string{"exp"}, "exp": 1,
+		}, secret, claims), "unsupported critical header extensions [exp]"},
+		{"empty crit", signJWTWithHeader(t, map[string]interface{}{
+			"alg": "HS256", "kid": "hmac", "crit": []string{},
+		}, secret, claims), "unsupported critical header extensions"},
+	} {
+		_, err := decode(tc.token, "", verify)
+		if assert.Error(t, err, tc.name) {
+			assert.Contains(t, err.Error(), tc.want, tc.name)
+			assert.Equal(t, 401, nvelope.GetReturnCode(err), tc.name)
+			assert.Equal(t, "Bearer", nvelope.GetErrorHeaders(err).Get("WWW-Authenticate"), tc.name)
+		}
+	}
+
+	m, err = decode(signJWT(t, "HS256", "hmac", []byte("guess"), claims), "", nvelope.TrustUnverifiedJWT(true))
+	require.NoError(t, err, "trust mode does not check signatures")
+	assert.Equal(t, claims, m.Claims)
+	_, err = decode(signJWT(t, "HS256", "hmac", []byte("guess"), expired), "", nvelope.TrustUnverifiedJWT(true))
+	require.Error(t, err, "trust mode still checks expiration")
+	assert.Equal(t, 401, nvelope.GetReturnCode(err))
+	_, err = decode(signJWTWithHeader(t, map[string]interface{}{"alg": "HS256", "crit": []string{"b64"}, "b64": false},
+		secret, claims), "", nvelope.TrustUnverifiedJWT(true))
+	require.Error(t, err, "trust mode still rejects critical extensions")
+	assert.Equal(t, 401, nvelope.GetReturnCode(err))
+
+	_, err = decode(good, "")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "jwt=true requires WithJWTKeyFunc or TrustUnverifiedJWT")
+	var query struct {
+		Claims jwtClaims `nvelope:"query,name=token,jwt=true"`
+	}
+	err = nvelope.DecodeRequest(httptest.NewRequest("GET", "/", nil), &query, verify)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "jwt=true is only supported for headers and cookies")
+}
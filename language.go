@@ -0,0 +1,54 @@
+package nvelope
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/golang/gddo/httputil/header"
+	"github.com/muir/nject"
+)
+
+// PreferredLanguage is the list of languages from the request's
+// Accept-Language header, most preferred first.  Languages with
+// a quality of zero and the "*" wildcard are omitted.  It is
+// provided by InjectPreferredLanguage.
+type PreferredLanguage []string
+
+// InjectPreferredLanguage is a provider that parses the Accept-Language
+// header of the request and provides PreferredLanguage.
+var InjectPreferredLanguage = nject.Provide("preferred-language", GetPreferredLanguage)
+
+// GetPreferredLanguage parses the Accept-Language header of r
+func GetPreferredLanguage(r *http.Request) PreferredLanguage {
+	specs := header.ParseAccept(r.Header, "Accept-Language")
+	sort.SliceStable(specs, func(i, j int) bool {
+		return specs[i].Q > specs[j].Q
+	})
+	langs := make(PreferredLanguage, 0, len(specs))
+	for _, spec := range specs {
+		if spec.Q <= 0 || spec.Value == "*" {
+			continue
+		}
+		langs = append(langs, spec.Value)
+	}
+	return langs
+}
+
+// ErrorLocalizer translates an error into a message for the client
+// based on the client's preferred languages.  It returns the HTTP
+// status code and the message.  A code of zero means use the code
+// from GetReturnCode.  An empty message means the error was not
+// localized and it will be handled as if there was no localizer.
+type ErrorLocalizer func(err error, langs []string) (code int, message string)
+
+// WithErrorLocalizer provides a function that MakeResponseEncoder
+// uses to generate error bodies in the client's language.  If the
+// localizer provides a message, the error is replaced by one whose
+// Error() is that message and which is encoded like any other error:
+// WithErrorModel is given the replacement.  SuppressErrorBodyFor does
+// not apply to localized messages.
+func WithErrorLocalizer(localizer ErrorLocalizer) ResponseEncoderFuncArg {
+	return func(o *encoderOptions) {
+		o.errorLocalizer = localizer
+	}
+}
@@ -0,0 +1,103 @@
+package nvelope_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/muir/nvelope"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetPreferredLanguage(t *testing.T) {
+	cases := []struct {
+		header string
+		want   nvelope.PreferredLanguage
+	}{
+		{"", nvelope.PreferredLanguage{}},
+		{"fr", nvelope.PreferredLanguage{"fr"}},
+		{"da, en-gb;q=0.8, en;q=0.7", nvelope.PreferredLanguage{"da", "en-gb", "en"}},
+		{"en;q=0.5, de, fr;q=0.9", nvelope.PreferredLanguage{"de", "fr", "en"}},
+		{"en;q=0, es, *;q=0.1", nvelope.PreferredLanguage{"es"}},
+	}
+	for _, tc := range cases {
+		r := httptest.NewRequest("GET", "/", nil)
+		if tc.header != "" {
+			r.Header.Set("Accept-Language", tc.header)
+		}
+		assert.Equal(t, tc.want, nvelope.GetPreferredLanguage(r), tc.header)
+	}
+}
+
+var errNotFound = errors.New("not found")
+
+func TestWithErrorLocalizer(t *testing.T) {
+	messages := map[string]string{
+		"fr": "introuvable",
+		"de": "nicht gefunden",
+	}
+	do := captureOutputChain("/x",
+		nvelope.NoLogger,
+		nvelope.InjectWriter,
+		nvelope.MakeResponseEncoder("JSON",
+			nvelope.WithEncoder("application/json", json.Marshal),
+			nvelope.WithErrorLocalizer(func(err error, langs []string) (int, string) {
+				if !errors.Is(err, errNotFound) {
+					return 0, ""
+				}
+				for _, lang := range langs {
+					if msg, ok := messages[strings.Split(lang, "-")[0]]; ok {
+						return 404, msg
+					}
+				}
+				return 0, ""
+			}),
+		),
+		nvelope.InjectPreferredLanguage,
+		func(langs nvelope.PreferredLanguage) (nvelope.Response, error) {
+			if len(langs) == 1 && langs[0] == "ok" {
+				return "fine", nil
+			}
+			return nil, errNotFound
+		},
+	)
+	assert.Equal(t, `404->introuvable`, do("/x", header("Accept-Language", "fr-CA, de;q=0.5")))
+	assert.Equal(t, `404->nicht gefunden`, do("/x", header("Accept-Language", "es, de;q=0.5")))
+	assert.Equal(t, `500->not found`, do("/x", header("Accept-Language", "es")))
+	assert.Equal(t, `500->not found`, do("/x"))
+	assert.Equal(t, `200->"fine"`, do("/x", header("Accept-Language", "ok")))
+}
+
+func TestWithErrorLocalizerErrorModel(t *testing.T) {
+	do := captureResponseChain("/x",
+		nvelope.NoLogger,
+		nvelope.InjectWriter,
+		nvelope.MakeResponseEncoder("JSON",
+			nvelope.WithEncoder("application/json", json.Marshal),
+			nvelope.WithErrorModel(func(err error) (interface{}, bool) {
+				return map[string]interface{}{"message": err.Error(), "code": nvelope.GetReturnCode(err)}, true
+			}),
+			nvelope.WithErrorLocalizer(func(err error, langs []string) (int, string) {
+				return 404, "introuvable"
+			}),
+			nvelope.SuppressErrorBodyFor(404),
+		),
+		nvelope.InjectPreferredLanguage,
+		func() (nvelope.Response, error) {
+			return nil, errNotFound
+		},
+	)
+	res, err := do("/x", header("Accept-Language", "fr"))
+	require.NoError(t, err)
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, 404, res.StatusCode)
+	assert.Equal(t, "application/json", res.Header.Get("Content-Type"))
+	assert.Equal(t, `{"code":404,"message":"introuvable"}`, string(b), "the localized message is encoded")
+}
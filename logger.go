@@ -15,6 +15,22 @@ type BasicLogger interface {
 	Warn(msg string, fields ...map[string]interface{})
 }
 
+// InfoLogger is an optional extension of BasicLogger.  If the
+// BasicLogger also implements InfoLogger, then Info is used for events
+// that are not problems, like clients disconnecting.  Otherwise Debug
+// is used.
+type InfoLogger interface {
+	Info(msg string, fields ...map[string]interface{})
+}
+
+func logInfo(log BasicLogger, msg string, fields map[string]interface{}) {
+	if il, ok := log.(InfoLogger); ok {
+		il.Info(msg, fields)
+		return
+	}
+	log.Debug(msg, fields)
+}
+
 // StdLogger is implmented by the base library log.Logger
 type StdLogger interface {
 	Print(v ...interface{})
@@ -0,0 +1,40 @@
+package nvelope
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+
+	"github.com/muir/nject"
+)
+
+// MediaType is the parsed Content-Type header of a request.  Type is
+// lower case, like "application/json".  Params holds parameters like
+// "charset".  If there was no Content-Type header, Type is empty.
+type MediaType struct {
+	Type   string
+	Params map[string]string
+}
+
+// InjectMediaType is a provider that parses the Content-Type header
+// of the request once and provides MediaType.  A malformed
+// Content-Type is rejected with a 400.  Malformed parameters are
+// ignored if the media type itself can be parsed.
+var InjectMediaType = nject.Provide("media-type", GetMediaType)
+
+// GetMediaType parses the Content-Type header of r
+func GetMediaType(r *http.Request) (MediaType, nject.TerminalError) {
+	ct := r.Header.Get("Content-Type")
+	if ct == "" {
+		return MediaType{}, nil
+	}
+	mediaType, params, err := mime.ParseMediaType(ct)
+	if err != nil && !(errors.Is(err, mime.ErrInvalidMediaParameter) && mediaType != "") {
+		return MediaType{}, BadRequest(fmt.Errorf("invalid Content-Type '%s': %w", ct, err))
+	}
+	return MediaType{
+		Type:   mediaType,
+		Params: params,
+	}, nil
+}
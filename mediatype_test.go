@@ -0,0 +1,26 @@
+package nvelope_test
+
+import (
+	"testing"
+
+	"github.com/muir/nvelope"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInjectMediaType(t *testing.T) {
+	do := captureOutputChain("/x",
+		nvelope.NoLogger,
+		nvelope.InjectWriter,
+		nvelope.EncodeJSON,
+		nvelope.InjectMediaType,
+		func(mt nvelope.MediaType) (nvelope.Response, error) {
+			return mt, nil
+		},
+	)
+	assert.Equal(t, `200->{"Type":"application/json","Params":{"charset":"utf-8"}}`, do("/x", header("Content-Type", "Application/JSON; charset=utf-8")))
+	assert.Equal(t, `200->{"Type":"text/plain","Params":{}}`, do("/x", header("Content-Type", "text/plain")))
+	assert.Equal(t, `200->{"Type":"","Params":null}`, do("/x"))
+	assert.Equal(t, `200->{"Type":"text/html","Params":null}`, do("/x", header("Content-Type", "text/html; charset")), "bad parameters")
+	assert.Regexp(t, `^400->invalid Content-Type '/json'`, do("/x", header("Content-Type", "/json")))
+}
@@ -0,0 +1,116 @@
+/*
+Package nvelopetest provides helpers for testing endpoints that are
+built with nvelope.  Requests are handled in-process with
+httptest.NewRecorder so no server or router is needed.
+
+	res, err := nvelopetest.RoundTrip(MyEndpoint, "POST", "/things?verbose=true",
+		nvelopetest.Header("Content-Type", "application/json"),
+		nvelopetest.Body(`{"name":"x"}`))
+*/
+package nvelopetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/muir/nject"
+	"github.com/muir/nvelope"
+)
+
+// Mod modifies a request before it is handled
+type Mod func(*http.Request)
+
+// Body sets the request body
+func Body(s string) Mod {
+	return func(r *http.Request) {
+		r.Body = io.NopCloser(strings.NewReader(s))
+		r.ContentLength = int64(len(s))
+	}
+}
+
+// Header adds a request header
+func Header(key, value string) Mod {
+	return func(r *http.Request) {
+		r.Header.Add(key, value)
+	}
+}
+
+// Cookie adds a cookie to the request
+func Cookie(name, value string) Mod {
+	return func(r *http.Request) {
+		r.AddCookie(&http.Cookie{Name: name, Value: value})
+	}
+}
+
+// Result is the outcome of handling a request
+type Result struct {
+	Status int
+	Header http.Header
+	Body   string
+}
+
+// String formats the result as "status->body"
+func (r Result) String() string {
+	return fmt.Sprintf("%d->%s", r.Status, r.Body)
+}
+
+// MinimalChain wraps an endpoint with the providers that are needed
+// to decode requests as JSON, encode responses as JSON, and turn panics
+// into errors.  Options are passed to nvelope.GenerateDecoder.  Use
+// nvelope.WithPathVarsFunction if the endpoint uses path variables.
+func MinimalChain(endpoint interface{}, opts ...nvelope.DecodeInputsGeneratorOpt) nject.Provider {
+	opts = append([]nvelope.DecodeInputsGeneratorOpt{
+		nvelope.WithDecoder("application/json", json.Unmarshal),
+		nvelope.WithDefaultContentType("application/json"),
+	}, opts...)
+	return nject.Sequence("nvelopetest",
+		nvelope.NoLogger,
+		nvelope.InjectWriter,
+		nvelope.EncodeJSON,
+		nvelope.CatchPanic,
+		nvelope.Nil204,
+		nvelope.ReadBody,
+		nvelope.GenerateDecoder(opts...),
+		endpoint,
+	)
+}
+
+// Handler binds an injection chain into an http.HandlerFunc
+func Handler(chain ...interface{}) (http.HandlerFunc, error) {
+	var handler func(http.ResponseWriter, *http.Request)
+	err := nject.Sequence("nvelopetest-handler", chain...).Bind(&handler, nil)
+	if err != nil {
+		return nil, err
+	}
+	return handler, nil
+}
+
+// Do sends a request to a handler and returns the result
+func Do(h http.Handler, method string, target string, mods ...Mod) Result {
+	r := httptest.NewRequest(method, target, nil)
+	for _, mod := range mods {
+		mod(r)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return Result{
+		Status: w.Code,
+		Header: w.Header(),
+		Body:   w.Body.String(),
+	}
+}
+
+// RoundTrip wraps the endpoint with MinimalChain, sends it one
+// request, and returns the result.  An error is returned only if
+// the injection chain cannot be bound.
+func RoundTrip(endpoint interface{}, method string, target string, mods ...Mod) (Result, error) {
+	h, err := Handler(MinimalChain(endpoint))
+	if err != nil {
+		return Result{}, err
+	}
+	return Do(h, method, target, mods...), nil
+}
@@ -0,0 +1,70 @@
+package nvelopetest_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/muir/nvelope"
+	"github.com/muir/nvelope/nvelopetest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type thing struct {
+	Name  string `json:"name,omitempty"`
+	Count int    `json:"count,omitempty"`
+}
+
+func TestRoundTrip(t *testing.T) {
+	endpoint := func(s struct {
+		Thing   thing  `nvelope:"model"`
+		Verbose bool   `nvelope:"query,name=verbose"`
+		Trace   string `nvelope:"header,name=X-Trace"`
+		Session string `nvelope:"cookie,name=session"`
+	},
+	) (nvelope.Response, error) {
+		if s.Thing.Count < 0 {
+			return nil, nvelope.BadRequest(fmt.Errorf("negative count"))
+		}
+		return map[string]interface{}{
+			"thing":   s.Thing,
+			"verbose": s.Verbose,
+			"trace":   s.Trace,
+			"session": s.Session,
+		}, nil
+	}
+	res, err := nvelopetest.RoundTrip(endpoint, "POST", "/x?verbose=true",
+		nvelopetest.Body(`{"name":"a","count":2}`),
+		nvelopetest.Header("X-Trace", "t1"),
+		nvelopetest.Cookie("session", "s1"))
+	require.NoError(t, err)
+	assert.Equal(t, `200->{"session":"s1","thing":{"name":"a","count":2},"trace":"t1","verbose":true}`, res.String())
+	assert.Equal(t, "application/json", res.Header.Get("Content-Type"))
+
+	res, err = nvelopetest.RoundTrip(endpoint, "POST", "/x", nvelopetest.Body(`{"count":-1}`))
+	require.NoError(t, err)
+	assert.Equal(t, 400, res.Status)
+	assert.Equal(t, "negative count", res.Body)
+
+	_, err = nvelopetest.RoundTrip(func(s struct {
+		ID int `nvelope:"path,name=id"`
+	},
+	) {
+	}, "GET", "/x")
+	assert.Error(t, err, "no path vars function")
+}
+
+func TestHandler(t *testing.T) {
+	h, err := nvelopetest.Handler(nvelopetest.MinimalChain(func(s struct {
+		ID int `nvelope:"path,name=id"`
+	},
+	) (nvelope.Response, error) {
+		return s.ID, nil
+	}, nvelope.WithPathVarsFunction(func(r *http.Request) nvelope.RouteVarLookup {
+		return func(string) string { return "42" }
+	})))
+	require.NoError(t, err)
+	assert.Equal(t, "200->42", nvelopetest.Do(h, "GET", "/x/42").String())
+}
@@ -0,0 +1,39 @@
+// Package nvproto decodes protobuf models for nvelope.  It is a
+// separate module so that nvelope does not depend upon protobuf.
+package nvproto
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// ProtoJSONDecoder is a Decoder that uses protojson.Unmarshal to
+// fill protobuf messages from JSON so that the JSON field names
+// match the proto field names (both the lowerCamelCase and
+// the original names are accepted).  Model fields can be either
+// a message or a pointer to a message.  Targets that are not protobuf
+// messages are decoded with json.Unmarshal.
+//
+//	nvelope.GenerateDecoder(
+//		nvelope.WithDecoder("application/json", nvproto.ProtoJSONDecoder),
+//	)
+func ProtoJSONDecoder(data []byte, target interface{}) error {
+	if m, ok := target.(proto.Message); ok {
+		return protojson.Unmarshal(data, m)
+	}
+	v := reflect.ValueOf(target)
+	if v.Kind() == reflect.Ptr && !v.IsNil() && v.Elem().Kind() == reflect.Ptr {
+		p := v.Elem()
+		if _, ok := reflect.Zero(p.Type()).Interface().(proto.Message); ok {
+			if p.IsNil() {
+				p.Set(reflect.New(p.Type().Elem()))
+			}
+			return protojson.Unmarshal(data, p.Interface().(proto.Message))
+		}
+	}
+	return errors.WithStack(json.Unmarshal(data, target))
+}
@@ -0,0 +1,36 @@
+package nvproto_test
+
+import (
+	"testing"
+
+	"github.com/muir/nvelope/nvproto"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/apipb"
+)
+
+func TestProtoJSONDecoder(t *testing.T) {
+	body := []byte(`{"name":"Get","requestTypeUrl":"type.googleapis.com/Req","response_streaming":true}`)
+
+	var ptr *apipb.Method
+	require.NoError(t, nvproto.ProtoJSONDecoder(body, &ptr), "pointer to a message")
+	require.NotNil(t, ptr)
+	assert.Equal(t, "Get", ptr.GetName())
+	assert.Equal(t, "type.googleapis.com/Req", ptr.GetRequestTypeUrl(), "lowerCamelCase names")
+	assert.True(t, ptr.GetResponseStreaming(), "original names")
+
+	var m apipb.Method
+	require.NoError(t, nvproto.ProtoJSONDecoder(body, &m), "message")
+	assert.Equal(t, "Get", m.GetName())
+
+	err := nvproto.ProtoJSONDecoder([]byte(`{"nope":1}`), &m)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `unknown field "nope"`)
+
+	var plain struct {
+		Name string `json:"name"`
+	}
+	require.NoError(t, nvproto.ProtoJSONDecoder([]byte(`{"name":"x"}`), &plain), "not a message")
+	assert.Equal(t, "x", plain.Name)
+}
@@ -0,0 +1,29 @@
+package nvelope
+
+// Pagination is a set of commonly used paging query parameters.
+// Embed it into a request model and GenerateDecoder will fill
+// it along with the rest of the model:
+//
+//	type ListRequest struct {
+//		nvelope.Pagination
+//		Filter string `nvelope:"query,name=filter"`
+//	}
+//
+// "page" defaults to 1 and "per_page" defaults to 20 and is limited
+// to at most 100.  If different limits are needed, define a similar
+// struct with different tags.
+type Pagination struct {
+	Page    int    `nvelope:"query,name=page,default=1,minimum=1"`
+	PerPage int    `nvelope:"query,name=per_page,default=20,minimum=1,maximum=100"`
+	Offset  int    `nvelope:"query,name=offset,minimum=0"`
+	Cursor  string `nvelope:"query,name=cursor"`
+}
+
+// Skip returns the number of items to skip: Offset if it was
+// specified, otherwise the number of items in the pages before Page.
+func (p Pagination) Skip() int {
+	if p.Offset != 0 {
+		return p.Offset
+	}
+	return (p.Page - 1) * p.PerPage
+}
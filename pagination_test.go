@@ -0,0 +1,29 @@
+package nvelope_test
+
+import (
+	"testing"
+
+	"github.com/muir/nvelope"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPagination(t *testing.T) {
+	do := captureOutput("/x", func(s struct {
+		nvelope.Pagination
+		Filter string `json:",omitempty" nvelope:"query,name=filter"`
+	},
+	) (nvelope.Response, error) {
+		return map[string]interface{}{
+			"page":     s.Page,
+			"per_page": s.PerPage,
+			"cursor":   s.Cursor,
+			"skip":     s.Skip(),
+		}, nil
+	})
+	assert.Equal(t, `200->{"cursor":"","page":1,"per_page":20,"skip":0}`, do("/x"))
+	assert.Equal(t, `200->{"cursor":"abc","page":3,"per_page":10,"skip":20}`, do("/x?page=3&per_page=10&cursor=abc"))
+	assert.Equal(t, `200->{"cursor":"","page":1,"per_page":20,"skip":7}`, do("/x?offset=7"))
+	assert.Regexp(t, `^400->.*decode query per_page: 101 must be at most 100$`, do("/x?per_page=101"))
+	assert.Regexp(t, `^400->.*decode query page: 0 must be at least 1$`, do("/x?page=0"))
+}
@@ -2,6 +2,7 @@ package nvelope
 
 import (
 	"fmt"
+	"net/http"
 	"runtime/debug"
 
 	"github.com/muir/nject"
@@ -26,12 +27,18 @@ func (err panicError) Error() string {
 }
 
 // SetErrorOnPanic should be called as a defer.  It
-// sets an error value if there is a panic.
+// sets an error value if there is a panic.  A panic with
+// http.ErrAbortHandler is not caught: it is re-panicked so that
+// net/http can abort the response as documented.
 func SetErrorOnPanic(ep *error, log BasicLogger) {
 	r := recover()
 	if r == nil {
 		return
 	}
+	// nolint:errorlint
+	if r == http.ErrAbortHandler {
+		panic(r)
+	}
 	pe := panicError{
 		msg:   fmt.Sprint(r),
 		r:     r,
@@ -48,8 +55,73 @@ func SetErrorOnPanic(ep *error, log BasicLogger) {
 }
 
 // CatchPanic is a wrapper that catches downstream panics and returns
-// an error a downsteam provider panic's.
-var CatchPanic = nject.Provide("catch-panic", catchPanicInjector)
+// an error a downsteam provider panic's.  The error has no return code
+// so it is sent as a 500 with the error message ("panic: ...") as the
+// body.  Use MakeCatchPanic with WithPanicResponseBody to control the
+// response, or SuppressErrorBodyFor(500) to hide the message.
+var CatchPanic = MakeCatchPanic()
+
+// CatchPanicOpt is a functional argument for MakeCatchPanic
+type CatchPanicOpt func(*catchPanicOptions)
+
+type catchPanicOptions struct {
+	responseBody func(recovered interface{}, stack []byte) (int, interface{})
+}
+
+// WithPanicResponseBody provides a function that chooses the HTTP
+// status and the response body when a panic is caught.  The body is
+// encoded by the response encoder (see ErrorBody) so it can be any
+// model.  A status of 0 means 500.  A nil body sends the text for the
+// status, like "Internal Server Error".  The panic is still logged.
+//
+//	nvelope.MakeCatchPanic(nvelope.WithPanicResponseBody(
+//		func(recovered interface{}, stack []byte) (int, interface{}) {
+//			if !development {
+//				return 500, map[string]string{"error": "internal error"}
+//			}
+//			return 500, map[string]string{
+//				"error": fmt.Sprint(recovered),
+//				"stack": string(stack),
+//			}
+//		}))
+func WithPanicResponseBody(f func(recovered interface{}, stack []byte) (int, interface{})) CatchPanicOpt {
+	return func(o *catchPanicOptions) {
+		o.responseBody = f
+	}
+}
+
+// MakeCatchPanic generates a wrapper, like CatchPanic, that catches
+// downstream panics and turns them into errors.  It must be downstream
+// of the response encoder for WithPanicResponseBody to be used.
+func MakeCatchPanic(opts ...CatchPanicOpt) nject.Provider {
+	var o catchPanicOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.responseBody == nil {
+		return nject.Provide("catch-panic", catchPanicInjector)
+	}
+	return nject.Provide("catch-panic", func(inner func() error, log BasicLogger) (err error) {
+		defer func() {
+			pe, ok := isPanicError(err)
+			if !ok {
+				return
+			}
+			code, body := o.responseBody(pe.r, []byte(pe.stack))
+			if code == 0 {
+				code = http.StatusInternalServerError
+			}
+			if body == nil {
+				err = ReturnCode(WithClientMessage(err, http.StatusText(code)), code)
+			} else {
+				err = ReturnCode(WithErrorBody(err, body), code)
+			}
+		}()
+		defer SetErrorOnPanic(&err, log)
+		err = inner()
+		return
+	})
+}
 
 func catchPanicInjector(inner func() error, log BasicLogger) (err error) {
 	defer SetErrorOnPanic(&err, log)
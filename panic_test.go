@@ -0,0 +1,94 @@
+package nvelope_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/muir/nvelope"
+	"github.com/muir/nvelope/nvelopetest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetErrorOnPanicAbortHandler(t *testing.T) {
+	f := func(v interface{}) (err error) {
+		defer nvelope.SetErrorOnPanic(&err, nvelope.NoLogger())
+		panic(v)
+	}
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() { _ = f(http.ErrAbortHandler) })
+	err := f(fmt.Errorf("oops"))
+	assert.Equal(t, "panic: oops", err.Error())
+	assert.NotNil(t, nvelope.RecoverInterface(err))
+}
+
+func TestPanicResponseBody(t *testing.T) {
+	handler := func(dev bool) http.HandlerFunc {
+		h, err := nvelopetest.Handler(
+			nvelope.NoLogger,
+			nvelope.InjectWriter,
+			nvelope.EncodeJSON,
+			nvelope.MakeCatchPanic(nvelope.WithPanicResponseBody(
+				func(recovered interface{}, stack []byte) (int, interface{}) {
+					if !dev {
+						return 0, map[string]string{"error": "internal error"}
+					}
+					return http.StatusServiceUnavailable, map[string]string{
+						"panic": fmt.Sprint(recovered),
+						"stack": string(stack),
+					}
+				})),
+			func(r *http.Request) (nvelope.Response, error) {
+				switch r.URL.Path {
+				case "/ok":
+					return "fine", nil
+				case "/error":
+					return nil, nvelope.BadRequest(fmt.Errorf("plain error"))
+				}
+				panic("boom")
+			},
+		)
+		require.NoError(t, err)
+		return h
+	}
+
+	prod := handler(false)
+	assert.Equal(t, `500->{"error":"internal error"}`, nvelopetest.Do(prod, "GET", "/panic").String())
+	assert.Equal(t, `200->"fine"`, nvelopetest.Do(prod, "GET", "/ok").String())
+	assert.Equal(t, `400->plain error`, nvelopetest.Do(prod, "GET", "/error").String(), "errors are not changed")
+
+	res := nvelopetest.Do(handler(true), "GET", "/panic")
+	assert.Equal(t, http.StatusServiceUnavailable, res.Status)
+	var body map[string]string
+	require.NoError(t, json.Unmarshal([]byte(res.Body), &body))
+	assert.Equal(t, "boom", body["panic"])
+	assert.Contains(t, body["stack"], "TestPanicResponseBody", "stack is from the panic")
+
+	nilBody, err := nvelopetest.Handler(
+		nvelope.NoLogger,
+		nvelope.InjectWriter,
+		nvelope.EncodeJSON,
+		nvelope.MakeCatchPanic(nvelope.WithPanicResponseBody(func(interface{}, []byte) (int, interface{}) {
+			return 0, nil
+		})),
+		func() (nvelope.Response, error) {
+			panic("secret")
+		},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, `500->Internal Server Error`, nvelopetest.Do(nilBody, "GET", "/").String())
+
+	plain, err := nvelopetest.Handler(
+		nvelope.NoLogger,
+		nvelope.InjectWriter,
+		nvelope.EncodeJSON,
+		nvelope.MakeCatchPanic(),
+		func() (nvelope.Response, error) {
+			panic("boom")
+		},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, `500->panic: boom`, nvelopetest.Do(plain, "GET", "/").String(), "without options it is like CatchPanic")
+}
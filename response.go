@@ -0,0 +1,186 @@
+package nvelope
+
+import (
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+type redirectResponse struct {
+	code     int
+	location string
+}
+
+// Redirect creates a Response that causes the response encoder
+// to send a redirect: the Location header is set to location and
+// the HTTP status is set to code, which must be a 3xx code like
+// http.StatusFound.  No body is sent.  Other codes are reported as an
+// error by the response encoder.
+//
+//	func(r *http.Request) (nvelope.Response, error) {
+//		return nvelope.Redirect(http.StatusSeeOther, "/elsewhere"), nil
+//	}
+func Redirect(code int, location string) Response {
+	return redirectResponse{
+		code:     code,
+		location: location,
+	}
+}
+
+// NoContent is a Response that causes the response encoder to send
+// a 204 with no body.  Unlike Nil204, it does not depend upon
+// the handler returning nil.
+//
+//	return nvelope.NoContent{}, nil
+type NoContent struct{}
+
+// StoredResponse is a Response that is written as-is by the response
+// encoder: the status, headers, and body are sent without encoding.
+// It is meant for replaying responses that were saved earlier, for
+// example by middleware that implements idempotency keys.  Headers
+// in Header replace any headers of the same name that were already
+// set.  A Status of zero is sent as 200.
+//
+//	if saved, ok := cache.Get(key); ok {
+//		return nvelope.StoredResponse{
+//			Status: saved.Status,
+//			Header: saved.Header,
+//			Body:   saved.Body,
+//		}, nil
+//	}
+type StoredResponse struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+type asResponse struct {
+	contentType string
+	model       Response
+}
+
+// As creates a Response that overrides content negotiation: the response
+// encoder will use the encoder registered (with WithEncoder) for contentType
+// to encode model.  If there is no such encoder, the response will be
+// an error.
+//
+//	if r.Export {
+//		return nvelope.As("text/csv", rows), nil
+//	}
+//	return rows, nil
+func As(contentType string, model Response) Response {
+	return asResponse{
+		contentType: contentType,
+		model:       model,
+	}
+}
+
+type metaResponse struct {
+	model Response
+	meta  interface{}
+}
+
+// WithMeta creates a Response that adds meta to the envelope
+// created by WithEnvelope:
+//
+//	return nvelope.WithMeta(rows, Page{Total: total}), nil
+//
+// is encoded as {"data": rows, "meta": {"total": ...}}.  If the response
+// encoder was not created with WithEnvelope, the envelope key is "data".
+func WithMeta(model Response, meta interface{}) Response {
+	return metaResponse{
+		model: model,
+		meta:  meta,
+	}
+}
+
+type cookieResponse struct {
+	model   Response
+	cookies []*http.Cookie
+}
+
+// WithCookies creates a Response that causes the response encoder to
+// add a Set-Cookie header for each of the cookies (using http.SetCookie)
+// and then encode model as usual.  Model can be any Response, including
+// Redirect and NoContent.  The cookies are not set if the handler
+// also returns an error.
+//
+//	return nvelope.WithCookies(user, &http.Cookie{
+//		Name:     "session",
+//		Value:    token,
+//		HttpOnly: true,
+//	}), nil
+func WithCookies(model Response, cookies ...*http.Cookie) Response {
+	return cookieResponse{
+		model:   model,
+		cookies: cookies,
+	}
+}
+
+// specialResponse is a Response that is not encoded as a model
+type specialResponse struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// getSpecialResponse describes the Response types that are not
+// encoded as models, including those wrapped with As.  It returns false
+// for other responses.
+func getSpecialResponse(model Response) (specialResponse, bool, error) {
+	switch m := model.(type) {
+	case asResponse:
+		return getSpecialResponse(m.model)
+	case redirectResponse:
+		if m.code < 300 || m.code > 399 {
+			return specialResponse{}, true, errors.Errorf("redirect to %s uses status %d, not a 3xx status", m.location, m.code)
+		}
+		return specialResponse{
+			status: m.code,
+			header: http.Header{"Location": []string{m.location}},
+		}, true, nil
+	case NoContent, *NoContent:
+		return specialResponse{status: http.StatusNoContent}, true, nil
+	case StoredResponse:
+		return storedSpecialResponse(m), true, nil
+	case *StoredResponse:
+		return storedSpecialResponse(*m), true, nil
+	default:
+		return specialResponse{}, false, nil
+	}
+}
+
+func storedSpecialResponse(m StoredResponse) specialResponse {
+	status := m.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return specialResponse{
+		status: status,
+		header: m.Header,
+		body:   m.Body,
+	}
+}
+
+// withHeader returns a copy of header with the special response's
+// headers applied
+func (s specialResponse) withHeader(header http.Header) http.Header {
+	header = header.Clone()
+	for key, values := range s.header {
+		header[key] = append([]string(nil), values...)
+	}
+	return header
+}
+
+func (s specialResponse) write(w *DeferredWriter) error {
+	for key, values := range s.header {
+		w.Header()[key] = append([]string(nil), values...)
+	}
+	w.WriteHeader(s.status)
+	if len(s.body) != 0 {
+		if _, err := w.Write(s.body); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,220 @@
+package nvelope_test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/muir/nape"
+	"github.com/muir/nvelope"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedirect(t *testing.T) {
+	do := captureResponse("/x", func() (nvelope.Response, error) {
+		return nvelope.Redirect(http.StatusSeeOther, "/elsewhere"), nil
+	})
+	res, err := do("/x")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusSeeOther, res.StatusCode)
+	assert.Equal(t, "/elsewhere", res.Header.Get("Location"))
+	assert.Empty(t, b)
+}
+
+func TestRedirectInvalidCode(t *testing.T) {
+	do := captureResponse("/x", func() (nvelope.Response, error) {
+		return nvelope.Redirect(http.StatusOK, "/elsewhere"), nil
+	})
+	res, err := do("/x")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+	assert.Empty(t, res.Header.Get("Location"))
+	assert.Contains(t, string(b), "uses status 200, not a 3xx status")
+}
+
+func TestNoContent(t *testing.T) {
+	do := captureOutput("/x", func() (nvelope.Response, error) {
+		return nvelope.NoContent{}, nil
+	})
+	assert.Equal(t, "204->", do("/x"))
+
+	do = captureOutput("/x", func() (nvelope.Response, error) {
+		return &nvelope.NoContent{}, nil
+	})
+	assert.Equal(t, "204->", do("/x"), "pointer")
+}
+
+func TestSpecialResponseAPIEnforcer(t *testing.T) {
+	type call struct {
+		code     int
+		location string
+	}
+	var calls []call
+	do := captureOutputChain("/x",
+		nvelope.NoLogger,
+		nvelope.InjectWriter,
+		nvelope.MakeResponseEncoder("JSON",
+			nvelope.WithEncoder("application/json", json.Marshal,
+				nvelope.WithAPIEnforcer(func(code int, enc []byte, header http.Header, r *http.Request) error {
+					calls = append(calls, call{code: code, location: header.Get("Location")})
+					if code == http.StatusFound {
+						return errors.New("302 is not in the API")
+					}
+					return nil
+				}),
+			),
+		),
+		nvelope.ReadBody,
+		nape.DecodeJSON,
+		func(r struct {
+			Kind string `nvelope:"query,name=kind"`
+		},
+		) (nvelope.Response, error) {
+			switch r.Kind {
+			case "found":
+				return nvelope.Redirect(http.StatusFound, "/found"), nil
+			case "stored":
+				return nvelope.StoredResponse{Status: 202, Body: []byte("saved")}, nil
+			case "empty":
+				return nvelope.NoContent{}, nil
+			}
+			return nvelope.Redirect(http.StatusSeeOther, "/other"), nil
+		},
+	)
+	assert.Equal(t, "303->", do("/x"))
+	assert.Equal(t, []call{{code: 303, location: "/other"}}, calls)
+
+	calls = nil
+	assert.Equal(t, "204->", do("/x?kind=empty"))
+	assert.Equal(t, []call{{code: 204}}, calls)
+
+	calls = nil
+	assert.Equal(t, "202->saved", do("/x?kind=stored"))
+	assert.Equal(t, []call{{code: 202}}, calls)
+
+	calls = nil
+	assert.Equal(t, "500->302 is not in the API", do("/x?kind=found"), "rejected redirects become errors")
+	if assert.Len(t, calls, 2) {
+		assert.Equal(t, call{code: 302, location: "/found"}, calls[0])
+		assert.Equal(t, 500, calls[1].code)
+	}
+}
+
+func TestWithCookies(t *testing.T) {
+	do := captureResponse("/x", func(r *http.Request) (nvelope.Response, error) {
+		cookies := []*http.Cookie{
+			{Name: "session", Value: "abc", HttpOnly: true},
+			{Name: "theme", Value: "dark", Path: "/"},
+		}
+		switch r.URL.Query().Get("kind") {
+		case "redirect":
+			return nvelope.WithCookies(nvelope.Redirect(http.StatusSeeOther, "/home"), cookies...), nil
+		case "error":
+			return nvelope.WithCookies("hi", cookies...), errors.New("oops")
+		default:
+			return nvelope.WithCookies(map[string]string{"a": "b"}, cookies...), nil
+		}
+	})
+	get := func(url string) (*http.Response, string) {
+		res, err := do(url)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		b, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		return res, string(b)
+	}
+
+	res, b := get("/x")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, `{"a":"b"}`, b)
+	assert.Equal(t, []string{"session=abc; HttpOnly", "theme=dark; Path=/"}, res.Header.Values("Set-Cookie"))
+
+	res, b = get("/x?kind=redirect")
+	assert.Equal(t, http.StatusSeeOther, res.StatusCode)
+	assert.Equal(t, "/home", res.Header.Get("Location"))
+	assert.Empty(t, b)
+	assert.Len(t, res.Header.Values("Set-Cookie"), 2)
+
+	res, _ = get("/x?kind=error")
+	assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+	assert.Empty(t, res.Header.Values("Set-Cookie"), "no cookies with an error")
+}
+
+func TestStoredResponse(t *testing.T) {
+	stored := nvelope.StoredResponse{
+		Status: http.StatusCreated,
+		Header: http.Header{
+			"Content-Type": {"application/vnd.thing+json"},
+			"Location":     {"/things/7"},
+		},
+		Body: []byte(`{"id": 7}`),
+	}
+	do := captureResponse("/x", func() (nvelope.Response, error) {
+		return stored, nil
+	})
+	res, err := do("/x")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+	assert.Equal(t, "application/vnd.thing+json", res.Header.Get("Content-Type"))
+	assert.Equal(t, "/things/7", res.Header.Get("Location"))
+	assert.Equal(t, `{"id": 7}`, string(b), "body is not re-encoded")
+
+	assert.Equal(t, "200->raw", captureOutput("/x", func() (nvelope.Response, error) {
+		return &nvelope.StoredResponse{Body: []byte("raw")}, nil
+	})("/x"))
+}
+
+func TestAs(t *testing.T) {
+	encodeCSV := func(i interface{}) ([]byte, error) {
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		err := w.WriteAll(i.([][]string))
+		return buf.Bytes(), err
+	}
+	do := captureOutputChain("/x",
+		nvelope.NoLogger,
+		nvelope.InjectWriter,
+		nvelope.MakeResponseEncoder("multi",
+			nvelope.WithEncoder("application/json", json.Marshal),
+			nvelope.WithEncoder("text/csv", encodeCSV),
+		),
+		nvelope.ReadBody,
+		nape.DecodeJSON,
+		func(r struct {
+			Export string `nvelope:"query,name=export"`
+		},
+		) (nvelope.Response, error) {
+			rows := [][]string{{"a", "b"}, {"c", "d"}}
+			switch r.Export {
+			case "none":
+				return nvelope.As("text/csv", nvelope.NoContent{}), nil
+			case "moved":
+				return nvelope.As("text/csv", nvelope.Redirect(http.StatusSeeOther, "/export.csv")), nil
+			}
+			if r.Export != "" {
+				return nvelope.As(r.Export, rows), nil
+			}
+			return rows, nil
+		},
+	)
+	assert.Equal(t, `200->[["a","b"],["c","d"]]`, do("/x"))
+	assert.Equal(t, "200->a,b\nc,d\n", do("/x?export=text/csv"))
+	assert.Equal(t, "500->no response encoder for text/html", do("/x?export=text/html"))
+	assert.Equal(t, "204->", do("/x?export=none"), "wrapped NoContent is not encoded as a model")
+	assert.Equal(t, "303->", do("/x?export=moved"), "wrapped Redirect is not encoded as a model")
+}
@@ -0,0 +1,52 @@
+package nvelope
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// JSONSchemaValidator checks a JSON document against a JSON Schema.
+// If the document does not match, it returns an error that describes
+// the violations.  nvelope does not include a JSON Schema
+// implementation: adapt the library of your choice.  The validator is
+// called with the same schema for every request so it can compile the
+// schema once and cache it.
+type JSONSchemaValidator func(schema string, document []byte) error
+
+// WithJSONSchemaValidation validates JSON request bodies against
+// a JSON Schema before they are decoded into the model.  Bodies are
+// only validated if their Content-Type (or the WithDefaultContentType)
+// is application/json or ends with +json.  Empty bodies are not
+// validated.  A body that does not match is rejected with a 400 (or
+// the status set with WithDecodeErrorStatus or UseUnprocessableEntity)
+// whose message includes the violations reported by the validator,
+// and the model is not decoded.
+func WithJSONSchemaValidation(schema string, validator JSONSchemaValidator) DecodeInputsGeneratorOpt {
+	return func(o *eigo) {
+		o.jsonSchema = schema
+		o.jsonSchemaValidator = validator
+	}
+}
+
+// validateJSONSchema implements WithJSONSchemaValidation
+func (options *eigo) validateJSONSchema(r *http.Request, body []byte) error {
+	if options.jsonSchemaValidator == nil || len(body) == 0 {
+		return nil
+	}
+	ct := r.Header.Get("Content-Type")
+	if ct == "" {
+		ct = options.defaultContentType
+	}
+	mediaType, _, _ := strings.Cut(ct, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	if mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json") {
+		return nil
+	}
+	err := options.jsonSchemaValidator(options.jsonSchema, body)
+	if err != nil {
+		return options.unprocessable(errors.Wrap(err, "request body does not match the JSON schema"))
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package nvelope_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/muir/nvelope"
+	"github.com/muir/nvelope/nvelopetest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// requiredOnlySchema is a stand-in for a JSON Schema library: it only
+// understands "required" and the "type" of properties
+func requiredOnlySchema(schema string, document []byte) error {
+	var s struct {
+		Required   []string `json:"required"`
+		Properties map[string]struct {
+			Type string `json:"type"`
+		} `json:"properties"`
+	}
+	if err := json.Unmarshal([]byte(schema), &s); err != nil {
+		return err
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(document, &doc); err != nil {
+		return err
+	}
+	var violations []string
+	for _, name := range s.Required {
+		if _, ok := doc[name]; !ok {
+			violations = append(violations, fmt.Sprintf("/%s is required", name))
+		}
+	}
+	for name, prop := range s.Properties {
+		if v, ok := doc[name]; ok && prop.Type == "string" {
+			if _, ok := v.(string); !ok {
+				violations = append(violations, fmt.Sprintf("/%s must be a string", name))
+			}
+		}
+	}
+	if len(violations) != 0 {
+		return fmt.Errorf("%s", strings.Join(violations, "; "))
+	}
+	return nil
+}
+
+func TestJSONSchemaValidation(t *testing.T) {
+	const schema = `{"required":["name","size"],"properties":{"name":{"type":"string"}}}`
+	var validated int
+	validator := func(s string, document []byte) error {
+		validated++
+		assert.Equal(t, schema, s)
+		return requiredOnlySchema(s, document)
+	}
+	h, err := nvelopetest.Handler(
+		nvelope.NoLogger,
+		nvelope.InjectWriter,
+		nvelope.EncodeJSON,
+		nvelope.ReadBody,
+		nvelope.GenerateDecoder(
+			nvelope.WithDecoder("application/json", json.Unmarshal),
+			nvelope.WithDecoder("application/xml", func([]byte, interface{}) error { return nil }),
+			nvelope.WithJSONSchemaValidation(schema, validator),
+		),
+		func(s struct {
+			Body map[string]interface{} `nvelope:"model"`
+		},
+		) (nvelope.Response, error) {
+			return s.Body, nil
+		},
+	)
+	require.NoError(t, err)
+	do := func(contentType string, body string) string {
+		return nvelopetest.Do(h, "POST", "/", nvelopetest.Header("Content-Type", contentType), nvelopetest.Body(body)).String()
+	}
+
+	assert.Equal(t, `200->{"name":"a","size":3}`, do("application/json", `{"name":"a","size":3}`))
+	assert.Equal(t, 1, validated)
+
+	res := do("application/json; charset=utf-8", `{"name":7}`)
+	assert.Regexp(t, `^400->.*request body does not match the JSON schema`, res)
+	assert.Contains(t, res, "/size is required")
+	assert.Contains(t, res, "/name must be a string")
+
+	assert.Regexp(t, `^400->.*/name is required`, do("application/vnd.thing+json", `{"size":1}`))
+
+	validated = 0
+	assert.Equal(t, `200->null`, do("application/xml", `<thing/>`), "only JSON bodies are validated")
+	assert.Regexp(t, `^400->.*unexpected end of JSON input`, do("application/json", ``),
+		"empty bodies are left to the decoder")
+	assert.Equal(t, 0, validated)
+}
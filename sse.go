@@ -0,0 +1,125 @@
+package nvelope
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/muir/nject"
+	"github.com/pkg/errors"
+)
+
+// SSEEvent is one Server-Sent Event.  Event and ID are omitted from
+// the stream when they are empty.  Line breaks in Event and ID are
+// removed.  Data can have multiple lines.
+type SSEEvent struct {
+	Event string
+	Data  string
+	ID    string
+}
+
+// EncodeSSE is a wrapper that sends responses that are channels of
+// SSEEvent (either <-chan SSEEvent or chan SSEEvent) as a stream of
+// Server-Sent Events (Content-Type: text/event-stream).  Each event
+// is flushed to the client as soon as it is received from the channel.
+// The stream ends when the channel is closed or when the client goes
+// away.  Code that sends to the channel should watch the request context
+// so that it doesn't block forever.
+//
+// Other responses, and errors, are passed through so EncodeSSE must be
+// used downstream from a response encoder like EncodeJSON:
+//
+//	service.RegisterEndpoint("/events",
+//		nvelope.InjectWriter,
+//		nvelope.EncodeJSON,
+//		nvelope.EncodeSSE,
+//		func(r *http.Request) (nvelope.Response, error) {
+//			events := make(chan nvelope.SSEEvent)
+//			go produce(r.Context(), events)
+//			return (<-chan nvelope.SSEEvent)(events), nil
+//		},
+//	)
+//
+// Since the status has already been sent, errors writing the stream
+// are logged rather than returned.
+var EncodeSSE = nject.Provide("encode-sse", encodeSSE)
+
+func encodeSSE(inner func() (Response, error), w *DeferredWriter, log BasicLogger, r *http.Request) (Response, error) {
+	model, err := inner()
+	if err != nil || w.Done() {
+		return model, err
+	}
+	var events <-chan SSEEvent
+	switch m := model.(type) {
+	case <-chan SSEEvent:
+		events = m
+	case chan SSEEvent:
+		events = m
+	default:
+		return model, err
+	}
+	streamSSE(w, r, log, events)
+	return nil, nil
+}
+
+func streamSSE(w *DeferredWriter, r *http.Request, log BasicLogger, events <-chan SSEEvent) {
+	logDetails := map[string]interface{}{
+		"method": r.Method,
+		"uri":    r.URL.String(),
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := w.Flush(); err != nil {
+		logWriteError(log, "Cannot write response", err, logDetails)
+		return
+	}
+	base := w.UnderlyingWriter()
+	flusher, _ := base.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if _, err := base.Write([]byte(formatSSE(event))); err != nil {
+				logWriteError(log, "Cannot stream response", errors.Wrap(err, "stream events"), logDetails)
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// formatSSE encodes an event in the text/event-stream format.  Line
+// breaks are removed from ID and Event so that they cannot start new
+// fields or events.  Data is split into lines on "\r\n", "\r", and "\n",
+// as clients do.
+func formatSSE(event SSEEvent) string {
+	var b strings.Builder
+	if id := stripLineBreaks(event.ID); id != "" {
+		b.WriteString("id: " + id + "\n")
+	}
+	if name := stripLineBreaks(event.Event); name != "" {
+		b.WriteString("event: " + name + "\n")
+	}
+	data := strings.ReplaceAll(event.Data, "\r\n", "\n")
+	data = strings.ReplaceAll(data, "\r", "\n")
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+var lineBreakStripper = strings.NewReplacer("\r", "", "\n", "")
+
+func stripLineBreaks(s string) string {
+	return lineBreakStripper.Replace(s)
+}
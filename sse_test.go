@@ -0,0 +1,146 @@
+package nvelope_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/muir/nvelope"
+	"github.com/muir/nvelope/nvelopetest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeSSE(t *testing.T) {
+	events := make(chan nvelope.SSEEvent)
+	done := make(chan struct{})
+	h, err := nvelopetest.Handler(
+		nvelope.NoLogger,
+		nvelope.InjectWriter,
+		nvelope.EncodeJSON,
+		nvelope.EncodeSSE,
+		func(r *http.Request) (nvelope.Response, error) {
+			switch r.URL.Query().Get("kind") {
+			case "error":
+				return nil, nvelope.ReturnCode(errors.New("no events"), http.StatusConflict)
+			case "json":
+				return map[string]int{"a": 1}, nil
+			}
+			go func() {
+				<-r.Context().Done()
+				close(done)
+			}()
+			return (<-chan nvelope.SSEEvent)(events), nil
+		},
+	)
+	require.NoError(t, err)
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/events")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+	assert.Equal(t, "no-cache", res.Header.Get("Cache-Control"))
+
+	reader := bufio.NewReader(res.Body)
+	readEvent := func() string {
+		var lines []string
+		for {
+			line, err := reader.ReadString('\n')
+			require.NoError(t, err)
+			if line == "\n" {
+				return strings.Join(lines, "")
+			}
+			lines = append(lines, line)
+		}
+	}
+	events <- nvelope.SSEEvent{Event: "greeting", Data: "hello", ID: "1"}
+	assert.Equal(t, "id: 1\nevent: greeting\ndata: hello\n", readEvent(), "first event is flushed before the second is sent")
+	events <- nvelope.SSEEvent{Data: "two\nlines"}
+	assert.Equal(t, "data: two\ndata: lines\n", readEvent())
+	close(events)
+	rest, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Empty(t, rest)
+	<-done
+
+	res2 := nvelopetest.Do(h, "GET", "/events?kind=json")
+	assert.Equal(t, `200->{"a":1}`, res2.String(), "other responses are encoded normally")
+	res2 = nvelopetest.Do(h, "GET", "/events?kind=error")
+	assert.Equal(t, 409, res2.Status, "errors are encoded normally")
+}
+
+func TestEncodeSSEInjection(t *testing.T) {
+	h, err := nvelopetest.Handler(
+		nvelope.NoLogger,
+		nvelope.InjectWriter,
+		nvelope.EncodeJSON,
+		nvelope.EncodeSSE,
+		func() (nvelope.Response, error) {
+			events := make(chan nvelope.SSEEvent, 4)
+			events <- nvelope.SSEEvent{ID: "1\nevent: admin\ndata: forged", Data: "a"}
+			events <- nvelope.SSEEvent{Event: "note\r\rdata: forged\r\r", Data: "b"}
+			events <- nvelope.SSEEvent{Data: "one\rtwo\r\nthree\n\nfour"}
+			events <- nvelope.SSEEvent{ID: "\r\n", Event: "\n", Data: "c"}
+			close(events)
+			return (<-chan nvelope.SSEEvent)(events), nil
+		},
+	)
+	require.NoError(t, err)
+	res := nvelopetest.Do(h, "GET", "/events")
+	assert.Equal(t, 200, res.Status)
+	assert.Equal(t, ""+
+		"id: 1event: admindata: forged\ndata: a\n\n"+
+		"event: notedata: forged\ndata: b\n\n"+
+		"data: one\ndata: two\ndata: three\ndata: \ndata: four\n\n"+
+		"data: c\n\n",
+		res.Body)
+	assert.NotContains(t, res.Body, "\r", "a lone CR would end a line on the client")
+}
+
+func TestEncodeSSEDisconnect(t *testing.T) {
+	events := make(chan nvelope.SSEEvent)
+	finished := make(chan struct{})
+	h, err := nvelopetest.Handler(
+		nvelope.NoLogger,
+		nvelope.InjectWriter,
+		func(inner func()) {
+			inner()
+			close(finished)
+		},
+		nvelope.EncodeJSON,
+		nvelope.EncodeSSE,
+		func() (nvelope.Response, error) {
+			return events, nil
+		},
+	)
+	require.NoError(t, err)
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, "GET", ts.URL, nil)
+	require.NoError(t, err)
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	events <- nvelope.SSEEvent{Data: "x"}
+	line, err := bufio.NewReader(res.Body).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "data: x\n", line)
+	cancel()
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler did not stop after the client went away")
+	}
+}
@@ -0,0 +1,144 @@
+package nvelope
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// StreamJSONArrays true causes responses that are channels (<-chan T)
+// or iterator functions (func(yield func(T) bool)) to be streamed
+// as a JSON array, one element at a time, rather than being collected
+// and encoded all at once.  The response is written directly to
+// the underlying http.ResponseWriter so it is not buffered by the
+// DeferredWriter and any APIEnforcerFunc is skipped.
+//
+// Since the status has already been sent when the first element is
+// written, errors in the middle of the stream cannot be reported to
+// the client.  Instead they are logged and the stream is cut off
+// without the closing "]" so that the client sees invalid JSON rather
+// than a short but valid array.
+//
+// Streaming stops early if the client goes away.  Code that sends
+// to the channel should watch the request context so that it doesn't
+// block forever.
+//
+// If the negotiated content type is not JSON, the elements are
+// collected into a slice and encoded normally.
+func StreamJSONArrays(b bool) ResponseEncoderFuncArg {
+	return func(o *encoderOptions) {
+		o.streamJSONArrays = b
+	}
+}
+
+// streamElements returns an iterator over the elements of model if
+// model is a receivable channel or an iterator function.
+func streamElements(model Response) (func(yield func(reflect.Value) bool), reflect.Type, bool) {
+	if model == nil {
+		return nil, nil, false
+	}
+	v := reflect.ValueOf(model)
+	t := v.Type()
+	switch t.Kind() {
+	case reflect.Chan:
+		if t.ChanDir()&reflect.RecvDir == 0 {
+			return nil, nil, false
+		}
+		return func(yield func(reflect.Value) bool) {
+			for {
+				e, ok := v.Recv()
+				if !ok || !yield(e) {
+					return
+				}
+			}
+		}, t.Elem(), true
+	case reflect.Func:
+		if t.NumIn() != 1 || t.NumOut() != 0 {
+			return nil, nil, false
+		}
+		yt := t.In(0)
+		if yt.Kind() != reflect.Func || yt.NumIn() != 1 || yt.NumOut() != 1 || yt.Out(0).Kind() != reflect.Bool {
+			return nil, nil, false
+		}
+		return func(yield func(reflect.Value) bool) {
+			v.Call([]reflect.Value{reflect.MakeFunc(yt, func(args []reflect.Value) []reflect.Value {
+				return []reflect.Value{reflect.ValueOf(yield(args[0]))}
+			})})
+		}, yt.In(0), true
+	default:
+		return nil, nil, false
+	}
+}
+
+// collectElements gathers streamed elements into a slice
+func collectElements(each func(yield func(reflect.Value) bool), elemType reflect.Type) Response {
+	s := reflect.MakeSlice(reflect.SliceOf(elemType), 0, 0)
+	each(func(e reflect.Value) bool {
+		s = reflect.Append(s, e)
+		return true
+	})
+	return s.Interface()
+}
+
+func isJSONContentType(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.TrimSpace(mediaType)
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// streamJSONArray writes the elements as a JSON array directly to
+// the underlying writer
+func streamJSONArray(w *DeferredWriter, r *http.Request, log BasicLogger, contentType string, each func(yield func(reflect.Value) bool)) {
+	logDetails := map[string]interface{}{
+		"method": r.Method,
+		"uri":    r.URL.String(),
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	if err := w.Flush(); err != nil {
+		logWriteError(log, "Cannot write response", err, logDetails)
+		return
+	}
+	base := w.UnderlyingWriter()
+	flusher, _ := base.(http.Flusher)
+	enc := json.NewEncoder(base)
+	var err error
+	write := func(s string) {
+		_, err = base.Write([]byte(s))
+	}
+	write("[")
+	first := true
+	each(func(e reflect.Value) bool {
+		if err != nil {
+			return false
+		}
+		if r.Context().Err() != nil {
+			err = r.Context().Err()
+			return false
+		}
+		if !first {
+			write(",")
+			if err != nil {
+				return false
+			}
+		}
+		first = false
+		err = enc.Encode(e.Interface())
+		if err != nil {
+			return false
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return true
+	})
+	if err == nil {
+		write("]")
+	}
+	if err != nil {
+		logWriteError(log, "Cannot stream response", errors.Wrap(err, "stream JSON array"), logDetails)
+	}
+}
@@ -0,0 +1,187 @@
+package nvelope
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/muir/reflectutils"
+	"github.com/pkg/errors"
+)
+
+// ValidateTags true causes each model's tags to be checked before
+// its decoder is generated.  Without it, some unsupported combinations
+// of tag options are only reported (one at a time) when the field
+// is reached and others are silently ignored.  With it, every tagged
+// field is checked and all of the problems are returned together in
+// one error so that mistakes are caught at startup.  Some of the
+// combinations that are checked:
+//
+//	unknown parameter kinds, like "nvelope:\"quer,name=x\""
+//	deepObject=true on anything but query maps, structs, slices, and arrays
+//	delimiter= or style= on path variables, headers, or cookies (except style=simple)
+//	explode=true on path variables
+//	form=, formOnly=, and allowReserved= on anything but query parameters
+//	scheme=, fold=, and prefix= on anything but headers
+//	minimum= and other numeric constraints on non-numbers
+//	format=, minLength=, and maxLength= on non-strings
+//	encoding= without content=
+//	boolStyle= on non-bools
+//	jwt=true on anything but headers and cookies
+//	exactItems=true on anything but arrays, or with deepObject=true
+//	numberFormat= on non-numbers, and numberFormat=grouped with comma-delimited lists
+func ValidateTags(b bool) DecodeInputsGeneratorOpt {
+	return func(o *eigo) {
+		o.validateTags = b
+	}
+}
+
+// checkModelTags returns an error listing every unsupported tag
+// combination in the model.
+func checkModelTags(model reflect.Type, tagName string) error {
+	var problems []string
+	walkModelFields(model, func(field reflect.StructField) bool {
+		tag, ok := reflectutils.LookupTag(field.Tag, tagName)
+		if !ok {
+			return true
+		}
+		tags, err := parseTag(tag)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", field.Name, err))
+			return false
+		}
+		for _, problem := range tagProblems(field.Type, tags) {
+			problems = append(problems, fmt.Sprintf("%s: %s", field.Name, problem))
+		}
+		return false
+	})
+	if len(problems) == 0 {
+		return nil
+	}
+	return errors.Errorf("unsupported %s tags in %s:\n\t%s", tagName, model, strings.Join(problems, "\n\t"))
+}
+
+// tagProblems checks the options of one field against what is supported
+// for its parameter kind and type
+func tagProblems(fieldType reflect.Type, tags tags) []string {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	only := func(set bool, option string, bases ...string) {
+		if !set {
+			return
+		}
+		for _, base := range bases {
+			if tags.Base == base {
+				return
+			}
+		}
+		list := strings.Join(bases, " and ")
+		if len(bases) > 2 {
+			list = strings.Join(bases[:len(bases)-1], ", ") + ", and " + bases[len(bases)-1]
+		}
+		add("%s is only supported for %s, not %s", option, list, tags.Base)
+	}
+	switch tags.Base {
+	case "-":
+		return nil
+	case "model", "path", "query", "header", "cookie", "method", "host", "scheme", "options":
+	default:
+		add("'%s' is not a known parameter kind (use model, path, query, header, cookie, method, host, scheme, or options)", tags.Base)
+		return problems
+	}
+	only(tags.RejectUnknown != nil, "rejectUnknown=", "options")
+	if tags.Base == "options" {
+		return problems
+	}
+	if tags.Base == "model" {
+		return problems
+	}
+
+	scalar := fieldType
+	for scalar.Kind() == reflect.Ptr {
+		scalar = scalar.Elem()
+	}
+	container := scalar
+	if scalar.Kind() == reflect.Slice || scalar.Kind() == reflect.Array {
+		scalar = scalar.Elem()
+		for scalar.Kind() == reflect.Ptr {
+			scalar = scalar.Elem()
+		}
+	}
+
+	if tags.DeepObject {
+		only(true, "deepObject=true", "query")
+		// nolint:exhaustive
+		switch container.Kind() {
+		case reflect.Map, reflect.Struct, reflect.Slice, reflect.Array:
+		default:
+			add("deepObject=true is only supported for maps, structs, slices, and arrays, not %s", fieldType)
+		}
+	}
+	if tags.Style != "" && tags.Style != "simple" {
+		only(true, "style="+tags.Style, "query")
+	} else if tags.Delimiter != "," {
+		only(true, "delimiter=", "query")
+	}
+	only(tags.Explode, "explode=true", "query", "header", "cookie")
+	only(tags.Form, "form=true", "query")
+	only(tags.FormOnly, "formOnly=true", "query")
+	only(tags.AllowReserved, "allowReserved=true", "query")
+	only(tags.Scheme != "", "scheme=", "header")
+	only(tags.Fold, "fold=true", "header")
+	only(tags.Prefix != "", "prefix=", "header")
+	only(tags.Presence, "presence=true", "query", "header")
+	only(tags.Join != "", "join=", "query", "header")
+	only(tags.JWT, "jwt=true", "header", "cookie")
+	if tags.JWT && tags.Content != "" {
+		add("jwt=true cannot be combined with content=")
+	}
+	if tags.Join != "" && !tags.Explode {
+		add("join= requires explode=true")
+	}
+	if tags.BoolStyle != "" && scalar.Kind() != reflect.Bool {
+		add("boolStyle= is only supported for bools, not %s", fieldType)
+	}
+	if tags.ExactItems && container.Kind() != reflect.Array {
+		add("exactItems=true requires an array, not %s", fieldType)
+	} else if tags.ExactItems && tags.DeepObject {
+		add("exactItems=true cannot be combined with deepObject=true")
+	}
+	if tags.NumberFormat == "grouped" && tags.Delimiter == "," && !tags.Explode &&
+		(container.Kind() == reflect.Slice || container.Kind() == reflect.Array) {
+		add("numberFormat=grouped requires a delimiter other than comma")
+	}
+	if tags.Presence && fieldType.Kind() != reflect.Bool {
+		add("presence=true requires a bool, not %s", fieldType)
+	}
+	if tags.Encoding != "" && tags.Content == "" {
+		add("encoding=%s requires content=", tags.Encoding)
+	}
+	if tags.Required && tags.Default != "" {
+		add("required and default cannot both be set")
+	}
+	if tags.Content == "" {
+		numeric := tags.Minimum != nil || tags.Maximum != nil || tags.ExclusiveMin != nil ||
+			tags.ExclusiveMax != nil || tags.MultipleOf != nil
+		// nolint:exhaustive
+		switch scalar.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+		default:
+			if numeric {
+				add("minimum, maximum, exclusiveMin, exclusiveMax, and multipleOf are only supported for numbers, not %s", fieldType)
+			}
+			if tags.NumberFormat != "" {
+				add("numberFormat= is only supported for numbers, not %s", fieldType)
+			}
+		}
+		if scalar.Kind() != reflect.String &&
+			(tags.Format != "" || tags.MinLength != nil || tags.MaxLength != nil || tags.ByteLength) {
+			add("format, minLength, maxLength, and byteLength are only supported for strings, not %s", fieldType)
+		}
+	}
+	return problems
+}
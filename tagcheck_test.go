@@ -0,0 +1,86 @@
+package nvelope_test
+
+import (
+	"math/big"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/muir/nvelope"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateTags(t *testing.T) {
+	type bad struct {
+		Typo      string            `nvelope:"quer,name=typo"`
+		Deep      map[string]string `nvelope:"header,name=deep,deepObject=true"`
+		DeepInt   int               `nvelope:"query,name=deepInt,deepObject=true"`
+		Delimited []string          `nvelope:"path,name=delimited,delimiter=pipe"`
+		Exploded  []string          `nvelope:"path,name=exploded,explode=true"`
+		Form      string            `nvelope:"header,name=form,form=true"`
+		Scheme    string            `nvelope:"query,name=scheme,scheme=Bearer"`
+		Min       string            `nvelope:"query,name=min,minimum=3"`
+		Big       big.Int           `nvelope:"query,name=big,maximum=3"`
+		Length    int               `nvelope:"query,name=length,maxLength=3"`
+		Encoding  string            `nvelope:"query,name=encoding,encoding=base64"`
+		Presence  string            `nvelope:"query,name=presence,presence=true"`
+		Style     string            `nvelope:"query,name=style,style=fancy"`
+		BoolStyle int               `nvelope:"query,name=boolStyle,boolStyle=lenient"`
+		Exact     []int             `nvelope:"query,name=exact,exactItems=true"`
+		Grouped   []string          `nvelope:"query,name=grouped,numberFormat=grouped"`
+	}
+	var m bad
+	err := nvelope.DecodeRequest(httptest.NewRequest("GET", "/", nil), &m, nvelope.ValidateTags(true))
+	require.Error(t, err)
+	for _, want := range []string{
+		"unsupported nvelope tags in nvelope_test.bad:\n",
+		"\tTypo: 'quer' is not a known parameter kind",
+		"\tDeep: deepObject=true is only supported for query, not header",
+		"\tDeepInt: deepObject=true is only supported for maps, structs, slices, and arrays, not int",
+		"\tDelimited: delimiter= is only supported for query, not path",
+		"\tExploded: explode=true is only supported for query, header, and cookie, not path",
+		"\tForm: form=true is only supported for query, not header",
+		"\tScheme: scheme= is only supported for header, not query",
+		"\tMin: minimum, maximum, exclusiveMin, exclusiveMax, and multipleOf are only supported for numbers, not string",
+		"\tBig: minimum, maximum, exclusiveMin, exclusiveMax, and multipleOf are only supported for numbers, not big.Int",
+		"\tLength: format, minLength, maxLength, and byteLength are only supported for strings, not int",
+		"\tEncoding: encoding=base64 requires content=",
+		"\tPresence: presence=true requires a bool, not string",
+		"\tStyle: style=fancy is not supported",
+		"\tBoolStyle: boolStyle= is only supported for bools, not int",
+		"\tExact: exactItems=true requires an array, not []int",
+		"\tGrouped: numberFormat= is only supported for numbers, not []string",
+	} {
+		assert.Contains(t, err.Error(), want)
+	}
+
+	type good struct {
+		ID      int               `nvelope:"path,name=id,minimum=1"`
+		Tags    []string          `nvelope:"query,name=tags,delimiter=pipe,explode=false,maxLength=10"`
+		Filter  map[string]string `nvelope:"query,name=filter,deepObject=true"`
+		Auth    string            `nvelope:"header,name=Authorization,scheme=Bearer"`
+		Simple  []string          `nvelope:"header,name=X-Simple,style=simple"`
+		Cookies map[string]string `nvelope:"cookie,name=c,explode=true"`
+		Data    *struct {
+			A int `json:"a"`
+		} `nvelope:"query,name=data,content=application/json,encoding=base64"`
+		Verbose bool   `nvelope:"query,name=verbose,presence=true"`
+		Debug   bool   `nvelope:"header,name=X-Debug,boolStyle=lenient"`
+		Pair    [2]int `nvelope:"query,name=pair,exactItems=true"`
+		Ignored int    `nvelope:"-"`
+	}
+	var g good
+	assert.NoError(t, nvelope.DecodeRequest(httptest.NewRequest("GET", "/", nil), &g,
+		nvelope.ValidateTags(true),
+		nvelope.WithPathVarsFunction(func() nvelope.RouteVarLookup {
+			return func(string) string { return "1" }
+		})))
+
+	var quiet struct {
+		Typo string `nvelope:"quer,name=typo"`
+		Form string `nvelope:"header,name=form,form=true"`
+	}
+	err = nvelope.DecodeRequest(httptest.NewRequest("GET", "/", nil), &quiet)
+	assert.NoError(t, err, "without ValidateTags, ignored options are not reported")
+}
@@ -0,0 +1,112 @@
+package nvelope
+
+import (
+	"github.com/muir/reflectutils"
+)
+
+// Tags is the parsed form of an nvelope struct tag.  It is
+// provided so that packages that build on nvelope, like decoders
+// for routers, can interpret tags the same way GenerateDecoder does.
+// See GenerateDecoder for the meaning of each option.
+type Tags struct {
+	// Base is the first element of the tag: "model", "path",
+	// "query", "header", "cookie" or, for fields inside a
+	// struct, the name of the field.
+	Base string
+	// Name is the name of the parameter.  It is empty if not set.
+	Name string
+	// Explode has already been defaulted based on Base: true for
+	// query and header, false otherwise.
+	Explode bool
+	// Delimiter has already had aliases like "pipe" and styles
+	// like "spaceDelimited" resolved.  It defaults to ",".
+	Delimiter     string
+	Style         string
+	AllowReserved bool
+	Form          bool
+	FormOnly      bool
+	Content       string
+	DeepObject    bool
+	Default       string
+	Fold          bool
+	Scheme        string
+	Minimum       *float64
+	Maximum       *float64
+	ExclusiveMin  *float64
+	ExclusiveMax  *float64
+	MultipleOf    *float64
+	Format        string
+	MinLength     *int
+	MaxLength     *int
+	ByteLength    bool
+	Required      bool
+	Presence      bool
+	Prefix        string
+	RejectUnknown *bool
+	// Join has already had aliases like "comma" resolved
+	Join     string
+	ErrMsg   string
+	Encoding string
+	// Layout is not interpreted by nvelope.  It is meant for
+	// decoders registered with WithTagDecoder.
+	Layout string
+	// BoolStyle is "", "strict", or "lenient"
+	BoolStyle  string
+	JWT        bool
+	ExactItems bool
+	// NumberFormat is "", "strict", or "grouped"
+	NumberFormat string
+}
+
+// ParseNvelopeTag parses the value of an nvelope struct tag, for
+// example "query,name=ids,explode=false".  Defaults are applied as
+// they are by GenerateDecoder.
+func ParseNvelopeTag(tag string) (Tags, error) {
+	tags, err := parseTag(reflectutils.Tag{
+		Tag:   "nvelope",
+		Value: tag,
+	})
+	if err != nil {
+		return Tags{}, err
+	}
+	return tags.export(), nil
+}
+
+func (tags tags) export() Tags {
+	return Tags{
+		Base:          tags.Base,
+		Name:          tags.Name,
+		Explode:       tags.Explode,
+		Delimiter:     tags.Delimiter,
+		Style:         tags.Style,
+		AllowReserved: tags.AllowReserved,
+		Form:          tags.Form,
+		FormOnly:      tags.FormOnly,
+		Content:       tags.Content,
+		DeepObject:    tags.DeepObject,
+		Default:       tags.Default,
+		Fold:          tags.Fold,
+		Scheme:        tags.Scheme,
+		Minimum:       tags.Minimum,
+		Maximum:       tags.Maximum,
+		ExclusiveMin:  tags.ExclusiveMin,
+		ExclusiveMax:  tags.ExclusiveMax,
+		MultipleOf:    tags.MultipleOf,
+		Format:        tags.Format,
+		MinLength:     tags.MinLength,
+		MaxLength:     tags.MaxLength,
+		ByteLength:    tags.ByteLength,
+		Required:      tags.Required,
+		Presence:      tags.Presence,
+		Prefix:        tags.Prefix,
+		RejectUnknown: tags.RejectUnknown,
+		Join:          tags.Join,
+		ErrMsg:        tags.ErrMsg,
+		Encoding:      tags.Encoding,
+		Layout:        tags.Layout,
+		BoolStyle:     tags.BoolStyle,
+		JWT:           tags.JWT,
+		ExactItems:    tags.ExactItems,
+		NumberFormat:  tags.NumberFormat,
+	}
+}
@@ -0,0 +1,86 @@
+package nvelope_test
+
+import (
+	"testing"
+
+	"github.com/muir/nvelope"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNvelopeTag(t *testing.T) {
+	f := func(v float64) *float64 { return &v }
+	n := func(v int) *int { return &v }
+	b := func(v bool) *bool { return &v }
+	cases := []struct {
+		tag  string
+		want nvelope.Tags
+	}{
+		{"model", nvelope.Tags{Base: "model", Delimiter: ","}},
+		{"path,name=id", nvelope.Tags{Base: "path", Name: "id", Delimiter: ","}},
+		{"path,name=id,explode=true", nvelope.Tags{Base: "path", Name: "id", Explode: true, Delimiter: ","}},
+		{"cookie,name=c", nvelope.Tags{Base: "cookie", Name: "c", Delimiter: ","}},
+		{"query,name=q", nvelope.Tags{Base: "query", Name: "q", Explode: true, Delimiter: ","}},
+		{"query,name=q,explode=false", nvelope.Tags{Base: "query", Name: "q", Delimiter: ","}},
+		{"header,name=X-H", nvelope.Tags{Base: "header", Name: "X-H", Explode: true, Delimiter: ","}},
+		{"query,delimiter=comma", nvelope.Tags{Base: "query", Explode: true, Delimiter: ","}},
+		{"query,delimiter=pipe", nvelope.Tags{Base: "query", Explode: true, Delimiter: "|"}},
+		{"query,delimiter=space", nvelope.Tags{Base: "query", Explode: true, Delimiter: " "}},
+		{"query,delimiter=semicolon", nvelope.Tags{Base: "query", Explode: true, Delimiter: ";"}},
+		{"query,delimiter=tab", nvelope.Tags{Base: "query", Explode: true, Delimiter: "\t"}},
+		{"query,delimiter=%3A", nvelope.Tags{Base: "query", Explode: true, Delimiter: ":"}},
+		{"query,style=form", nvelope.Tags{Base: "query", Explode: true, Delimiter: ",", Style: "form"}},
+		{"query,style=form,delimiter=comma", nvelope.Tags{Base: "query", Explode: true, Delimiter: ",", Style: "form"}},
+		{"query,style=pipeDelimited,delimiter=pipe", nvelope.Tags{Base: "query", Explode: true, Delimiter: "|", Style: "pipeDelimited"}},
+		{"query,style=pipeDelimited", nvelope.Tags{Base: "query", Explode: true, Delimiter: "|", Style: "pipeDelimited"}},
+		{"query,style=spaceDelimited", nvelope.Tags{Base: "query", Explode: true, Delimiter: " ", Style: "spaceDelimited"}},
+		{"query,style=deepObject", nvelope.Tags{Base: "query", Explode: true, Delimiter: ",", Style: "deepObject", DeepObject: true}},
+		{"query,deepObject=true", nvelope.Tags{Base: "query", Explode: true, Delimiter: ",", DeepObject: true}},
+		{"query,form=true,allowReserved=true", nvelope.Tags{Base: "query", Explode: true, Delimiter: ",", Form: true, AllowReserved: true}},
+		{"query,formOnly", nvelope.Tags{Base: "query", Explode: true, Delimiter: ",", FormOnly: true}},
+		{"query,content=application/json", nvelope.Tags{Base: "query", Explode: true, Delimiter: ",", Content: "application/json"}},
+		{"query,default=7,minimum=1,maximum=10", nvelope.Tags{Base: "query", Explode: true, Delimiter: ",", Default: "7", Minimum: f(1), Maximum: f(10)}},
+		{"query,exclusiveMin=0,exclusiveMax=1.5", nvelope.Tags{Base: "query", Explode: true, Delimiter: ",", ExclusiveMin: f(0), ExclusiveMax: f(1.5)}},
+		{"query,multipleOf=0.5", nvelope.Tags{Base: "query", Explode: true, Delimiter: ",", MultipleOf: f(0.5)}},
+		{"query,format=email", nvelope.Tags{Base: "query", Explode: true, Delimiter: ",", Format: "email"}},
+		{"query,minLength=1,maxLength=8,byteLength=true", nvelope.Tags{Base: "query", Explode: true, Delimiter: ",", MinLength: n(1), MaxLength: n(8), ByteLength: true}},
+		{"query,required=true", nvelope.Tags{Base: "query", Explode: true, Delimiter: ",", Required: true}},
+		{"query,errMsg=try again", nvelope.Tags{Base: "query", Explode: true, Delimiter: ",", ErrMsg: "try again"}},
+		{"query,content=application/json,encoding=base64+gzip", nvelope.Tags{Base: "query", Explode: true, Delimiter: ",", Content: "application/json", Encoding: "base64+gzip"}},
+		{"query,content=application/date,layout=2006-01-02", nvelope.Tags{Base: "query", Explode: true, Delimiter: ",", Content: "application/date", Layout: "2006-01-02"}},
+		{"query,join=pipe", nvelope.Tags{Base: "query", Explode: true, Delimiter: ",", Join: "|"}},
+		{"header,presence=true", nvelope.Tags{Base: "header", Explode: true, Delimiter: ",", Presence: true}},
+		{"options,rejectUnknown=false", nvelope.Tags{Base: "options", Delimiter: ",", RejectUnknown: b(false)}},
+		{"header,prefix=X-Meta-", nvelope.Tags{Base: "header", Explode: true, Delimiter: ",", Prefix: "X-Meta-"}},
+		{"header,name=Accept,fold=true", nvelope.Tags{Base: "header", Name: "Accept", Explode: true, Delimiter: ",", Fold: true}},
+		{"header,name=Authorization,scheme=Bearer", nvelope.Tags{Base: "header", Name: "Authorization", Explode: true, Delimiter: ",", Scheme: "Bearer"}},
+		{"query,boolStyle=lenient", nvelope.Tags{Base: "query", Explode: true, Delimiter: ",", BoolStyle: "lenient"}},
+		{"header,name=Authorization,scheme=Bearer,jwt=true", nvelope.Tags{Base: "header", Name: "Authorization", Explode: true, Delimiter: ",", Scheme: "Bearer", JWT: true}},
+		{"query,exactItems=true", nvelope.Tags{Base: "query", Explode: true, Delimiter: ",", ExactItems: true}},
+		{"query,numberFormat=grouped", nvelope.Tags{Base: "query", Explode: true, Delimiter: ",", NumberFormat: "grouped"}},
+		{"eint", nvelope.Tags{Base: "eint", Delimiter: ","}},
+	}
+	for _, tc := range cases {
+		got, err := nvelope.ParseNvelopeTag(tc.tag)
+		if assert.NoError(t, err, tc.tag) {
+			assert.Equal(t, tc.want, got, tc.tag)
+		}
+	}
+}
+
+func TestParseNvelopeTagErrors(t *testing.T) {
+	for _, tag := range []string{
+		"query,style=matrix",
+		"query,style=pipeDelimited,delimiter=space",
+		"query,style=pipeDelimited,delimiter=comma",
+		"query,style=spaceDelimited,delimiter=%2C",
+		"query,delimiter=%zz",
+		"query,minimum=low",
+		"query,boolStyle=loose",
+		"query,numberFormat=european",
+	} {
+		_, err := nvelope.ParseNvelopeTag(tag)
+		require.Error(t, err, tag)
+	}
+}
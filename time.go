@@ -0,0 +1,133 @@
+package nvelope
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// FlexibleTimeLayouts are the layouts that FlexibleTime tries, in order,
+// when parsing a string.  Layouts without a time zone are parsed as UTC.
+var FlexibleTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC850,
+	time.ANSIC,
+}
+
+// FlexibleTime is a time.Time that can be decoded from most of the
+// common ways that clients send times: any of the FlexibleTimeLayouts,
+// or a JSON number of seconds since the Unix epoch.  Since json.Unmarshal
+// does not look at struct tags other than "json", use FlexibleTime (or
+// Date) for time fields inside request bodies that are not RFC3339.
+// It implements encoding.TextUnmarshaler so it can also be used for
+// query, path, header, and cookie parameters.
+//
+// FlexibleTime is encoded as RFC3339.
+//
+//	type Filter struct {
+//		Since nvelope.FlexibleTime `json:"since"`
+//		Day   nvelope.Date         `json:"day"`
+//	}
+type FlexibleTime struct {
+	time.Time
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (t *FlexibleTime) UnmarshalText(b []byte) error {
+	s := strings.TrimSpace(string(b))
+	for _, layout := range FlexibleTimeLayouts {
+		parsed, err := time.Parse(layout, s)
+		if err == nil {
+			t.Time = parsed
+			return nil
+		}
+	}
+	return errors.Errorf("cannot parse '%s' as a time", s)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.  JSON null leaves
+// the time unchanged.
+func (t *FlexibleTime) UnmarshalJSON(b []byte) error {
+	b = bytes.TrimSpace(b)
+	switch {
+	case bytes.Equal(b, []byte("null")):
+		return nil
+	case len(b) > 0 && b[0] == '"':
+		var s string
+		if err := json.Unmarshal(b, &s); err != nil {
+			return errors.WithStack(err)
+		}
+		return t.UnmarshalText([]byte(s))
+	default:
+		secs, err := strconv.ParseFloat(string(b), 64)
+		if err != nil {
+			return errors.Errorf("cannot parse %s as a time", string(b))
+		}
+		whole := int64(secs)
+		t.Time = time.Unix(whole, int64((secs-float64(whole))*1e9)).UTC()
+		return nil
+	}
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (t FlexibleTime) MarshalText() ([]byte, error) {
+	return t.Time.MarshalText()
+}
+
+// MarshalJSON implements json.Marshaler
+func (t FlexibleTime) MarshalJSON() ([]byte, error) {
+	return t.Time.MarshalJSON()
+}
+
+// DateLayout is the layout used by Date
+const DateLayout = "2006-01-02"
+
+// Date is a time.Time that is decoded from and encoded as a calendar
+// date: "2006-01-02".  It implements encoding.TextUnmarshaler so it can
+// be used for parameters as well as in request bodies.
+type Date struct {
+	time.Time
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (d *Date) UnmarshalText(b []byte) error {
+	parsed, err := time.Parse(DateLayout, strings.TrimSpace(string(b)))
+	if err != nil {
+		return errors.Wrap(err, "date")
+	}
+	d.Time = parsed
+	return nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler.  JSON null leaves
+// the date unchanged.
+func (d *Date) UnmarshalJSON(b []byte) error {
+	if bytes.Equal(bytes.TrimSpace(b), []byte("null")) {
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return errors.WithStack(err)
+	}
+	return d.UnmarshalText([]byte(s))
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (d Date) MarshalText() ([]byte, error) {
+	return []byte(d.Format(DateLayout)), nil
+}
+
+// MarshalJSON implements json.Marshaler
+func (d Date) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + d.Format(DateLayout) + `"`), nil
+}
@@ -0,0 +1,85 @@
+package nvelope_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/muir/nvelope"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlexibleTime(t *testing.T) {
+	want := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	cases := []struct {
+		input string
+		want  time.Time
+	}{
+		{input: `"2021-03-04T05:06:07Z"`, want: want},
+		{input: `"2021-03-04T05:06:07.25Z"`, want: want.Add(250 * time.Millisecond)},
+		{input: `"2021-03-04T05:06:07"`, want: want},
+		{input: `"2021-03-04 05:06:07"`, want: want},
+		{input: `"2021-03-04"`, want: time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)},
+		{input: `"Thu, 04 Mar 2021 05:06:07 GMT"`, want: want},
+		{input: `1614834367`, want: want},
+		{input: `1614834367.5`, want: want.Add(500 * time.Millisecond)},
+	}
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.input, func(t *testing.T) {
+			var got struct {
+				When nvelope.FlexibleTime `json:"when"`
+			}
+			require.NoError(t, json.Unmarshal([]byte(`{"when":`+tc.input+`}`), &got))
+			assert.True(t, tc.want.Equal(got.When.Time), "got %s", got.When.Time)
+		})
+	}
+
+	var bad nvelope.FlexibleTime
+	assert.Error(t, json.Unmarshal([]byte(`"yesterday"`), &bad))
+	assert.Error(t, json.Unmarshal([]byte(`true`), &bad))
+
+	kept := nvelope.FlexibleTime{Time: want}
+	require.NoError(t, json.Unmarshal([]byte(`null`), &kept))
+	assert.True(t, want.Equal(kept.Time), "null leaves the time unchanged")
+
+	enc, err := json.Marshal(nvelope.FlexibleTime{Time: want})
+	require.NoError(t, err)
+	assert.Equal(t, `"2021-03-04T05:06:07Z"`, string(enc))
+}
+
+func TestDate(t *testing.T) {
+	var got struct {
+		Day nvelope.Date `json:"day"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(`{"day":"2021-03-04"}`), &got))
+	assert.Equal(t, time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC), got.Day.Time)
+	enc, err := json.Marshal(got)
+	require.NoError(t, err)
+	assert.Equal(t, `{"day":"2021-03-04"}`, string(enc))
+	assert.Error(t, json.Unmarshal([]byte(`{"day":"2021-03-04T05:06:07Z"}`), &got))
+	assert.Error(t, json.Unmarshal([]byte(`{"day":20210304}`), &got))
+}
+
+func TestDecodeFlexibleTime(t *testing.T) {
+	do := captureOutput("/x/{day}", func(s struct {
+		Day   nvelope.Date         `nvelope:"path,name=day"`
+		Since nvelope.FlexibleTime `nvelope:"query,name=since"`
+		Body  struct {
+			Until nvelope.FlexibleTime `json:"until"`
+		} `nvelope:"model"`
+	},
+	) (nvelope.Response, error) {
+		return map[string]string{
+			"day":   s.Day.Format(time.RFC3339),
+			"since": s.Since.Format(time.RFC3339),
+			"until": s.Body.Until.Format(time.RFC3339),
+		}, nil
+	})
+	assert.Equal(t,
+		`200->{"day":"2021-03-04T00:00:00Z","since":"2021-03-01T00:00:00Z","until":"2021-03-05T12:00:00Z"}`,
+		do("/x/2021-03-04?since=2021-03-01", body(`{"until":"2021-03-05 12:00:00"}`)))
+	assert.Equal(t, "400->", do("/x/2021-03-04T01:02:03Z", body(`{}`))[:5])
+}